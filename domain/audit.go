@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// actions of the audit records
+const (
+	AuditCreate  string = "create"
+	AuditUpdate  string = "update"
+	AuditDelete  string = "delete"
+	AuditComment string = "comment"
+	AuditStatus  string = "status"
+	AuditAttach  string = "attach"
+)
+
+// AuditRecord - change of the resource made through the api
+type AuditRecord struct {
+	ID      int64     `json:"id"`
+	Changed time.Time `json:"changed"`
+	// Actor author of the change from the token claims
+	Actor     string `json:"actor"`
+	RequestID string `json:"requestId,omitempty"`
+	// Endpoint method and route of the api, example: PUT /v2/videochecks/configs/:pid
+	Endpoint string `json:"endpoint"`
+	// Resource kind and id of the changed resource, example: videocheck/1234
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	// Before, After state of the resource, null if resource doesn't exist
+	Before json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After  json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	Diff   []AuditChange   `json:"diff,omitempty"`
+}
+
+// AuditChange - changed field of the resource, path of the nested fields is joined by dot
+type AuditChange struct {
+	Path   string          `json:"path"`
+	Before json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After  json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// AuditFilter conditions of the audit search, empty fields are not used
+type AuditFilter struct {
+	// Resource kind (videocheck) or kind and id (videocheck/1234) of the resource
+	Resource string
+	Actor    string
+	Since    time.Time
+	// AfterID records after the id, keyset paging
+	AfterID int64
+}
+
+// Match returns true if record matches the filter
+func (f AuditFilter) Match(r AuditRecord) bool {
+	return r.ID > f.AfterID && (f.Actor == "" || r.Actor == f.Actor) && !r.Changed.Before(f.Since) &&
+		(f.Resource == "" || r.Resource == f.Resource || strings.HasPrefix(r.Resource, f.Resource+"/"))
+}
+
+// AuditRepo persisted append-only log of the changes
+type AuditRepo interface {
+	// Append stores record and fills its id
+	Append(context.Context, *AuditRecord) error
+	// Find returns records of the filter ordered by id, up to limit
+	Find(context.Context, AuditFilter, int64) ([]AuditRecord, error)
+}
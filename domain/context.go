@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDKey name of the http request id in the logs and the headers of the http log
+const RequestIDKey string = "request_id"
+
+// ctxKey type of the context keys of the domain
+type ctxKey int
+
+const ctxRequestID ctxKey = iota
+
+// WithRequestID returns context with http request id, repositories use it in the logs
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, ctxRequestID, requestID)
+}
+
+// RequestID returns http request id of the context or empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(ctxRequestID).(string)
+	return requestID
+}
+
+// RequestLogger returns logger with request id of the context, so logs of the handlers and the repositories
+// are traced by the request
+func RequestLogger(ctx context.Context, logger *zap.SugaredLogger) *zap.SugaredLogger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return logger.With(zap.String(RequestIDKey, requestID))
+	}
+	return logger
+}
@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Device - counting device (controller) registered in the CountMax project database
+type Device struct {
+	SN        string    `json:"sn"`
+	ProjectID int64     `json:"projectId"`
+	LayoutID  string    `json:"layoutId"`
+	Zone      string    `json:"zone"`
+	Enter     string    `json:"enter"`
+	Model     string    `json:"model"`
+	LastSeen  time.Time `json:"lastSeen"`
+	IsOnline  bool      `json:"isOnline"`
+}
+
+type Devices []Device
+
+// DeviceRepo behavior of the devices repository,
+// devices are stored in the own database of every project
+type DeviceRepo interface {
+	// FindDevices returns page of project devices, empty layoutID means all layouts
+	FindDevices(context.Context, Project, string, int64, int64) (Devices, int64, error)
+	FindDeviceBySN(context.Context, Project, string) (*Device, error)
+}
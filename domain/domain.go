@@ -1,9 +1,15 @@
 package domain
 
 import (
+	"context"
+	"errors"
+	"io"
 	"time"
 )
 
+// ErrAlreadyExists error of the creation of the existing resource
+var ErrAlreadyExists error = errors.New("already exists")
+
 // CustomerConfig - entity of customer, business object
 type CustomerConfig struct {
 	CustomerID     int64  `json:"customerId"`
@@ -16,6 +22,9 @@ type CustomerConfig struct {
 
 type CustomerConfigs []CustomerConfig
 
+// CustomerConfigChange returns new service desk parameters for the current config
+type CustomerConfigChange func(CustomerConfig) CustomerConfig
+
 // Project - entity of project, business object
 type Project struct {
 	ID          int64  `json:"id"`
@@ -86,53 +95,112 @@ type ManualCountings []ManualCounting
 
 // VideocheckConfig propertie of videocheck parameters
 type VideocheckConfig struct {
-	ProjectID   int64  `json:"projectId"`
-	LocalServer bool   `json:"localServer"`
-	LocalCam    bool   `json:"localCam"`
-	LocalFtp    bool   `json:"localFtp"`
-	Options     string `json:"options"`
+	ProjectID   int64 `json:"projectId"`
+	LocalServer bool  `json:"localServer"`
+	LocalCam    bool  `json:"localCam"`
+	LocalFtp    bool  `json:"localFtp"`
+	// Options json object, validated by VideocheckOptionsSchema
+	Options VideocheckOptions `json:"options" swaggertype:"object"`
 }
 
 type VideocheckConfigs []VideocheckConfig
 
+// VideocheckChange returns new configs by ids for the current configs by ids
+type VideocheckChange func(current map[int64]*VideocheckConfig) (map[int64]*VideocheckConfig, error)
+
 type CustomerRepo interface {
-	FindCustomersConfig(int64, int64, bool) (CustomerConfigs, int64, error)
-	FindCustomerConfig(int64) (*CustomerConfig, error)
-	FindProjects(int64, int64, bool) (Projects, int64, error)
-	FindProjectByID(int64, *string) (*Project, error)
-	FindFTPByID(int64) (*FTPinfo, error)
-	FindManualCountings(int64, int64, int64, int64) (ManualCountings, int64, error)
-	FindVideoChechCfgs(int64, int64) (VideocheckConfigs, int64, error)
-	FindVideoCheckCfgByID(int64) (*VideocheckConfig, error)
-	StoreVideoCheckCfg(VideocheckConfig) error
-	UpSertVideoCheckCfg(VideocheckConfig) error
-	DeleteVideoCheckCfgByID(int64) (int64, error)
-	Health() error
+	FindCustomersConfig(context.Context, ListQuery, int64, int64, bool) (CustomerConfigs, int64, error)
+	FindCustomerConfig(context.Context, int64) (*CustomerConfig, error)
+	// StoreCustomerConfig, UpdateCustomerConfig, DeleteCustomerConfig change service desk parameters
+	// of the customer config, store returns ErrAlreadyExists for the existing config, update applies change
+	// to the locked config and returns configs before and after the change, update and delete return nil
+	// if the config isn't found
+	StoreCustomerConfig(context.Context, CustomerConfig) error
+	UpdateCustomerConfig(context.Context, int64, CustomerConfigChange) (*CustomerConfig, *CustomerConfig, error)
+	DeleteCustomerConfig(context.Context, int64) (*CustomerConfig, error)
+	FindProjects(context.Context, ListQuery, int64, int64, bool) (Projects, int64, error)
+	FindProjectByID(context.Context, int64, *string) (*Project, error)
+	FindFTPByID(context.Context, int64) (*FTPinfo, error)
+	FindManualCountings(context.Context, int64, int64, int64, int64) (ManualCountings, int64, error)
+	FindVideoChechCfgs(context.Context, ListQuery, int64, int64) (VideocheckConfigs, int64, error)
+	FindVideoCheckCfgByID(context.Context, int64) (*VideocheckConfig, error)
+	StoreVideoCheckCfg(context.Context, VideocheckConfig) error
+	UpSertVideoCheckCfg(context.Context, VideocheckConfig) error
+	DeleteVideoCheckCfgByID(context.Context, int64) (int64, error)
+	// ChangeVideoCheckCfgs locks configs of the ids (found ones are passed to change by ids) and writes
+	// configs returned by change in one transaction, nil config deletes it, error of change writes nothing
+	ChangeVideoCheckCfgs(context.Context, []int64, VideocheckChange) error
+	// WithLock runs function holding the exclusive lock of the key in CM_INFO, so instances of the service
+	// sharing CM_INFO run it one by one, the lock is released after the function
+	WithLock(context.Context, string, func(context.Context) error) error
+	Health(context.Context) error
 }
 
 // [ Intraservice DataBase ]
 
 // Asset - entity of asset, bisness object
 type Asset struct {
-	ID                  int64  `json:"id"`
-	Name                string `json:"name"`
-	ServiceDeskParentID int64  `json:"serviceDeskParentId"`
-	Changed             string `json:"changed"`
-	ServiceDeskID       int64  `json:"serviceDeskId"`
+	ID                  int64     `json:"id"`
+	Name                string    `json:"name"`
+	ServiceDeskParentID int64     `json:"serviceDeskParentId"`
+	Changed             time.Time `json:"changed"`
+	ServiceDeskID       int64     `json:"serviceDeskId"`
+	// Fields custom fields of the asset by names of the AssetFields, not mapped fields by id as strings
+	Fields map[string]interface{} `json:"fields,omitempty"`
 }
 
 type Assets []Asset
 
+// types of the values of the asset custom fields
+const (
+	AssetFieldString string = "string"
+	AssetFieldInt    string = "int"
+	AssetFieldFloat  string = "float"
+	AssetFieldDate   string = "date"
+	AssetFieldBool   string = "bool"
+)
+
+// AssetField custom field of the Intraservice asset: id of the field in the XML Data and type of the value
+type AssetField struct {
+	ID   int64  `mapstructure:"id"`
+	Type string `mapstructure:"type"`
+}
+
+// AssetFields mapping of the names of the asset custom fields, name -> field
+type AssetFields map[string]AssetField
+
+// AssetFieldPrefix prefix of the custom fields in the filters of the assets: field.serial
+const AssetFieldPrefix string = "field."
+
+// AssetNode - asset in the hierarchy of the Intraservice assets (customer -> object -> device),
+// assets are linked by serviceDeskId and serviceDeskParentId, id is 0 for the assets without CountMax id
+type AssetNode struct {
+	Asset
+	// Depth distance from the requested asset, 0 - requested asset itself
+	Depth    int        `json:"depth"`
+	Children AssetNodes `json:"children,omitempty"`
+}
+
+type AssetNodes []AssetNode
+
 // AssetRepo vehavior of the Asset repository
 type AssetRepo interface {
-	FindAll(int64, int64) (Assets, int64, error)
-	FindByID(int64) (*Asset, error)
-	Health() error
+	FindAll(context.Context, ListQuery, int64, int64) (Assets, int64, error)
+	FindByID(context.Context, int64) (*Asset, error)
+	// FindDescendants returns asset with serviceDeskId and its descendants up to depth ordered by depth,
+	// serviceDeskId 0 - top-level assets without parent
+	FindDescendants(context.Context, int64, int) (AssetNodes, error)
+	// FindAncestors returns asset with serviceDeskId and its parents up to depth ordered from the asset to the root
+	FindAncestors(context.Context, int64, int) (AssetNodes, error)
+	// FindChanged returns up to limit assets changed after time ordered by changed and serviceDeskId,
+	// serviceDeskId > 0 - assets changed at the time with greater serviceDeskId are included (keyset paging)
+	FindChanged(context.Context, time.Time, int64, int64) (Assets, error)
+	Health(context.Context) error
 }
 
 // [ Intraservice API ]
 
-// ControllerTask - json string with Task from serviceDesk
+// ControllerTask - json string with Task from serviceDesk, legacy format of the tasks (format=raw)
 type ControllerTask struct {
 	Task string `json:"task"`
 }
@@ -155,10 +223,27 @@ type TaskComment struct {
 
 // SDRepo behavior of the ServiceDesk repository
 type SDRepo interface {
-	FindAllBySN(string, int64, int64) (ControllerTasks, int64, error)
-	Health() error
-	TaskAddComment(string, string) error
-	TaskSetStatus(string, TaskStatus) error
+	// FindAllBySN returns page of the tasks of the controller by serial number, offset and limit
+	FindAllBySN(context.Context, string, TaskFilter, int64, int64) (Tasks, int64, error)
+	// FindTaskByID returns task by id, nil if task not found
+	FindTaskByID(context.Context, int64) (*Task, error)
+	// TaskLifetime returns comments and status transitions of the task, nil if task not found
+	TaskLifetime(context.Context, int64) (*TaskLifetime, error)
+	// FindDictionary returns items of the dictionary SDStatuses, SDPriorities...
+	FindDictionary(context.Context, string) (SDReferences, error)
+	// CreateTask creates task with title, description, service, creator and custom fields of the Task
+	CreateTask(context.Context, Task) (*Task, error)
+	// TaskFiles returns files attached to the task
+	TaskFiles(context.Context, int64) (TaskFiles, error)
+	// TaskFile returns file of the task with content, caller closes content, nil if file not found
+	TaskFile(context.Context, int64, int64) (*TaskFile, io.ReadCloser, error)
+	// AttachTaskFile streams content to the task as file with name and content type
+	AttachTaskFile(context.Context, int64, string, string, io.Reader) (*TaskFile, error)
+	Health(context.Context) error
+	TaskAddComment(context.Context, string, string) error
+	TaskSetStatus(context.Context, string, TaskStatus) error
+	ServiceExists(context.Context, int64) (bool, error)
+	UserExists(context.Context, int64) (bool, error)
 }
 
 // [ references ]
@@ -173,7 +258,7 @@ type Entities []Entity
 
 // RefRepo repository od reference data
 type RefRepo interface {
-	FindEntities(int64, int64) (Entities, int64, error)
-	FindEntityByID(string) (*Entity, error)
-	Health() error
+	FindEntities(context.Context, ListQuery, int64, int64) (Entities, int64, error)
+	FindEntityByID(context.Context, string) (*Entity, error)
+	Health(context.Context) error
 }
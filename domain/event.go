@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// types of the task events
+const (
+	EventTaskCreated       string = "task.created"
+	EventTaskStatusChanged string = "task.status_changed"
+	EventTaskCommentAdded  string = "task.comment_added"
+)
+
+// TaskEvent - change of the ServiceDesk task normalized from the notification of the ServiceDesk
+type TaskEvent struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	TaskID int64  `json:"taskId"`
+	// SN serial number of the controller of the task, empty if task isn't tied to the controller
+	SN      string `json:"sn,omitempty"`
+	AssetID int64  `json:"assetId,omitempty"`
+	// StatusID, PrevStatusID current and previous status of the task.status_changed
+	StatusID     int64     `json:"statusId,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	PrevStatusID int64     `json:"prevStatusId,omitempty"`
+	Comment      string    `json:"comment,omitempty"`
+	Author       string    `json:"author,omitempty"`
+	Occurred     time.Time `json:"occurred"`
+}
+
+type TaskEvents []TaskEvent
+
+// Subscriber - receiver of the task events by http callback
+type Subscriber struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret key of the HMAC-SHA256 signature of the callback body, empty - callbacks aren't signed
+	Secret string `json:"-"`
+	// Events types of the events, empty - all events
+	Events []string `json:"events"`
+}
+
+// Accepts returns true if subscriber receives events of the type
+func (s Subscriber) Accepts(eventType string) bool {
+	return len(s.Events) == 0 || ListFields(s.Events).Has(eventType)
+}
+
+// OutboxMessage - event waiting for delivery to the subscriber
+type OutboxMessage struct {
+	ID          int64     `json:"id"`
+	Subscriber  string    `json:"subscriber"`
+	Event       TaskEvent `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	// Dead delivery stopped after max attempts
+	Dead bool `json:"dead,omitempty"`
+	// Delivered time of the successful delivery, nil while message isn't delivered
+	Delivered *time.Time `json:"delivered,omitempty"`
+}
+
+// Outbox persisted queue of the events for the subscribers
+type Outbox interface {
+	// Enqueue stores messages, fills their ids and returns count of the stored messages,
+	// message of the event already queued for the subscriber is skipped and its id stays zero
+	Enqueue(context.Context, []OutboxMessage) (int, error)
+	// Pending returns not delivered and not dead messages with next attempt before time, up to limit
+	Pending(context.Context, time.Time, int) ([]OutboxMessage, error)
+	// Update saves results of the delivery attempts of the messages at once
+	Update(context.Context, []OutboxMessage) error
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"strings"
 )
 
@@ -52,3 +53,13 @@ type CRMCustomer struct {
 	ID    string `json:"id"`
 	Title string `json:"title"`
 }
+
+// LayoutRepo behavior of the Layout repository
+type LayoutRepo interface {
+	// FindLayoutIDs returns all layouts with filled LayoutID only, source for the Layouts.ACL
+	FindLayoutIDs(context.Context) (Layouts, error)
+	// FindLayouts returns page of layouts from comma separated list of ids and total count
+	FindLayouts(context.Context, string, int64, int64) (Layouts, int64, error)
+	FindLayoutByID(context.Context, string) (*Layout, error)
+	Health(context.Context) error
+}
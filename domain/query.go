@@ -0,0 +1,130 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// filter operators of the ListQuery
+const (
+	OpEq   string = "eq"
+	OpNe   string = "ne"
+	OpGt   string = "gt"
+	OpGte  string = "gte"
+	OpLt   string = "lt"
+	OpLte  string = "lte"
+	OpLike string = "like"
+)
+
+// FilterOps allowed operators of the filter
+var FilterOps = []string{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpLike}
+
+// Filter condition on the field of the resource, field is the json name of the field
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// SortField sorting by the field of the resource, field is the json name of the field
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery filtering, free-text search and sorting of the list,
+// repositories push it down to the sql, empty query means default order without conditions
+type ListQuery struct {
+	Filters []Filter
+	Search  string
+	Sort    []SortField
+	// After values of the sort fields (see SortFields) of the last row of the previous page,
+	// the page starts after this row (keyset paging) instead of offset
+	After []string
+	// NoCount disables query of the total count of rows
+	NoCount bool
+}
+
+// IsEmpty returns true if query has no conditions, sorting and keyset paging
+func (lq ListQuery) IsEmpty() bool {
+	return len(lq.Filters) == 0 && lq.Search == "" && len(lq.Sort) == 0 && len(lq.After) == 0 && !lq.NoCount
+}
+
+// SortFields returns sorting of the query with the unique key of the resource at the end, if it's absent
+func (lq ListQuery) SortFields(key string) []SortField {
+	fields := make([]SortField, 0, len(lq.Sort)+1)
+	for _, s := range lq.Sort {
+		if s.Field == key {
+			return append(fields, lq.Sort...)
+		}
+	}
+	fields = append(fields, lq.Sort...)
+	return append(fields, SortField{Field: key})
+}
+
+// String canonical representation of the query, used as part of the cache key
+func (lq ListQuery) String() string {
+	parts := make([]string, 0, len(lq.Filters)+2)
+	for _, f := range lq.Filters {
+		parts = append(parts, fmt.Sprintf("filter[%s][%s]=%s", f.Field, f.Op, url.QueryEscape(f.Value)))
+	}
+	if lq.Search != "" {
+		parts = append(parts, "q="+url.QueryEscape(lq.Search))
+	}
+	if len(lq.Sort) > 0 {
+		sort := make([]string, 0, len(lq.Sort))
+		for _, s := range lq.Sort {
+			if s.Desc {
+				sort = append(sort, "-"+s.Field)
+				continue
+			}
+			sort = append(sort, s.Field)
+		}
+		parts = append(parts, "sort="+strings.Join(sort, ","))
+	}
+	if len(lq.After) > 0 {
+		after := make([]string, 0, len(lq.After))
+		for _, v := range lq.After {
+			after = append(after, url.QueryEscape(v))
+		}
+		parts = append(parts, "after="+strings.Join(after, ","))
+	}
+	if lq.NoCount {
+		parts = append(parts, "count=false")
+	}
+	return strings.Join(parts, "&")
+}
+
+// ListFields whitelist of the fields of the resource allowed for filtering and sorting,
+// the first field is the unique key of the resource
+type ListFields []string
+
+// Key returns unique key of the resource, last field of the sorting for the stable paging
+func (lf ListFields) Key() string {
+	if len(lf) == 0 {
+		return ""
+	}
+	return lf[0]
+}
+
+// Has returns true if field is in the whitelist
+func (lf ListFields) Has(field string) bool {
+	for _, f := range lf {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// whitelists of the list resources, names are the json names of the fields
+var (
+	ProjectListFields = ListFields{"id", "name", "typeId", "typeName", "parentId", "managerId", "managerName",
+		"isEnabled", "db_type"}
+	CustomerConfigListFields = ListFields{"customerId", "customerName", "customerTypeId", "sdServiceId",
+		"sdCreatorId", "sdDestination"}
+	VideocheckConfigListFields = ListFields{"projectId", "localServer", "localCam", "localFtp"}
+	AssetListFields            = ListFields{"serviceDeskId", "id", "name", "serviceDeskParentId", "changed"}
+	EntityListFields           = ListFields{"id", "description"}
+)
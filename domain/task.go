@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Task - task (request) of the ServiceDesk (Intraservice)
+type Task struct {
+	ID          int64      `json:"id"`
+	ParentID    int64      `json:"parentId,omitempty"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	StatusID    int64      `json:"statusId"`
+	Status      string     `json:"status,omitempty"`
+	PriorityID  int64      `json:"priorityId"`
+	Priority    string     `json:"priority,omitempty"`
+	ServiceID   int64      `json:"serviceId"`
+	Service     string     `json:"service,omitempty"`
+	TypeID      int64      `json:"typeId,omitempty"`
+	CreatorID   int64      `json:"creatorId"`
+	Creator     string     `json:"creator,omitempty"`
+	ExecutorIDs []int64    `json:"executorIds"`
+	Executors   string     `json:"executors,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Changed     *time.Time `json:"changed,omitempty"`
+	Deadline    *time.Time `json:"deadline,omitempty"`
+	Closed      *time.Time `json:"closed,omitempty"`
+	// Fields custom fields of the task by field id
+	Fields map[string]string `json:"fields,omitempty"`
+	// Raw original json of the task from the ServiceDesk, used by the legacy format of the api
+	Raw json.RawMessage `json:"-"`
+}
+
+type Tasks []Task
+
+// NewTask request of the new ServiceDesk task for the controller of the project,
+// service, creator and destination of the task are taken from the customer config of the project
+type NewTask struct {
+	SN        string `json:"sn"`
+	ProjectID int64  `json:"projectId"`
+	// Category kind of the problem, only one open task is allowed for the same SN and category
+	Category string `json:"category"`
+	Problem  string `json:"problem"`
+}
+
+// TaskFilter conditions of the tasks search, empty fields are not used
+type TaskFilter struct {
+	StatusIDs  []int64
+	ServiceIDs []int64
+	// CreatedFrom, CreatedTo range of the task creation date, inclusive
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// NewestFirst orders tasks by id descending instead of default order of the ServiceDesk
+	NewestFirst bool
+}
+
+// TaskFile - file attached to the task of the ServiceDesk
+type TaskFile struct {
+	ID          int64      `json:"id"`
+	TaskID      int64      `json:"taskId"`
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	ContentType string     `json:"contentType,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+}
+
+type TaskFiles []TaskFile
+
+// TaskNote - comment in the history of the task
+type TaskNote struct {
+	ID       int64      `json:"id"`
+	AuthorID int64      `json:"authorId,omitempty"`
+	Author   string     `json:"author"`
+	Date     *time.Time `json:"date,omitempty"`
+	Private  bool       `json:"private"`
+	Text     string     `json:"text"`
+}
+
+// TaskStatusChange - transition of the task status in the history of the task
+type TaskStatusChange struct {
+	ID           int64      `json:"id"`
+	AuthorID     int64      `json:"authorId,omitempty"`
+	Author       string     `json:"author"`
+	Date         *time.Time `json:"date,omitempty"`
+	FromStatusID int64      `json:"fromStatusId,omitempty"`
+	FromStatus   string     `json:"fromStatus,omitempty"`
+	ToStatusID   int64      `json:"toStatusId"`
+	ToStatus     string     `json:"toStatus,omitempty"`
+}
+
+// TaskLifetime - history of the task: comments and status transitions in order of date
+type TaskLifetime struct {
+	TaskID   int64              `json:"taskId"`
+	Comments []TaskNote         `json:"comments"`
+	Statuses []TaskStatusChange `json:"statuses"`
+}
+
+// dictionaries of the ServiceDesk
+const (
+	SDStatuses   string = "statuses"
+	SDPriorities string = "priorities"
+	SDServices   string = "services"
+	SDTaskTypes  string = "types"
+	SDTaskFields string = "fields"
+)
+
+// SDDictionaries all dictionaries of the ServiceDesk
+var SDDictionaries = ListFields{SDStatuses, SDPriorities, SDServices, SDTaskTypes, SDTaskFields}
+
+// SDReference - item of the ServiceDesk dictionary: status, priority, service, task type or custom field
+type SDReference struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	ParentID int64  `json:"parentId,omitempty"`
+	// Type data type of the custom field
+	Type string `json:"type,omitempty"`
+	// Key name of the custom field in the task of the ServiceDesk api: Field1101
+	Key        string `json:"key,omitempty"`
+	IsArchived bool   `json:"isArchived,omitempty"`
+}
+
+type SDReferences []SDReference
+
+// FindByID returns item of the dictionary by id or nil
+func (refs SDReferences) FindByID(id int64) *SDReference {
+	for i := range refs {
+		if refs[i].ID == id {
+			return &refs[i]
+		}
+	}
+	return nil
+}
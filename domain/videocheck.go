@@ -0,0 +1,234 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// keys of the known videocheck options
+const (
+	vcOptTracComment    string = "trac_comment"
+	vcOptMergedCode1C   string = "merged_code1c"
+	vcOptFtpProxyUpload string = "ftpProxyUpload"
+	vcOptFtpMask        string = "ftpMask"
+)
+
+// limits of the videocheck options, the same as in VideocheckOptionsSchema
+const (
+	vcTracCommentMaxLen int = 4000
+	vcFtpMaskMaxLen     int = 64
+)
+
+var (
+	reMergedCode1C = regexp.MustCompile(`^[0-9]*$`)
+	reFtpMask      = regexp.MustCompile(`^[#_A-Za-z0-9.\-]*$`)
+)
+
+// VideocheckOptionsSchema JSON Schema of the options of the videocheck configuration
+const VideocheckOptionsSchema string = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "/v2/videochecks/configs/schema",
+  "title": "VideocheckOptions",
+  "description": "options of the videocheck configuration, unknown keys are allowed and kept as is",
+  "type": "object",
+  "properties": {
+    "trac_comment": {"type": "string", "maxLength": 4000, "description": "comment for the operators of the videocheck"},
+    "merged_code1c": {"type": "string", "pattern": "^[0-9]*$",
+      "description": "Code 1S of the project merged with this one"},
+    "ftpProxyUpload": {"type": "boolean", "description": "upload videos through the ftp proxy"},
+    "ftpMask": {"type": "string", "maxLength": 64, "pattern": "^[#_A-Za-z0-9.\\-]*$",
+      "description": "mask of the video files names on the ftp, # - digit"}
+  },
+  "additionalProperties": true
+}`
+
+// VideocheckOptions - options of the videocheck configuration, stored as json text in CM_INFO.
+// Unknown keys are kept in Extra and written back as is. Stored text which isn't json object
+// is kept as legacy value and returned unchanged
+type VideocheckOptions struct {
+	TracComment    string `json:"trac_comment"`
+	MergedCode1C   string `json:"merged_code1c"`
+	FtpProxyUpload bool   `json:"ftpProxyUpload"`
+	FtpMask        string `json:"ftpMask"`
+	// Extra unknown keys of the options
+	Extra map[string]json.RawMessage `json:"-"`
+	// present known keys of the options, absent keys aren't written
+	present map[string]bool
+	// legacy stored text which isn't json object of the options, invalid - error of its parsing
+	legacy  string
+	invalid error
+}
+
+// ParseVideocheckOptions returns options of the stored json text, empty text - empty options,
+// text which isn't json object is kept as legacy value
+func ParseVideocheckOptions(text string) VideocheckOptions {
+	o := VideocheckOptions{}
+	if strings.TrimSpace(text) == "" {
+		return o
+	}
+	if err := o.unmarshalObject([]byte(text)); err != nil {
+		return VideocheckOptions{legacy: text, invalid: err}
+	}
+	return o
+}
+
+// String returns json text of the options for the storage
+func (o VideocheckOptions) String() string {
+	if o.legacy != "" {
+		return o.legacy
+	}
+	if o.IsEmpty() {
+		return ""
+	}
+	data, _ := o.MarshalJSON()
+	return string(data)
+}
+
+// IsEmpty returns true if options have no keys
+func (o VideocheckOptions) IsEmpty() bool {
+	return o.legacy == "" && len(o.Extra) == 0 && len(o.present) == 0 &&
+		o.TracComment == "" && o.MergedCode1C == "" && !o.FtpProxyUpload && o.FtpMask == ""
+}
+
+// MarshalJSON writes known fields which are set or were present and unknown keys as is
+func (o VideocheckOptions) MarshalJSON() ([]byte, error) {
+	if o.legacy != "" {
+		return json.Marshal(o.legacy)
+	}
+	fields := make(map[string]json.RawMessage, len(o.Extra)+4)
+	for k, v := range o.Extra {
+		fields[k] = v
+	}
+	known := map[string]interface{}{
+		vcOptTracComment:    o.TracComment,
+		vcOptMergedCode1C:   o.MergedCode1C,
+		vcOptFtpProxyUpload: o.FtpProxyUpload,
+		vcOptFtpMask:        o.FtpMask,
+	}
+	for k, v := range known {
+		if o.present[k] || !isZeroOption(v) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			fields[k] = data
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(k)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(fields[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// isZeroOption returns true for zero value of the known option
+func isZeroOption(v interface{}) bool {
+	switch value := v.(type) {
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	}
+	return false
+}
+
+// UnmarshalJSON reads options as json object with checked types of the known keys
+// or as json string with stored text (legacy form), which is checked by Validate
+func (o *VideocheckOptions) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*o = VideocheckOptions{}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*o = ParseVideocheckOptions(text)
+		return nil
+	}
+	parsed := VideocheckOptions{}
+	if err := parsed.unmarshalObject(data); err != nil {
+		return err
+	}
+	*o = parsed
+	return nil
+}
+
+// unmarshalObject reads json object of the options
+func (o *VideocheckOptions) unmarshalObject(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("options must be json object, %v", err)
+	}
+	if fields == nil {
+		return fmt.Errorf("options must be json object")
+	}
+	known := map[string]interface{}{
+		vcOptTracComment:    &o.TracComment,
+		vcOptMergedCode1C:   &o.MergedCode1C,
+		vcOptFtpProxyUpload: &o.FtpProxyUpload,
+		vcOptFtpMask:        &o.FtpMask,
+	}
+	for k, v := range fields {
+		dst, ok := known[k]
+		if !ok {
+			if o.Extra == nil {
+				o.Extra = make(map[string]json.RawMessage)
+			}
+			o.Extra[k] = v
+			continue
+		}
+		if err := json.Unmarshal(v, dst); err != nil {
+			kind := "string"
+			if k == vcOptFtpProxyUpload {
+				kind = "boolean"
+			}
+			return fmt.Errorf("options.%s must be %s", k, kind)
+		}
+		if o.present == nil {
+			o.present = make(map[string]bool)
+		}
+		o.present[k] = true
+	}
+	return nil
+}
+
+// Validate checks options by VideocheckOptionsSchema, returns all violations
+func (o VideocheckOptions) Validate() []string {
+	errs := make([]string, 0)
+	if o.invalid != nil {
+		return append(errs, o.invalid.Error())
+	}
+	if len([]rune(o.TracComment)) > vcTracCommentMaxLen {
+		errs = append(errs, fmt.Sprintf("options.%s is longer than %d", vcOptTracComment, vcTracCommentMaxLen))
+	}
+	if !reMergedCode1C.MatchString(o.MergedCode1C) {
+		errs = append(errs, fmt.Sprintf("options.%s must contain digits only", vcOptMergedCode1C))
+	}
+	if len(o.FtpMask) > vcFtpMaskMaxLen {
+		errs = append(errs, fmt.Sprintf("options.%s is longer than %d", vcOptFtpMask, vcFtpMaskMaxLen))
+	}
+	if !reFtpMask.MatchString(o.FtpMask) {
+		errs = append(errs, fmt.Sprintf("options.%s allows #, _, letters, digits, dot and minus", vcOptFtpMask))
+	}
+	return errs
+}
@@ -0,0 +1,232 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+)
+
+// backends of the audit log
+const (
+	auditBackendFile     string = "file"
+	auditBackendPostgres string = "postgres"
+	defaultAuditFile     string = "audit.jsonl"
+	maxAuditLimit        int64  = 1000
+	// auditSort sort signature of the cursor of the audit
+	auditSort string = "id"
+	// auditTimeout of the audit record written after the change
+	auditTimeout time.Duration = 10 * time.Second
+)
+
+var (
+	errAuditDisabled error = errors.New("audit log is disabled or unavailable")
+	errAuditSince    error = errors.New("since parameter in RFC3339 format required")
+)
+
+// AuditResponse records of the audit log
+type AuditResponse struct {
+	Data []domain.AuditRecord `json:"data"`
+	Metadata
+}
+
+// setAudit opens audit log by config, without it changes are logged only
+func (s *Server) setAudit() {
+	backend := s.config.GetString("audit.backend")
+	var err error
+	switch backend {
+	case "":
+		s.log.Warn("audit log is disabled, audit.backend is empty")
+		return
+	case auditBackendFile:
+		path := s.config.GetString("audit.file")
+		if path == "" {
+			path = defaultAuditFile
+		}
+		s.audit, err = repos.NewFileAudit(path)
+	case auditBackendPostgres:
+		s.audit, err = repos.NewAuditPGRepo(s.config.GetString("audit.dsn"),
+			s.config.GetDuration("audit.sqltimeout_sec")*time.Second)
+	default:
+		err = fmt.Errorf("unknown audit.backend %s", backend)
+	}
+	if err != nil {
+		s.audit = nil
+		s.log.Errorf("open audit log error, %v, changes aren't audited", err)
+	}
+}
+
+// recordAudit writes audit record of the change of the resource made by the request, before and after are
+// states of the resource, nil if resource doesn't exist. Error of the audit doesn't fail the request
+func (s *Server) recordAudit(c echo.Context, resource, action string, before, after interface{}) {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	r := domain.AuditRecord{
+		Changed:   time.Now().UTC(),
+		Actor:     tokenActor(c),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		Endpoint:  c.Request().Method + " " + c.Path(),
+		Resource:  resource,
+		Action:    action,
+	}
+	var err error
+	if r.Before, err = auditState(before); err == nil {
+		r.After, err = auditState(after)
+	}
+	if err == nil {
+		r.Diff, err = auditDiff(r.Before, r.After)
+	}
+	if err == nil && s.audit == nil {
+		err = errAuditDisabled
+	}
+	if err == nil {
+		// the change is made, so the audit isn't canceled with the request
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		defer cancel()
+		err = s.audit.Append(ctx, &r)
+	}
+	if err != nil {
+		log.Errorf("audit of %s %s by %s, error %v", action, resource, r.Actor, err)
+	}
+}
+
+// auditState returns json of the state, nil for the missing resource
+func auditState(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return data, nil
+}
+
+// auditDiff returns changed fields of the json states ordered by path, objects are compared by fields
+func auditDiff(before, after json.RawMessage) ([]domain.AuditChange, error) {
+	fieldsBefore, fieldsAfter := make(map[string]json.RawMessage), make(map[string]json.RawMessage)
+	if err := flattenJSON("", before, fieldsBefore); err != nil {
+		return nil, err
+	}
+	if err := flattenJSON("", after, fieldsAfter); err != nil {
+		return nil, err
+	}
+	result := make([]domain.AuditChange, 0)
+	for path, value := range fieldsBefore {
+		if next, ok := fieldsAfter[path]; !ok || !bytes.Equal(value, next) {
+			result = append(result, domain.AuditChange{Path: path, Before: value, After: next})
+		}
+	}
+	for path, value := range fieldsAfter {
+		if _, ok := fieldsBefore[path]; !ok {
+			result = append(result, domain.AuditChange{Path: path, After: value})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// flattenJSON puts compact json values of the fields by dot joined paths, arrays and scalars are values
+func flattenJSON(path string, data json.RawMessage, fields map[string]json.RawMessage) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		var buf bytes.Buffer
+		if err = json.Compact(&buf, data); err != nil {
+			return err
+		}
+		fields[path] = buf.Bytes()
+		return nil
+	}
+	for k, v := range object {
+		key := k
+		if path != "" {
+			key = path + "." + k
+		}
+		if err := flattenJSON(key, v, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiAudit godoc
+// @Summary Audit log of the changes
+// @Description changes made through the api ordered by id: author from the token, request id, endpoint,
+// @Description states before and after the change and their diff. Next page is requested with after=next_cursor
+// @Produce json
+// @Security ApiKeyAuth
+// @Tags audit
+// @Param resource query string false "kind (videocheck, task) or kind and id (videocheck/1234) of the resource"
+// @Param actor query string false "author of the changes"
+// @Param since query string false "RFC3339 time of the first change"
+// @Param after query string false "opaque cursor next_cursor of the previous page"
+// @Param limit query integer false "default=10, max=1000"
+// @Success 200 {object} infra.AuditResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Failure 503 {object} infra.ErrResponse
+// @Router /v2/audit [get]
+func (s *Server) apiAudit(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	if s.audit == nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrServiceUnavailable(errAuditDisabled))
+	}
+	_, limit := s.getPageParams(c)
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	filter := domain.AuditFilter{Resource: c.QueryParam("resource"), Actor: c.QueryParam("actor")}
+	var err error
+	if since := c.QueryParam("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			err = fmt.Errorf("wrong since %s, %v", since, errAuditSince)
+		}
+	}
+	if after := c.QueryParam("after"); after != "" && err == nil {
+		filter.AfterID, err = auditCursorID(after)
+	}
+	if err != nil {
+		log.Warnf("bad request apiAudit, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	records, err := s.audit.Find(c.Request().Context(), filter, limit)
+	if err != nil {
+		log.Errorf("apiAudit, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	response := AuditResponse{Data: records,
+		Metadata: Metadata{ResultSet: ResultSet{Count: int64(len(records)), Limit: limit, Total: -1}}}
+	if int64(len(records)) == limit {
+		response.ResultSet.NextCursor = encodeCursor(listCursor{Sort: auditSort,
+			Values: []string{strconv.FormatInt(records[len(records)-1].ID, 10)}})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// auditCursorID returns id of the last record of the previous page
+func auditCursorID(raw string) (int64, error) {
+	cursor, err := decodeCursor(raw)
+	if err != nil {
+		return 0, err
+	}
+	if cursor.Sort != auditSort || len(cursor.Values) != 1 {
+		return 0, fmt.Errorf("wrong cursor %s", raw)
+	}
+	id, err := strconv.ParseInt(cursor.Values[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong cursor %s", raw)
+	}
+	return id, nil
+}
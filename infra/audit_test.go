@@ -0,0 +1,168 @@
+package infra
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+func TestAuditDiff(t *testing.T) {
+	tCases := []struct {
+		title    string
+		before   string
+		after    string
+		expected []domain.AuditChange
+	}{
+		{title: "create", after: `{"projectId":1,"options":{}}`,
+			expected: []domain.AuditChange{{Path: "projectId", After: []byte(`1`)}}},
+		{title: "update", before: `{"localCam":false,"options":{"ftpMask":"#","a":[1]}}`,
+			after: `{"localCam":true,"options":{"a":[1, 2]}}`,
+			expected: []domain.AuditChange{{Path: "localCam", Before: []byte(`false`), After: []byte(`true`)},
+				{Path: "options.a", Before: []byte(`[1]`), After: []byte(`[1,2]`)},
+				{Path: "options.ftpMask", Before: []byte(`"#"`)}}},
+		{title: "delete", before: `{"comment":"text"}`,
+			expected: []domain.AuditChange{{Path: "comment", Before: []byte(`"text"`)}}},
+		{title: "same", before: `{"a":1}`, after: `{"a":1}`, expected: []domain.AuditChange{}},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			var before, after json.RawMessage
+			if tc.before != "" {
+				before = json.RawMessage(tc.before)
+			}
+			if tc.after != "" {
+				after = json.RawMessage(tc.after)
+			}
+			diff, err := auditDiff(before, after)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if !reflect.DeepEqual(diff, tc.expected) {
+				t.Errorf("Expected diff %+v, but got %+v", tc.expected, diff)
+			}
+		})
+	}
+}
+
+func TestAPIAudit(t *testing.T) {
+	audit, err := repos.NewFileAudit(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	repo := newFakeVideocheckRepo()
+	repo.configs[7] = domain.VideocheckConfig{ProjectID: 7}
+	sd := &fakeTaskRepo{tasks: domain.Tasks{{ID: 5, StatusID: 27}}}
+	s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo, sdRepo: sd, audit: audit}
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	auth := e.Group("/v2", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			payload := &TokenPayload{Username: c.Request().Header.Get("X-User")}
+			if payload.Username == "" {
+				payload = &TokenPayload{AZP: staticTokenAZP, Name: staticTokenAZP}
+			}
+			c.Set(tokenPayloadKey, payload)
+			return next(c)
+		}
+	})
+	auth.POST("/videochecks/configs", s.apiNewCustomerVCConfigByID)
+	auth.PUT("/videochecks/configs/:pid", s.apiUpdCustomerVCConfigByID)
+	auth.PATCH("/videochecks/configs/:pid", s.apiPatchCustomerVCConfigByID)
+	auth.DELETE("/videochecks/configs/:pid", s.apiDelCustomerVCConfigByID)
+	auth.PUT("/tasks/:id/comment", s.apiTaskAddComment)
+	auth.PUT("/tasks/:id/status", s.apiTaskChangeStatus)
+	auth.GET("/audit", s.apiAudit)
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+			t.Fatalf("Expected success of %s %s, but got %d, body=%s", method, path, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+	do(http.MethodPost, "/v2/videochecks/configs", "alice", `{"projectId":8,"localFtp":true}`)
+	requestID := do(http.MethodPut, "/v2/videochecks/configs/7", "bob",
+		`{"projectId":7,"localCam":true}`).Header().Get(echo.HeaderXRequestID)
+	do(http.MethodPatch, "/v2/videochecks/configs/7", "alice", `{"options":{"ftpMask":"##"}}`)
+	do(http.MethodDelete, "/v2/videochecks/configs/8", "bob", "")
+	do(http.MethodPut, "/v2/tasks/5/comment", "alice", `{"comment":"checked"}`)
+	do(http.MethodPut, "/v2/tasks/5/status", "", `{"statusId":29}`)
+
+	tCases := []struct {
+		title   string
+		query   string
+		code    int
+		actions []string
+	}{
+		{title: "all", code: http.StatusOK,
+			actions: []string{"create", "update", "update", "delete", "comment", "status"}},
+		{title: "resource", query: "?resource=videocheck/7", code: http.StatusOK,
+			actions: []string{"update", "update"}},
+		{title: "actor", query: "?resource=videocheck&actor=bob", code: http.StatusOK,
+			actions: []string{"update", "delete"}},
+		{title: "task", query: "?resource=task&since=2000-01-01T00:00:00Z", code: http.StatusOK,
+			actions: []string{"comment", "status"}},
+		{title: "future", query: "?since=2100-01-01T00:00:00Z", code: http.StatusOK, actions: []string{}},
+		{title: "wrong since", query: "?since=yesterday", code: http.StatusBadRequest},
+	}
+	records := map[string][]domain.AuditRecord{}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/audit"+tc.query, nil))
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := AuditResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected audit records, but error %v", err)
+			}
+			actions := make([]string, 0)
+			for _, r := range resp.Data {
+				actions = append(actions, r.Action)
+			}
+			if !reflect.DeepEqual(actions, tc.actions) {
+				t.Errorf("Expected actions %v, but got %v", tc.actions, actions)
+			}
+			records[tc.title] = resp.Data
+		})
+	}
+	update := records["resource"][0]
+	endpoint := "PUT /v2/videochecks/configs/:pid"
+	if update.Actor != "bob" || update.RequestID != requestID || update.Endpoint != endpoint ||
+		!reflect.DeepEqual(update.Diff, []domain.AuditChange{{Path: "localCam", Before: []byte(`false`),
+			After: []byte(`true`)}}) {
+		t.Errorf("Expected update of localCam by bob in request %s, but got %+v", requestID, update)
+	}
+	status := records["task"][1]
+	if status.Resource != "task/5" || len(status.Diff) != 1 || string(status.Diff[0].Before) != "27" ||
+		status.Actor != staticTokenActor {
+		t.Errorf("Expected status change 27 -> 29 of the task 5 by static token, but got %+v", status)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/audit?limit=4", nil))
+	resp := AuditResponse{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/audit?after="+resp.ResultSet.NextCursor, nil))
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Data) != 2 || resp.Data[0].ID != 5 {
+		t.Errorf("Expected second page from record 5, but got %+v", resp.Data)
+	}
+}
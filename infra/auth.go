@@ -0,0 +1,331 @@
+package infra
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	tokenPayloadKey = "token_payload"
+	staticTokenAZP  = "hardcode_token"
+	// staticTokenActor author of the audit records of the static token requests
+	staticTokenActor                = "static-token"
+	layoutsAll                      = "*"
+	oidcDiscoveryPath               = "/.well-known/openid-configuration"
+	minJWKSRefresh    time.Duration = 30 * time.Second
+)
+
+var (
+	errNoJWKS        = errors.New("jwks: key set is not loaded")
+	errUnknownKID    = errors.New("jwks: unknown key id")
+	errTokenAlg      = errors.New("token: only RS256 signing method allowed")
+	errTokenExp      = errors.New("token: exp claim is required")
+	errTokenIssuer   = errors.New("token: issuer mismatch")
+	errTokenAZP      = errors.New("token: azp not allowed")
+	errTokenAudience = errors.New("token: audience mismatch")
+)
+
+// jwk single key of the JSON Web Key Set, only RSA keys are supported
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// keySet cached public keys of the token issuer (keycloak realm),
+// loaded from a local file or from the jwks_uri of the issuer
+type keySet struct {
+	file    string
+	issuer  string
+	handler *http.Client
+	mu      sync.RWMutex
+	keys    map[string]*rsa.PublicKey
+	checked time.Time
+}
+
+// newKeySet builder for keySet, file has priority over issuer
+func newKeySet(file, issuer string, timeout time.Duration) *keySet {
+	return &keySet{
+		file:    file,
+		issuer:  strings.TrimRight(issuer, "/"),
+		handler: &http.Client{Timeout: timeout},
+		keys:    make(map[string]*rsa.PublicKey),
+	}
+}
+
+// load reads key set from the source and replaces cached keys
+func (ks *keySet) load() error {
+	var (
+		keys map[string]*rsa.PublicKey
+		err  error
+	)
+	if ks.file != "" {
+		keys, err = ks.loadFile()
+	} else {
+		keys, err = ks.loadURL()
+	}
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+	return nil
+}
+
+func (ks *keySet) loadFile() (map[string]*rsa.PublicKey, error) {
+	raw, err := ioutil.ReadFile(ks.file)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file %s error, %w", ks.file, err)
+	}
+	return parseJWKS(bytes.NewReader(raw))
+}
+
+func (ks *keySet) loadURL() (map[string]*rsa.PublicKey, error) {
+	if ks.issuer == "" {
+		return nil, errNoJWKS
+	}
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	err := ks.getJSON(ks.issuer+oidcDiscoveryPath, &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("openid configuration of %s has empty jwks_uri", ks.issuer)
+	}
+	response, err := ks.handler.Get(discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s wrong response, status=%s", discovery.JWKSURI, response.Status)
+	}
+	return parseJWKS(response.Body)
+}
+
+func (ks *keySet) getJSON(uri string, dst interface{}) error {
+	response, err := ks.handler.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s wrong response, status=%s", uri, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(dst)
+}
+
+// key returns public key by kid, unknown kid forces reload of the key set
+// (keys rotation in the realm) but not often than minJWKSRefresh
+func (ks *keySet) key(kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	key, ok := ks.keys[kid]
+	if ok {
+		ks.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(ks.checked) < minJWKSRefresh {
+		ks.mu.Unlock()
+		return nil, errUnknownKID
+	}
+	ks.checked = time.Now()
+	ks.mu.Unlock()
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	ks.mu.RLock()
+	key, ok = ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, errUnknownKID
+	}
+	return key, nil
+}
+
+// isLoaded returns true if key set contains at least one key
+func (ks *keySet) isLoaded() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys) > 0
+}
+
+// parseJWKS decodes JSON Web Key Set and returns RSA signature keys by kid
+func parseJWKS(r io.Reader) (map[string]*rsa.PublicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode jwks error, %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "="))
+		if err != nil {
+			return nil, fmt.Errorf("decode modulus of key %s error, %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.E, "="))
+		if err != nil {
+			return nil, fmt.Errorf("decode exponent of key %s error, %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks does not contain RSA signature keys")
+	}
+	return keys, nil
+}
+
+// jwksRefresher reloads key set of the token issuer by schedule
+func (s *Server) jwksRefresher(period time.Duration, cancel <-chan struct{}) {
+	s.log.Infof("starting jwksRefresher with scheduller %v", period)
+	defer s.log.Info("stopped jwksRefresher")
+	tick := time.NewTicker(period)
+	for {
+		select {
+		case <-cancel:
+			tick.Stop()
+			return
+		case <-tick.C:
+			err := s.keys.load()
+			if err != nil {
+				s.log.Errorf("jwks reload error, %v", err)
+				s.setRepoState(repoToken, s.keys.isLoaded())
+				continue
+			}
+			s.setRepoState(repoToken, true)
+		}
+	}
+}
+
+// validateToken implementation of middleware.KeyAuthValidator,
+// checks bearer JWT and puts TokenPayload to the echo context
+func (s *Server) validateToken(key string, c echo.Context) (bool, error) {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	if s.allowStaticToken && s.token != "" &&
+		subtle.ConstantTimeCompare([]byte(key), []byte(s.token)) == 1 {
+		c.Set(tokenPayloadKey, &TokenPayload{AZP: staticTokenAZP, Name: staticTokenAZP, Layouts: layoutsAll})
+		return true, nil
+	}
+	payload, err := s.parseToken(key)
+	if err != nil {
+		log.Warnf("validateToken error, %v", err)
+		return false, err
+	}
+	c.Set(tokenPayloadKey, payload)
+	return true, nil
+}
+
+// parseToken verifies signature and claims of the raw JWT
+func (s *Server) parseToken(raw string) (*TokenPayload, error) {
+	if s.keys == nil {
+		return nil, errNoJWKS
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, errTokenAlg
+		}
+		kid, _ := t.Header["kid"].(string)
+		return s.keys.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errTokenExp
+	}
+	if s.tokenIssuer != "" && !claims.VerifyIssuer(s.tokenIssuer, true) {
+		return nil, errTokenIssuer
+	}
+	if len(s.tokenAZP) > 0 && !containsString(s.tokenAZP, fmt.Sprint(claims["azp"])) {
+		return nil, errTokenAZP
+	}
+	if s.tokenAudience != "" && !hasAudience(claims["aud"], s.tokenAudience) {
+		return nil, errTokenAudience
+	}
+	payload := &TokenPayload{}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, payload); err != nil {
+		return nil, err
+	}
+	payload.Token = raw
+	return payload, nil
+}
+
+// hasAudience checks aud claim, keycloak sets it as string or array of strings
+func hasAudience(aud interface{}, expected string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == expected
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenFromContext returns TokenPayload of the authorized request or nil
+func tokenFromContext(c echo.Context) *TokenPayload {
+	payload, _ := c.Get(tokenPayloadKey).(*TokenPayload)
+	return payload
+}
+
+// tokenActor returns author of the request for the audit records, the fixed one for the static token
+func tokenActor(c echo.Context) string {
+	payload := tokenFromContext(c)
+	if payload == nil {
+		return ""
+	}
+	if payload.AZP == staticTokenAZP {
+		return staticTokenActor
+	}
+	for _, actor := range []string{payload.Username, payload.Email, payload.Subject, payload.AZP} {
+		if actor != "" {
+			return actor
+		}
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package infra
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	testKID      string = "test-kid"
+	testIssuer   string = "https://sso.example.local/auth/realms/countmax"
+	testAZP      string = "commonapi"
+	testAudience string = "account"
+	testStatic   string = "legacy-secret"
+)
+
+func makeTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected success rsa.GenerateKey, but error %v", err)
+	}
+	return key
+}
+
+func makeTestJWKS(t *testing.T, kid string, key *rsa.PublicKey) []byte {
+	t.Helper()
+	set := map[string][]jwk{
+		"keys": {
+			{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			},
+		},
+	}
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("Expected success json.Marshal, but error %v", err)
+	}
+	return raw
+}
+
+func makeTestToken(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Expected success SignedString, but error %v", err)
+	}
+	return raw
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iss":                testIssuer,
+		"azp":                testAZP,
+		"aud":                []string{"broker", testAudience},
+		"sub":                "f3b8c1a0",
+		"preferred_username": "tester",
+		"name":               "Test User",
+		"email":              "tester@example.local",
+	}
+}
+
+func makeAuthServer(t *testing.T, pub *rsa.PublicKey) *Server {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(file, makeTestJWKS(t, testKID, pub), 0600); err != nil {
+		t.Fatalf("Expected success write jwks file, but error %v", err)
+	}
+	s := &Server{
+		log:           zap.NewNop().Sugar(),
+		token:         testStatic,
+		keys:          newKeySet(file, "", time.Second),
+		tokenIssuer:   testIssuer,
+		tokenAZP:      []string{testAZP},
+		tokenAudience: testAudience,
+	}
+	if err := s.keys.load(); err != nil {
+		t.Fatalf("Expected success keys.load, but error %v", err)
+	}
+	return s
+}
+
+func TestParseToken(t *testing.T) {
+	key := makeTestKey(t)
+	alien := makeTestKey(t)
+	s := makeAuthServer(t, &key.PublicKey)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noExp := validClaims()
+	delete(noExp, "exp")
+	wrongAZP := validClaims()
+	wrongAZP["azp"] = "other-client"
+	wrongAud := validClaims()
+	wrongAud["aud"] = "other-audience"
+	stringAud := validClaims()
+	stringAud["aud"] = testAudience
+	wrongIss := validClaims()
+	wrongIss["iss"] = "https://evil.local/auth/realms/countmax"
+
+	tCases := []struct {
+		title string
+		token string
+		valid bool
+	}{
+		{title: "valid token", token: makeTestToken(t, testKID, key, validClaims()), valid: true},
+		{title: "string audience", token: makeTestToken(t, testKID, key, stringAud), valid: true},
+		{title: "expired", token: makeTestToken(t, testKID, key, expired), valid: false},
+		{title: "without exp", token: makeTestToken(t, testKID, key, noExp), valid: false},
+		{title: "wrong azp", token: makeTestToken(t, testKID, key, wrongAZP), valid: false},
+		{title: "wrong audience", token: makeTestToken(t, testKID, key, wrongAud), valid: false},
+		{title: "wrong issuer", token: makeTestToken(t, testKID, key, wrongIss), valid: false},
+		{title: "alien key", token: makeTestToken(t, testKID, alien, validClaims()), valid: false},
+		{title: "unknown kid", token: makeTestToken(t, "unknown", key, validClaims()), valid: false},
+		{title: "garbage", token: "not.a.jwt", valid: false},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			payload, err := s.parseToken(tc.token)
+			if tc.valid != (err == nil) {
+				t.Fatalf("Expected valid=%t, but got error %v", tc.valid, err)
+			}
+			if tc.valid && payload.Username != "tester" {
+				t.Errorf("Expected username=tester, but got %s", payload.Username)
+			}
+		})
+	}
+}
+
+func TestValidateTokenMiddleware(t *testing.T) {
+	key := makeTestKey(t)
+	s := makeAuthServer(t, &key.PublicKey)
+	e := echo.New()
+	var got *TokenPayload
+	e.GET("/v2/check", func(c echo.Context) error {
+		got = tokenFromContext(c)
+		return c.NoContent(http.StatusOK)
+	}, middleware.KeyAuth(s.validateToken))
+
+	tCases := []struct {
+		title       string
+		token       string
+		allowStatic bool
+		code        int
+		azp         string
+	}{
+		{title: "jwt", token: makeTestToken(t, testKID, key, validClaims()), code: http.StatusOK, azp: testAZP},
+		{title: "static disabled", token: testStatic, code: http.StatusUnauthorized},
+		{title: "static enabled", token: testStatic, allowStatic: true, code: http.StatusOK, azp: staticTokenAZP},
+		{title: "wrong static", token: "wrong", allowStatic: true, code: http.StatusUnauthorized},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			got = nil
+			s.allowStaticToken = tc.allowStatic
+			req := httptest.NewRequest(http.MethodGet, "/v2/check", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d", tc.code, rec.Code)
+			}
+			if tc.code == http.StatusOK && (got == nil || got.AZP != tc.azp) {
+				t.Errorf("Expected token payload with azp=%s in context, but got %v", tc.azp, got)
+			}
+		})
+	}
+}
+
+func TestKeySetFromIssuer(t *testing.T) {
+	key := makeTestKey(t)
+	jwks := makeTestJWKS(t, testKID, &key.PublicKey)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/countmax" + oidcDiscoveryPath:
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   srv.URL + "/realms/countmax",
+				"jwks_uri": srv.URL + "/realms/countmax/protocol/openid-connect/certs",
+			})
+		case "/realms/countmax/protocol/openid-connect/certs":
+			_, _ = w.Write(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ks := newKeySet("", srv.URL+"/realms/countmax/", time.Second)
+	if err := ks.load(); err != nil {
+		t.Fatalf("Expected success load jwks from issuer, but error %v", err)
+	}
+	pub, err := ks.key(testKID)
+	if err != nil {
+		t.Fatalf("Expected key with kid=%s, but error %v", testKID, err)
+	}
+	if pub.N.Cmp(key.PublicKey.N) != 0 || pub.E != key.PublicKey.E {
+		t.Error("Expected loaded key equal generated public key, but not")
+	}
+	if _, err = ks.key("unknown"); err != errUnknownKID {
+		t.Errorf("Expected errUnknownKID for unknown kid, but got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package infra
+
+import (
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+
+	"go.uber.org/zap"
+)
+
+const (
+	cacheRedis    string = "redis"
+	cacheInMemory string = "inmemory"
+	cacheNone     string = "none"
+	// cacheDefaultSize default maximum items of the inmemory cache
+	cacheDefaultSize int = 10000
+)
+
+// setCache makes cache storage by config, redis is used if cache.url is not empty,
+// inmemory LRU otherwise, type none disables caching
+func (s *Server) setCache() {
+	s.cacheNS = "commonapi:" + s.version
+	ttl := map[string]int{}
+	for method := range s.config.GetStringMap("cache.ttl_sec") {
+		ttl[method] = s.config.GetInt("cache.ttl_sec." + method)
+	}
+	s.cacheTTL = repos.NewCacheTTL(ttl)
+	kind := s.config.GetString("cache.type")
+	url := s.config.GetString("cache.url")
+	switch {
+	case kind == cacheNone:
+		s.log.Warnf("cache disabled by config")
+		return
+	case kind == cacheRedis && url != "":
+		timeout := s.config.GetDuration("cache.timeout_sec") * time.Second
+		s.cache = repos.NewRedisCache(url, timeout)
+		if err := s.cache.Health(); err != nil {
+			s.log.Errorf("connection to redis (%s) error, %v, repositories work without cache until it's available",
+				url, err)
+		}
+		s.log.Infof("redis cache %s used with namespace %s", url, s.cacheNS)
+	default:
+		size := s.config.GetInt("cache.size")
+		if size <= 0 {
+			size = cacheDefaultSize
+		}
+		cache, err := repos.NewMemoryCache(size)
+		if err != nil {
+			s.log.Errorf("create inmemory cache error, %v, cache disabled", err)
+			return
+		}
+		s.cache = cache
+		s.log.Infof("inmemory cache used with size %d", size)
+	}
+}
+
+// cachedAssetRepo returns asset repository with cache if cache enabled
+func (s *Server) cachedAssetRepo(repo domain.AssetRepo) domain.AssetRepo {
+	if s.cache == nil {
+		return repo
+	}
+	return repos.NewCachedAssetRepo(repo, s.cache, s.cacheTTL, s.cacheNS, s.log)
+}
+
+// cachedInfoRepo returns CM_INFO repository with cache if cache enabled
+func (s *Server) cachedInfoRepo(repo domain.CustomerRepo) domain.CustomerRepo {
+	if s.cache == nil {
+		return repo
+	}
+	return repos.NewCachedCustomerRepo(repo, s.cache, s.cacheTTL, s.cacheNS, s.log)
+}
+
+// cachedRefRepo returns evolution repository with cache if cache enabled
+func (s *Server) cachedRefRepo(repo domain.RefRepo) domain.RefRepo {
+	if s.cache == nil {
+		return repo
+	}
+	return repos.NewCachedRefRepo(repo, s.cache, s.cacheTTL, s.cacheNS, s.log)
+}
+
+// cachedSDRepo returns Intraservice api repository with cache of the dictionaries if cache enabled
+func (s *Server) cachedSDRepo(repo domain.SDRepo) domain.SDRepo {
+	if s.cache == nil {
+		return repo
+	}
+	return repos.NewCachedSDRepo(repo, s.cache, s.cacheTTL, s.cacheNS, s.log)
+}
+
+// cacheHealthCheck checks cache storage and updates metric of the cached keys count
+func (s *Server) cacheHealthCheck() {
+	if s.cache == nil {
+		return
+	}
+	dest := s.config.GetString("cache.type")
+	if dest == cacheRedis {
+		dest = s.config.GetString("cache.url")
+	}
+	if err := s.cache.Health(); err != nil {
+		s.log.With(zap.String("repository", dest)).Errorf("healthCheck error, %v", err)
+		s.mService.WithLabelValues("cache", dest, s.version, s.githash, s.build).Set(0)
+		return
+	}
+	s.mService.WithLabelValues("cache", dest, s.version, s.githash, s.build).Set(1)
+	for _, scope := range []string{repos.CacheScopeInfo, repos.CacheScopeAsset, repos.CacheScopeRef,
+		repos.CacheScopeSD} {
+		count, err := s.cache.Count(repos.CacheKeyPrefix(s.cacheNS, scope))
+		if err != nil {
+			s.log.Errorf("count cached keys of %s error, %v", scope, err)
+			continue
+		}
+		s.mCache.WithLabelValues(scope).Set(float64(count))
+	}
+}
@@ -92,7 +92,7 @@ func (s *Server) consulRegister() {
 			EnableTagOverride: false,
 			Check: &consulapi.AgentServiceCheck{
 				DeregisterCriticalServiceAfter: "90m",
-				HTTP: fmt.Sprintf("http://%s:%d/health",
+				HTTP: fmt.Sprintf("http://%s:%d/health/ready",
 					s.config.GetString("consul.address"), s.config.GetInt("consul.port")),
 				Interval: "60s",
 			},
@@ -0,0 +1,135 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+var errDependencyUnavailable error = errors.New("dependency unavailable")
+
+// dependency external service of the server, handlers use its repository only when it's ready
+type dependency struct {
+	// name readiness name of the repository: repoINFO, repoISDB...
+	name string
+	// label service label of the metric
+	label string
+	// dest address of the service without credentials
+	dest string
+	// health checks the connected repository
+	health func(context.Context) error
+	// critical the service isn't ready without dependency, otherwise it's degraded
+	critical bool
+}
+
+// registerDependencies fills registry of the dependencies checked by healthCheck,
+// dependencies from health.noncritical don't affect readiness of the service
+func (s *Server) registerDependencies() {
+	s.deps = []dependency{
+		{name: repoISDB, label: "intraserviceDB", dest: getSrvPortDB(s.config.GetString("intraservice.dsn")),
+			health: func(ctx context.Context) error { return s.getAssetRepo().Health(ctx) }},
+		{name: repoINFO, label: "CM_INFO", dest: getSrvPortDB(s.config.GetString("cminfo.dsn")),
+			health: func(ctx context.Context) error { return s.getInfoRepo().Health(ctx) }},
+		{name: repoRef, label: "evolution", dest: getSrvPortDB(s.config.GetString("ref.dsn")),
+			health: func(ctx context.Context) error { return s.getRefRepo().Health(ctx) }},
+		{name: repoLayout, label: "layout", dest: getSrvPortDB(s.config.GetString("layout.dsn")),
+			health: func(ctx context.Context) error { return s.getLayoutRepo().Health(ctx) }},
+		{name: repoISAPI, label: "IntraserviceAPI", dest: s.config.GetString("intraservice.url"),
+			health: func(ctx context.Context) error { return s.sdRepo.Health(ctx) }},
+	}
+	nonCritical := domain.ListFields(s.config.GetStringSlice("health.noncritical"))
+	for i := range s.deps {
+		s.deps[i].critical = !nonCritical.Has(s.deps[i].name)
+	}
+}
+
+// isHealthyRepo false if the last healthCheck reports the repository down,
+// repository isn't checked yet before the first report
+func (s *Server) isHealthyRepo(name string) bool {
+	state, ok := s.getHealth().Dependencies[name]
+	return !ok || state.Status == healthOK
+}
+
+// needRepos middleware returns 503 with Retry-After while one of the repositories isn't connected
+// or the last healthCheck reports it down
+func (s *Server) needRepos(names ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			down := make([]string, 0, len(names))
+			for _, name := range names {
+				if !s.isReadyRepo(name) || !s.isHealthyRepo(name) {
+					down = append(down, name)
+				}
+			}
+			if len(down) == 0 {
+				return next(c)
+			}
+			s.log.Warnf("request %s rejected, repositories %s are unavailable", c.Path(), strings.Join(down, ","))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(periodHealthCheck.Seconds())))
+			return c.JSON(http.StatusServiceUnavailable,
+				ErrServiceUnavailable(fmt.Errorf("%w: %s", errDependencyUnavailable, strings.Join(down, ","))))
+		}
+	}
+}
+
+func (s *Server) getAssetRepo() domain.AssetRepo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.assetRepo
+}
+
+// setAssetRepo sets connected repository and its readiness at once
+func (s *Server) setAssetRepo(repo domain.AssetRepo) {
+	s.mu.Lock()
+	s.assetRepo = repo
+	s.repoReady[repoISDB] = true
+	s.mu.Unlock()
+}
+
+func (s *Server) getInfoRepo() domain.CustomerRepo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.infoRepo
+}
+
+// setInfoRepo sets connected repository and its readiness at once
+func (s *Server) setInfoRepo(repo domain.CustomerRepo) {
+	s.mu.Lock()
+	s.infoRepo = repo
+	s.repoReady[repoINFO] = true
+	s.mu.Unlock()
+}
+
+func (s *Server) getRefRepo() domain.RefRepo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.refRepo
+}
+
+// setRefRepo sets connected repository and its readiness at once
+func (s *Server) setRefRepo(repo domain.RefRepo) {
+	s.mu.Lock()
+	s.refRepo = repo
+	s.repoReady[repoRef] = true
+	s.mu.Unlock()
+}
+
+func (s *Server) getLayoutRepo() domain.LayoutRepo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.layoutRepo
+}
+
+// setLayoutRepo sets connected repository and its readiness at once
+func (s *Server) setLayoutRepo(repo domain.LayoutRepo) {
+	s.mu.Lock()
+	s.layoutRepo = repo
+	s.repoReady[repoLayout] = true
+	s.mu.Unlock()
+}
@@ -0,0 +1,55 @@
+package infra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestNeedRepos(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar(), repoReady: map[string]bool{repoINFO: false, repoISAPI: true}}
+	handler := s.needRepos(repoINFO, repoISAPI)(func(c echo.Context) error {
+		return c.JSON(http.StatusOK, OkStatus("OK"))
+	})
+	tCases := []struct {
+		title    string
+		connect  bool
+		health   string
+		expected int
+	}{
+		{title: "repository not connected", expected: http.StatusServiceUnavailable},
+		{title: "repository connected by reconnector", connect: true, expected: http.StatusOK},
+		{title: "repository down by healthCheck", health: healthCritical, expected: http.StatusServiceUnavailable},
+		{title: "repository degraded by healthCheck", health: healthDegraded, expected: http.StatusServiceUnavailable},
+		{title: "repository recovered by healthCheck", health: healthOK, expected: http.StatusOK},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			if tc.connect {
+				s.setInfoRepo(&fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{}})
+			}
+			if tc.health != "" {
+				s.setHealth(HealthResponse{Status: tc.health, Dependencies: map[string]DependencyStatus{
+					repoINFO:  {Ready: true, Status: tc.health},
+					repoISAPI: {Ready: true, Status: healthOK},
+				}})
+			}
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/projects", nil), rec)
+			if err := handler(c); err != nil {
+				t.Fatalf("Expected success handler, but error %v", err)
+			}
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status %d, but got %d", tc.expected, rec.Code)
+			}
+			if tc.expected == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("Expected Retry-After header, but it's empty")
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+)
+
+// defaults of the delivery of the events to the subscribers
+const (
+	defaultHookOutboxFile  string        = "outbox.json"
+	defaultHookMaxAttempts int           = 10
+	defaultHookRetryPeriod time.Duration = 10 * time.Second
+	defaultHookRetention   time.Duration = 72 * time.Hour
+	// hookMaxBackoff max delay between attempts of the delivery
+	hookMaxBackoff time.Duration = time.Hour
+	// hookMaxBody max size of the notification of the Intraservice
+	hookMaxBody int64 = 1 << 20
+	// hookBatch count of the messages delivered at once
+	hookBatch int = 100
+	// hookSignatureHeader HMAC-SHA256 of the body: sha256=<hex>, used for the notifications and the callbacks
+	hookSignatureHeader string = "X-Signature"
+	// hookSecretHeader shared secret of the notification if Intraservice can't sign it
+	hookSecretHeader string = "X-Hook-Secret"
+)
+
+var (
+	errHookDisabled  error = errors.New("webhook is disabled, hooks.secret is empty")
+	errHookSignature error = errors.New("wrong signature or secret of the webhook")
+	errHookOutbox    error = errors.New("outbox of the events is unavailable")
+)
+
+// HookResponse accepted events of the notification
+type HookResponse struct {
+	Data domain.TaskEvents `json:"data"`
+	// Queued count of the new messages for the subscribers, repeated events aren't queued
+	Queued int `json:"queued"`
+}
+
+// hooks receiver of the Intraservice notifications and dispatcher of the events to the subscribers
+type hooks struct {
+	secret      string
+	snField     string
+	subscribers []domain.Subscriber
+	outbox      domain.Outbox
+	client      *http.Client
+	maxAttempts int
+	retryPeriod time.Duration
+	// notify wakes up dispatcher after new events
+	notify chan struct{}
+}
+
+// setHooks fills subscribers and opens outbox by config, dispatcher isn't started without outbox
+func (s *Server) setHooks() {
+	s.hooks = hooks{
+		secret:      s.config.GetString("hooks.secret"),
+		snField:     s.config.GetString("hooks.sn_field"),
+		maxAttempts: s.config.GetInt("hooks.max_attempts"),
+		retryPeriod: s.config.GetDuration("hooks.retry_period_sec") * time.Second,
+		client:      &http.Client{Timeout: s.config.GetDuration("hooks.timeout_sec") * time.Second},
+		notify:      make(chan struct{}, 1),
+	}
+	if err := s.config.UnmarshalKey("hooks.subscribers", &s.hooks.subscribers); err != nil {
+		s.log.Errorf("hooks.subscribers config error, %v", err)
+	}
+	if s.hooks.client.Timeout <= 0 {
+		s.hooks.client.Timeout = periodHealthCheck
+	}
+	path := s.config.GetString("hooks.outbox_file")
+	if path == "" {
+		path = defaultHookOutboxFile
+	}
+	retention := s.config.GetDuration("hooks.retention_hours") * time.Hour
+	if retention <= 0 {
+		retention = defaultHookRetention
+	}
+	outbox, err := repos.NewFileOutbox(path, retention)
+	if err != nil {
+		s.log.Errorf("open outbox error, %v, webhook is unavailable", err)
+		return
+	}
+	s.hooks.outbox = outbox
+	go s.hookDispatcher(s.chCancel)
+}
+
+// verifyHook checks HMAC signature of the body or shared secret of the notification, the secret is accepted
+// only in the header, so it isn't written to the access log with the url
+func (h *hooks) verifyHook(c echo.Context, body []byte) bool {
+	if signature := c.Request().Header.Get(hookSignatureHeader); signature != "" {
+		expected := signBody(h.secret, body)
+		return hmac.Equal([]byte(strings.TrimPrefix(signature, "sha256=")), []byte(expected))
+	}
+	secret := c.Request().Header.Get(hookSecretHeader)
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) == 1
+}
+
+// signBody returns hex of HMAC-SHA256 of the body
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// apiIntraserviceHook godoc
+// @Summary Notification of the Intraservice about changed task
+// @Description verifies signature (X-Signature: sha256=<hex HMAC-SHA256 of the body>) or shared secret
+// @Description (X-Hook-Secret header), normalizes notification into events task.created, task.status_changed,
+// @Description task.comment_added and queues them for the subscribers, repeated events are not queued again
+// @Accept json
+// @Produce json
+// @Tags hooks
+// @Success 202 {object} infra.HookResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.ErrResponse
+// @Failure 403 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Failure 503 {object} infra.ErrResponse
+// @Router /v2/hooks/intraservice [post]
+func (s *Server) apiIntraserviceHook(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	if s.hooks.secret == "" {
+		return c.JSON(http.StatusForbidden, ErrForbidden(errHookDisabled))
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, hookMaxBody))
+	if err != nil {
+		log.Errorf("apiIntraserviceHook, read body error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if !s.hooks.verifyHook(c, body) {
+		log.Warnf("apiIntraserviceHook from %s, %v", c.RealIP(), errHookSignature)
+		return c.JSON(http.StatusUnauthorized, ErrUnAuthorized(errHookSignature))
+	}
+	if s.hooks.outbox == nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrServiceUnavailable(errHookOutbox))
+	}
+	events, err := repos.ParseIntraserviceHook(body, s.hooks.snField, time.Local)
+	if err != nil {
+		log.Errorf("apiIntraserviceHook, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	now := time.Now()
+	messages := make([]domain.OutboxMessage, 0, len(events)*len(s.hooks.subscribers))
+	for _, event := range events {
+		for _, sub := range s.hooks.subscribers {
+			if sub.Accepts(event.Type) {
+				messages = append(messages, domain.OutboxMessage{Subscriber: sub.Name, Event: event, NextAttempt: now})
+			}
+		}
+	}
+	queued := 0
+	if len(messages) > 0 {
+		if queued, err = s.hooks.outbox.Enqueue(c.Request().Context(), messages); err != nil {
+			log.Errorf("apiIntraserviceHook, outbox.Enqueue error %v", err)
+			return c.JSON(http.StatusInternalServerError, ErrServerInternal(errHookOutbox))
+		}
+	}
+	if queued > 0 {
+		select {
+		case s.hooks.notify <- struct{}{}:
+		default:
+		}
+	}
+	log.Infof("apiIntraserviceHook, %d events, %d messages queued, %d repeated", len(events), queued,
+		len(messages)-queued)
+	return c.JSON(http.StatusAccepted, HookResponse{Data: events, Queued: queued})
+}
+
+// hookDispatcher delivers pending messages of the outbox after new events and periodically for retries
+func (s *Server) hookDispatcher(cancel <-chan struct{}) {
+	period := s.hooks.retryPeriod
+	if period <= 0 {
+		period = defaultHookRetryPeriod
+	}
+	s.log.Infof("starting hookDispatcher with scheduller %v", period)
+	defer s.log.Info("stopped hookDispatcher")
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+	for {
+		s.deliverPending(context.Background())
+		select {
+		case <-cancel:
+			return
+		case <-tick.C:
+		case <-s.hooks.notify:
+		}
+	}
+}
+
+// deliverPending sends pending messages to the subscribers and saves results of the attempts
+func (s *Server) deliverPending(ctx context.Context) {
+	messages, err := s.hooks.outbox.Pending(ctx, time.Now(), hookBatch)
+	if err != nil {
+		s.log.Errorf("outbox.Pending error, %v", err)
+		return
+	}
+	maxAttempts := s.hooks.maxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultHookMaxAttempts
+	}
+	for i := range messages {
+		m := &messages[i]
+		err := errors.New("subscriber isn't configured")
+		for _, sub := range s.hooks.subscribers {
+			if sub.Name == m.Subscriber {
+				err = s.hooks.deliver(ctx, sub, m.Event)
+				break
+			}
+		}
+		now := time.Now()
+		m.Attempts++
+		if err == nil {
+			m.Delivered, m.LastError = &now, ""
+		} else {
+			m.LastError = err.Error()
+			m.NextAttempt = now.Add(hookBackoff(m.Attempts))
+			m.Dead = m.Attempts >= maxAttempts
+			s.log.Warnf("deliver event %s to %s, attempt %d, error %v", m.Event.ID, m.Subscriber, m.Attempts, err)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	// results of the batch are saved at once, delivered messages can be repeated after the crash
+	if err = s.hooks.outbox.Update(ctx, messages); err != nil {
+		s.log.Errorf("outbox.Update of %d messages error, %v", len(messages), err)
+	}
+}
+
+// hookBackoff exponential delay before the next attempt: 10s, 20s, 40s... up to hookMaxBackoff
+func hookBackoff(attempts int) time.Duration {
+	delay := defaultHookRetryPeriod
+	for i := 1; i < attempts && delay < hookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > hookMaxBackoff {
+		delay = hookMaxBackoff
+	}
+	return delay
+}
+
+// deliver posts event to the callback of the subscriber, 2xx response means delivered
+func (h *hooks) deliver(ctx context.Context, sub domain.Subscriber, event domain.TaskEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("X-Event-Type", event.Type)
+	request.Header.Set("X-Event-ID", event.ID)
+	if sub.Secret != "" {
+		request.Header.Set(hookSignatureHeader, "sha256="+signBody(sub.Secret, body))
+	}
+	response, err := h.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		txt, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("http response status=%s, response=%s", response.Status, string(txt))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const testHookBody = `{"Id":1,"Name":"[offline] S1","StatusId":29,"OldStatusId":27,"Changed":"2021-03-02 10:00:00"}`
+
+func TestAPIIntraserviceHook(t *testing.T) {
+	tCases := []struct {
+		title  string
+		secret string
+		header string
+		value  string
+		query  string
+		code   int
+		queued int
+	}{
+		{title: "disabled", header: hookSecretHeader, value: "s", code: http.StatusForbidden},
+		{title: "signature", secret: "s", header: hookSignatureHeader,
+			value: "sha256=" + signBody("s", []byte(testHookBody)), code: http.StatusAccepted, queued: 1},
+		{title: "wrong signature", secret: "s", header: hookSignatureHeader, value: "sha256=00",
+			code: http.StatusUnauthorized},
+		{title: "secret header", secret: "s", header: hookSecretHeader, value: "s",
+			code: http.StatusAccepted, queued: 1},
+		{title: "secret param", secret: "s", query: "?secret=s", code: http.StatusUnauthorized},
+		{title: "without secret", secret: "s", code: http.StatusUnauthorized},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			outbox, err := repos.NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json"), time.Hour)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			s := &Server{log: zap.NewNop().Sugar(), hooks: hooks{secret: tc.secret, outbox: outbox,
+				notify: make(chan struct{}, 1), subscribers: []domain.Subscriber{
+					{Name: "status", Events: []string{domain.EventTaskStatusChanged}},
+					{Name: "created", Events: []string{domain.EventTaskCreated}},
+				}}}
+			e := echo.New()
+			e.POST("/v2/hooks/intraservice", s.apiIntraserviceHook)
+			req := httptest.NewRequest(http.MethodPost, "/v2/hooks/intraservice"+tc.query,
+				strings.NewReader(testHookBody))
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusAccepted {
+				return
+			}
+			resp := HookResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected events in response, but error %v", err)
+			}
+			if resp.Queued != tc.queued || len(resp.Data) != 1 || resp.Data[0].SN != "S1" {
+				t.Errorf("Expected %d queued status event of S1, but got %+v", tc.queued, resp)
+			}
+			pending, _ := outbox.Pending(context.Background(), time.Now(), 10)
+			if len(pending) != tc.queued || pending[0].Subscriber != "status" {
+				t.Errorf("Expected message for subscriber status, but got %+v", pending)
+			}
+			// repeated notification isn't queued again
+			req = httptest.NewRequest(http.MethodPost, "/v2/hooks/intraservice"+tc.query,
+				strings.NewReader(testHookBody))
+			req.Header.Set(tc.header, tc.value)
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			resp = HookResponse{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+			pending, _ = outbox.Pending(context.Background(), time.Now(), 10)
+			if resp.Queued != 0 || len(pending) != 1 {
+				t.Errorf("Expected no messages of repeated notification, but got %d queued, %+v",
+					resp.Queued, pending)
+			}
+		})
+	}
+}
+
+func TestDeliverPending(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		calls      int
+		signatures []string
+	)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if r.Header.Get(hookSignatureHeader) == "sha256="+signBody("cb", body) {
+			signatures = append(signatures, r.Header.Get("X-Event-ID"))
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer subscriber.Close()
+	outbox, err := repos.NewFileOutbox(filepath.Join(t.TempDir(), "outbox.json"), time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	s := &Server{log: zap.NewNop().Sugar(), hooks: hooks{outbox: outbox, client: subscriber.Client(), maxAttempts: 2,
+		subscribers: []domain.Subscriber{{Name: "cb", URL: subscriber.URL, Secret: "cb"}}}}
+	ctx := context.Background()
+	now := time.Now()
+	_, err = outbox.Enqueue(ctx, []domain.OutboxMessage{
+		{Subscriber: "cb", Event: domain.TaskEvent{ID: "e1"}, NextAttempt: now},
+		{Subscriber: "removed", Event: domain.TaskEvent{ID: "e2"}, NextAttempt: now},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	s.deliverPending(ctx)
+	pending, _ := outbox.Pending(ctx, now.Add(hookMaxBackoff), 10)
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("Expected 2 messages for retry after errors, but got %+v", pending)
+	}
+	if pending[0].NextAttempt.Before(now.Add(defaultHookRetryPeriod)) {
+		t.Errorf("Expected retry after %v, but got %v", defaultHookRetryPeriod, pending[0].NextAttempt)
+	}
+	// retry of the messages is due
+	for i := range pending {
+		pending[i].NextAttempt = now
+	}
+	_ = outbox.Update(ctx, pending)
+	s.deliverPending(ctx)
+	pending, _ = outbox.Pending(ctx, now.Add(hookMaxBackoff), 10)
+	if len(pending) != 0 {
+		t.Errorf("Expected delivered message and dead message of unknown subscriber, but got %+v", pending)
+	}
+	if calls != 2 || len(signatures) != 2 || signatures[1] != "e1" {
+		t.Errorf("Expected 2 signed callbacks of e1, but got %d calls, %v", calls, signatures)
+	}
+}
+
+func TestHookBackoff(t *testing.T) {
+	tCases := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{attempts: 1, delay: defaultHookRetryPeriod},
+		{attempts: 3, delay: 4 * defaultHookRetryPeriod},
+		{attempts: 20, delay: hookMaxBackoff},
+	}
+	for _, tc := range tCases {
+		if delay := hookBackoff(tc.attempts); delay != tc.delay {
+			t.Errorf("Expected delay %v after %d attempts, but got %v", tc.delay, tc.attempts, delay)
+		}
+	}
+}
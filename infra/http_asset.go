@@ -1,17 +1,29 @@
 package infra
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
 	"git.countmax.ru/countmax/commonapi/domain"
 	"github.com/labstack/echo/v4"
 )
 
+// depth limits of the asset hierarchy
+const (
+	defaultAssetChildrenDepth int = 1
+	defaultAssetTreeDepth     int = 3
+	maxAssetDepth             int = 10
+)
+
 var (
 	errAssetNotFound error = errors.New("assets not found")
 	errEmptyID       error = errors.New("empty id not allowed")
+	errAssetDepth    error = fmt.Errorf("depth must be from 1 to %d", maxAssetDepth)
 )
 
 // AssetsResponse http wrapper with metadata
@@ -20,6 +32,12 @@ type AssetsResponse struct {
 	Metadata
 }
 
+// AssetNodesResponse http wrapper of the asset hierarchy
+type AssetNodesResponse struct {
+	Data domain.AssetNodes `json:"data"`
+	Metadata
+}
+
 // apiAssets godoc
 // @Summary Get all assets
 // @Description get slice of Assets with offset, limit parameters
@@ -28,6 +46,12 @@ type AssetsResponse struct {
 // @Tags intraservice
 // @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
+// @Param filter[field] query string false "filter[field][op]=value, op: eq (default),ne,gt,gte,lt,lte,like; fields: id,name,serviceDeskParentId,changed,serviceDeskId; changed in RFC3339 or 2006-01-02 (local time)"
+// @Param field.name query string false "custom field of the intraservice.asset_fields, example: field.serial=A123"
+// @Param q query string false "free-text search by name"
+// @Param sort query string false "comma separated fields, minus for descending order, example: -name,id"
+// @Param after query string false "opaque cursor next_cursor of the previous page, enables keyset paging instead of offset"
+// @Param count query bool false "default=true, false skips total count of rows (total=-1)"
 // @Success 200 {object} infra.AssetsResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
@@ -36,8 +60,21 @@ type AssetsResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/assets [get]
 func (s *Server) apiAssets(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
-	assets, count, err := s.assetRepo.FindAll(offset, limit)
+	query, err := s.getListQuery(c, domain.AssetListFields)
+	if err == nil {
+		err = s.getAssetFieldFilters(c, &query)
+	}
+	if err != nil {
+		log.Warnf("bad request apiAssets, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if len(query.After) > 0 {
+		// keyset paging, page starts after the cursor
+		offset = 0
+	}
+	assets, count, err := s.getAssetRepo().FindAll(c.Request().Context(), query, offset, limit)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
@@ -48,10 +85,11 @@ func (s *Server) apiAssets(c echo.Context) error {
 		Data: assets,
 		Metadata: Metadata{
 			ResultSet: ResultSet{
-				Count:  int64(len(assets)),
-				Offset: offset,
-				Limit:  limit,
-				Total:  count,
+				Count:      int64(len(assets)),
+				Offset:     offset,
+				Limit:      limit,
+				Total:      count,
+				NextCursor: nextCursor(query, domain.AssetListFields, assets, limit),
 			},
 		},
 	}
@@ -73,23 +111,53 @@ func (s *Server) apiAssets(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/assets/{id} [get]
 func (s *Server) apiAssetByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiAssetByID, %v", errEmptyID)
+		log.Errorf("bad request apiAssetByID, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
-	asset, err := s.assetRepo.FindByID(atoi64(id))
+	asset, err := s.getAssetRepo().FindByID(c.Request().Context(), atoi64(id))
 	if err != nil {
-		s.log.Errorf("bad request apiAssetByID, error %v", err)
+		log.Errorf("bad request apiAssetByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if asset == nil {
-		s.log.Warnf("apiAssetByID for id=%s not found", id)
+		log.Warnf("apiAssetByID for id=%s not found", id)
 		return c.JSON(http.StatusNotFound, ErrNotFound(errAssetNotFound))
 	}
 	return c.JSON(http.StatusOK, asset)
 }
 
+// getAssetFieldFilters adds filters by custom fields field.name=value of the mapping intraservice.asset_fields
+func (s *Server) getAssetFieldFilters(c echo.Context, q *domain.ListQuery) error {
+	params := c.QueryParams()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if strings.HasPrefix(key, domain.AssetFieldPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	// stable order of the filters for the same query
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, domain.AssetFieldPrefix)
+		if _, ok := s.assetFields[name]; !ok {
+			allowed := make([]string, 0, len(s.assetFields))
+			for f := range s.assetFields {
+				allowed = append(allowed, f)
+			}
+			sort.Strings(allowed)
+			return fmt.Errorf("filter by custom field %s not allowed, allowed fields: %s", name,
+				strings.Join(allowed, ","))
+		}
+		for _, value := range params[key] {
+			q.Filters = append(q.Filters, domain.Filter{Field: key, Op: domain.OpEq, Value: value})
+		}
+	}
+	return nil
+}
+
 func atoi64(s string) int64 {
 	i, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
@@ -97,3 +165,161 @@ func atoi64(s string) int64 {
 	}
 	return i
 }
+
+// apiAssetChildren godoc
+// @Summary Get descendants of the asset
+// @Description Get descendants of the Intraservice asset up to depth ordered by depth,
+// @Description assets of the hierarchy are addressed by serviceDeskId
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "serviceDeskId of the asset"
+// @Param depth query integer false "default=1, max=10"
+// @Success 200 {object} infra.AssetNodesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/assets/{id}/children [get]
+func (s *Server) apiAssetChildren(c echo.Context) error {
+	return s.assetHierarchy(c, "apiAssetChildren", defaultAssetChildrenDepth, s.getAssetRepo().FindDescendants)
+}
+
+// apiAssetAncestors godoc
+// @Summary Get ancestors of the asset
+// @Description Get parents of the Intraservice asset up to depth ordered from the parent to the root,
+// @Description assets of the hierarchy are addressed by serviceDeskId
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "serviceDeskId of the asset"
+// @Param depth query integer false "default=10, max=10"
+// @Success 200 {object} infra.AssetNodesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/assets/{id}/ancestors [get]
+func (s *Server) apiAssetAncestors(c echo.Context) error {
+	return s.assetHierarchy(c, "apiAssetAncestors", maxAssetDepth, s.getAssetRepo().FindAncestors)
+}
+
+// assetHierarchy returns related assets of the asset without the asset itself
+func (s *Server) assetHierarchy(c echo.Context, name string, defaultDepth int,
+	find func(context.Context, int64, int) (domain.AssetNodes, error)) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id <= 0 {
+		log.Errorf("bad request %s, %v", name, errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	depth, err := getAssetDepth(c, defaultDepth)
+	if err != nil {
+		log.Warnf("bad request %s, %v", name, err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	nodes, err := find(c.Request().Context(), id, depth)
+	if err != nil {
+		log.Errorf("%s for id=%d, error %v", name, id, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	if len(nodes) == 0 {
+		log.Warnf("%s for id=%d not found", name, id)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errAssetNotFound))
+	}
+	related := make(domain.AssetNodes, 0, len(nodes)-1)
+	for _, node := range nodes {
+		if node.Depth > 0 {
+			related = append(related, node)
+		}
+	}
+	count := int64(len(related))
+	return c.JSON(http.StatusOK, AssetNodesResponse{Data: related,
+		Metadata: Metadata{ResultSet: ResultSet{Count: count, Limit: count, Total: count}}})
+}
+
+// apiAssetTree godoc
+// @Summary Get tree of the assets
+// @Description Get hierarchy of the Intraservice assets (customer -> object -> device) with nested children
+// @Description up to depth, without root - trees of all top-level assets
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param root query integer false "serviceDeskId of the root asset"
+// @Param depth query integer false "default=3, max=10"
+// @Success 200 {object} infra.AssetNodesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/assets/tree [get]
+func (s *Server) apiAssetTree(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	var root int64
+	if param := c.QueryParam("root"); param != "" {
+		if root = atoi64(param); root <= 0 {
+			log.Warnf("bad request apiAssetTree, root=%s", param)
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(fmt.Errorf("wrong root %s", param)))
+		}
+	}
+	depth, err := getAssetDepth(c, defaultAssetTreeDepth)
+	if err != nil {
+		log.Warnf("bad request apiAssetTree, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	nodes, err := s.getAssetRepo().FindDescendants(c.Request().Context(), root, depth)
+	if err != nil {
+		log.Errorf("apiAssetTree for root=%d, error %v", root, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	if len(nodes) == 0 {
+		log.Warnf("apiAssetTree for root=%d not found", root)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errAssetNotFound))
+	}
+	tree := buildAssetTree(nodes)
+	return c.JSON(http.StatusOK, AssetNodesResponse{Data: tree,
+		Metadata: Metadata{ResultSet: ResultSet{Count: int64(len(tree)), Total: int64(len(nodes))}}})
+}
+
+// getAssetDepth returns depth parameter of the hierarchy within limits
+func getAssetDepth(c echo.Context, defaultDepth int) (int, error) {
+	param := c.QueryParam("depth")
+	if param == "" {
+		return defaultDepth, nil
+	}
+	depth, err := strconv.Atoi(param)
+	if err != nil || depth < 1 || depth > maxAssetDepth {
+		return 0, errAssetDepth
+	}
+	return depth, nil
+}
+
+// buildAssetTree nests nodes ordered by depth into their parents, returns nodes of depth 0
+func buildAssetTree(nodes domain.AssetNodes) domain.AssetNodes {
+	byID := make(map[int64]*domain.AssetNode, len(nodes))
+	maxDepth := 0
+	for i := range nodes {
+		byID[nodes[i].ServiceDeskID] = &nodes[i]
+		if nodes[i].Depth > maxDepth {
+			maxDepth = nodes[i].Depth
+		}
+	}
+	// children are complete before they are copied into the parent, so the deepest level goes first
+	for depth := maxDepth; depth > 0; depth-- {
+		for i := range nodes {
+			if nodes[i].Depth != depth {
+				continue
+			}
+			if parent, ok := byID[nodes[i].ServiceDeskParentID]; ok && parent.Depth == depth-1 {
+				parent.Children = append(parent.Children, nodes[i])
+			}
+		}
+	}
+	roots := make(domain.AssetNodes, 0)
+	for _, node := range nodes {
+		if node.Depth == 0 {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
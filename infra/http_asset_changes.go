@@ -0,0 +1,312 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// limits of the feed of the asset changes
+const (
+	maxAssetChangesLimit   int64         = 1000
+	defaultAssetPollPeriod time.Duration = 30 * time.Second
+	// sseHeartbeat period of the comments keeping idle stream alive through proxies
+	sseHeartbeat time.Duration = 15 * time.Second
+	// assetFeedBuffer batches of the changes buffered for the subscriber, slow subscriber is disconnected
+	assetFeedBuffer int = 16
+	// assetChangesSort sort signature of the cursor of the feed
+	assetChangesSort string = "changed,serviceDeskId"
+)
+
+var (
+	errAssetSince       error = errors.New("since parameter in RFC3339 format or after cursor required")
+	errAssetFeedDropped error = errors.New("subscriber is too slow, reconnect with Last-Event-ID")
+)
+
+// assetFeed fan-out of the asset changes found by the poller to the stream subscribers
+type assetFeed struct {
+	mu   sync.Mutex
+	subs map[chan domain.Assets]struct{}
+	// since, afterID position of the poller in the changes
+	since   time.Time
+	afterID int64
+}
+
+// subscribe returns channel of the changes, channel is closed if subscriber doesn't read it in time
+func (f *assetFeed) subscribe() chan domain.Assets {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[chan domain.Assets]struct{})
+	}
+	ch := make(chan domain.Assets, assetFeedBuffer)
+	f.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes subscriber
+func (f *assetFeed) unsubscribe(ch chan domain.Assets) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends changes to the subscribers without waiting, returns count of the subscribers
+func (f *assetFeed) publish(assets domain.Assets) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- assets:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+	return len(f.subs)
+}
+
+// assetChangesPoller reads changes of the assets after the last seen change and publishes them to the stream
+func (s *Server) assetChangesPoller(period time.Duration, cancel <-chan struct{}) {
+	if period <= 0 {
+		period = defaultAssetPollPeriod
+	}
+	s.log.Infof("starting assetChangesPoller with scheduller %v", period)
+	defer s.log.Info("stopped assetChangesPoller")
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-tick.C:
+			s.pollAssetChanges(context.Background())
+		}
+	}
+}
+
+// pollAssetChanges publishes pages of the changes, without subscribers the position moves to the current time
+func (s *Server) pollAssetChanges(ctx context.Context) {
+	feed := &s.assetFeed
+	feed.mu.Lock()
+	idle := len(feed.subs) == 0
+	if idle || feed.since.IsZero() {
+		feed.since, feed.afterID = time.Now(), 0
+	}
+	since, afterID := feed.since, feed.afterID
+	feed.mu.Unlock()
+	if idle || !s.isReadyRepo(repoISDB) {
+		return
+	}
+	for {
+		assets, err := s.getAssetRepo().FindChanged(ctx, since, afterID, maxAssetChangesLimit)
+		if err != nil {
+			s.log.Errorf("pollAssetChanges, FindChanged error %v", err)
+			return
+		}
+		if len(assets) == 0 {
+			return
+		}
+		last := assets[len(assets)-1]
+		since, afterID = last.Changed, last.ServiceDeskID
+		feed.mu.Lock()
+		feed.since, feed.afterID = since, afterID
+		feed.mu.Unlock()
+		if feed.publish(assets) == 0 || int64(len(assets)) < maxAssetChangesLimit {
+			return
+		}
+	}
+}
+
+// changesCursor returns cursor of the feed after the asset
+func changesCursor(a domain.Asset) string {
+	return encodeCursor(listCursor{Sort: assetChangesSort,
+		Values: []string{a.Changed.Format(time.RFC3339Nano), strconv.FormatInt(a.ServiceDeskID, 10)}})
+}
+
+// getChangesPosition returns position in the feed by cursor (after parameter or Last-Event-ID header of the stream)
+// or by since parameter, ok is false if the position isn't set
+func getChangesPosition(c echo.Context) (since time.Time, afterID int64, ok bool, err error) {
+	raw := c.QueryParam("after")
+	if raw == "" {
+		raw = c.Request().Header.Get("Last-Event-ID")
+	}
+	if raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			return since, 0, false, err
+		}
+		if cursor.Sort != assetChangesSort || len(cursor.Values) != 2 {
+			return since, 0, false, fmt.Errorf("wrong cursor %s", raw)
+		}
+		since, err = time.Parse(time.RFC3339Nano, cursor.Values[0])
+		if err != nil {
+			return since, 0, false, fmt.Errorf("wrong cursor %s", raw)
+		}
+		afterID, err = strconv.ParseInt(cursor.Values[1], 10, 64)
+		if err != nil {
+			return since, 0, false, fmt.Errorf("wrong cursor %s", raw)
+		}
+		return since, afterID, true, nil
+	}
+	raw = c.QueryParam("since")
+	if raw == "" {
+		return since, 0, false, nil
+	}
+	since, err = time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return since, 0, false, fmt.Errorf("wrong since %s, %v", raw, errAssetSince)
+	}
+	return since, 0, true, nil
+}
+
+// apiAssetChanges godoc
+// @Summary Get changed assets
+// @Description Get assets changed after since ordered by changed and serviceDeskId,
+// @Description next page is requested with after=next_cursor, cursor is empty on the last page
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param since query string false "RFC3339 time, required without after"
+// @Param after query string false "opaque cursor next_cursor of the previous page"
+// @Param limit query integer false "default=10, max=1000"
+// @Success 200 {object} infra.AssetsResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/assets/changes [get]
+func (s *Server) apiAssetChanges(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	_, limit := s.getPageParams(c)
+	if limit > maxAssetChangesLimit {
+		limit = maxAssetChangesLimit
+	}
+	since, afterID, ok, err := getChangesPosition(c)
+	if err == nil && !ok {
+		err = errAssetSince
+	}
+	if err != nil {
+		log.Warnf("bad request apiAssetChanges, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	assets, err := s.getAssetRepo().FindChanged(c.Request().Context(), since, afterID, limit)
+	if err != nil {
+		log.Errorf("apiAssetChanges since %v, error %v", since, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	response := AssetsResponse{Data: assets,
+		Metadata: Metadata{ResultSet: ResultSet{Count: int64(len(assets)), Limit: limit, Total: -1}}}
+	if int64(len(assets)) == limit {
+		response.ResultSet.NextCursor = changesCursor(assets[len(assets)-1])
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// apiAssetChangesStream godoc
+// @Summary Stream of the changed assets
+// @Description Server-Sent Events with the changed assets found by the background poller: event asset,
+// @Description id is the cursor of the change. With since, after or Last-Event-ID the stream starts with
+// @Description the changes after this position, events may repeat, so subscribers must apply them idempotently
+// @Produce text/event-stream
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param since query string false "RFC3339 time of the catch-up"
+// @Param after query string false "cursor of the catch-up, Last-Event-ID header is used on reconnect"
+// @Success 200 {object} domain.Asset
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Router /v2/assets/changes/stream [get]
+func (s *Server) apiAssetChangesStream(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	since, afterID, catchUp, err := getChangesPosition(c)
+	if err != nil {
+		log.Warnf("bad request apiAssetChangesStream, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	// subscription before the catch-up, so the changes between them aren't lost
+	ch := s.assetFeed.subscribe()
+	defer s.assetFeed.unsubscribe(ch)
+	ctx := c.Request().Context()
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	var last *domain.Asset
+	send := func(assets domain.Assets) error {
+		for i := range assets {
+			if last != nil && !changedAfter(assets[i], *last) {
+				continue
+			}
+			data, err := json.Marshal(assets[i])
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %s\nevent: asset\ndata: %s\n\n", changesCursor(assets[i]), data)
+			if err != nil {
+				return err
+			}
+			last = &assets[i]
+		}
+		w.Flush()
+		return nil
+	}
+	for catchUp {
+		assets, err := s.getAssetRepo().FindChanged(ctx, since, afterID, maxAssetChangesLimit)
+		if err != nil {
+			log.Errorf("apiAssetChangesStream since %v, error %v", since, err)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+			w.Flush()
+			return nil
+		}
+		if err = send(assets); err != nil {
+			return nil
+		}
+		if int64(len(assets)) < maxAssetChangesLimit {
+			break
+		}
+		since, afterID = assets[len(assets)-1].Changed, assets[len(assets)-1].ServiceDeskID
+	}
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case assets, ok := <-ch:
+			if !ok {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", errAssetFeedDropped)
+				w.Flush()
+				return nil
+			}
+			if err := send(assets); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// changedAfter returns true if change of the asset a is after change of the asset b in the feed order
+func changedAfter(a, b domain.Asset) bool {
+	if a.Changed.Equal(b.Changed) {
+		return a.ServiceDeskID > b.ServiceDeskID
+	}
+	return a.Changed.After(b.Changed)
+}
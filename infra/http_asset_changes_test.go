@@ -0,0 +1,98 @@
+package infra
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestAPIAssetChanges(t *testing.T) {
+	base := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	repo := &fakeAssetRepo{assets: domain.Assets{
+		{ID: 1, ServiceDeskID: 11, Changed: base},
+		{ID: 2, ServiceDeskID: 12, Changed: base.Add(time.Minute)},
+		{ID: 3, ServiceDeskID: 13, Changed: base.Add(time.Minute)},
+		{ID: 4, ServiceDeskID: 14, Changed: base.Add(2 * time.Minute)},
+	}}
+	s := &Server{log: zap.NewNop().Sugar(), assetRepo: repo}
+	e := echo.New()
+	e.GET("/v2/assets/changes", s.apiAssetChanges)
+	get := func(query string) (int, AssetsResponse) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/assets/changes"+query, nil))
+		resp := AssetsResponse{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	// pages of 2 assets, the second page starts inside the same changed time
+	code, resp := get("?limit=2&since=" + url.QueryEscape(base.Format(time.RFC3339)))
+	if code != http.StatusOK || len(resp.Data) != 2 || resp.Data[1].ID != 3 || resp.ResultSet.NextCursor == "" {
+		t.Fatalf("Expected assets 2, 3 with cursor, but got %d %+v", code, resp)
+	}
+	code, resp = get("?limit=2&after=" + resp.ResultSet.NextCursor)
+	if code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].ID != 4 || resp.ResultSet.NextCursor != "" {
+		t.Errorf("Expected last asset 4 without cursor, but got %d %+v", code, resp)
+	}
+	for _, query := range []string{"", "?since=yesterday", "?after=broken"} {
+		if code, _ = get(query); code != http.StatusBadRequest {
+			t.Errorf("Expected code=400 for %q, but got %d", query, code)
+		}
+	}
+}
+
+func TestAPIAssetChangesStream(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	repo := &fakeAssetRepo{assets: domain.Assets{{ID: 1, ServiceDeskID: 11, Changed: base}}}
+	s := &Server{log: zap.NewNop().Sugar(), assetRepo: repo, repoReady: map[string]bool{repoISDB: true}}
+	e := echo.New()
+	e.GET("/v2/assets/changes/stream", s.apiAssetChangesStream)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/v2/assets/changes/stream?since="+url.QueryEscape(base.Add(-time.Second).Format(time.RFC3339)), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected stream, but error %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, but got %s", ct)
+	}
+	events := make(chan domain.Asset)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				a := domain.Asset{}
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &a)
+				events <- a
+			}
+		}
+		close(events)
+	}()
+	// catch-up from since
+	if a := <-events; a.ID != 1 {
+		t.Fatalf("Expected catch-up asset 1, but got %+v", a)
+	}
+	// the first poll sets position, the second publishes the new change
+	s.pollAssetChanges(ctx)
+	repo.mu.Lock()
+	repo.assets = append(repo.assets, domain.Asset{ID: 2, ServiceDeskID: 12, Changed: time.Now().Add(time.Minute)})
+	repo.mu.Unlock()
+	s.pollAssetChanges(ctx)
+	if a := <-events; a.ID != 2 {
+		t.Errorf("Expected polled asset 2, but got %+v", a)
+	}
+}
@@ -0,0 +1,212 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeAssetRepo hierarchy of the assets in memory, walks it as recursive CTE of the AssetSQLRepo
+type fakeAssetRepo struct {
+	domain.AssetRepo
+	mu     sync.Mutex
+	assets domain.Assets
+	query  domain.ListQuery
+}
+
+func (f *fakeAssetRepo) FindChanged(_ context.Context, since time.Time, afterID,
+	limit int64) (domain.Assets, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(domain.Assets, 0)
+	for _, a := range f.assets {
+		if a.Changed.After(since) || (afterID > 0 && a.Changed.Equal(since) && a.ServiceDeskID > afterID) {
+			result = append(result, a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return changedAfter(result[j], result[i]) })
+	if int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (f *fakeAssetRepo) FindAll(_ context.Context, q domain.ListQuery, _, _ int64) (domain.Assets, int64, error) {
+	f.query = q
+	return f.assets, int64(len(f.assets)), nil
+}
+
+func (f *fakeAssetRepo) FindDescendants(_ context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	level := make(domain.AssetNodes, 0)
+	for _, a := range f.assets {
+		if (id == 0 && a.ServiceDeskParentID == 0) || a.ServiceDeskID == id {
+			level = append(level, domain.AssetNode{Asset: a})
+		}
+	}
+	result := append(domain.AssetNodes{}, level...)
+	for d := 1; d <= depth && len(level) > 0; d++ {
+		next := make(domain.AssetNodes, 0)
+		for _, parent := range level {
+			for _, a := range f.assets {
+				if a.ServiceDeskParentID == parent.ServiceDeskID {
+					next = append(next, domain.AssetNode{Asset: a, Depth: d})
+				}
+			}
+		}
+		result, level = append(result, next...), next
+	}
+	return result, nil
+}
+
+func (f *fakeAssetRepo) FindAncestors(_ context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	result := make(domain.AssetNodes, 0)
+	for d := 0; d <= depth && id != 0; d++ {
+		found := false
+		for _, a := range f.assets {
+			if a.ServiceDeskID == id {
+				result = append(result, domain.AssetNode{Asset: a, Depth: d})
+				id, found = a.ServiceDeskParentID, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return result, nil
+}
+
+// newAssetHierarchy customer 1 -> objects 10, 11 -> devices 100, 101 of the object 10
+func newAssetHierarchy() *fakeAssetRepo {
+	return &fakeAssetRepo{assets: domain.Assets{
+		{ServiceDeskID: 1, Name: "customer"},
+		{ServiceDeskID: 10, ServiceDeskParentID: 1, Name: "mall"},
+		{ServiceDeskID: 11, ServiceDeskParentID: 1, Name: "store"},
+		{ID: 5001, ServiceDeskID: 100, ServiceDeskParentID: 10, Name: "entrance"},
+		{ID: 5002, ServiceDeskID: 101, ServiceDeskParentID: 10, Name: "exit"},
+		{ServiceDeskID: 2, Name: "other customer"},
+	}}
+}
+
+func TestAPIAssetHierarchy(t *testing.T) {
+	tCases := []struct {
+		title string
+		path  string
+		code  int
+		ids   []int64
+	}{
+		{title: "children", path: "/v2/assets/1/children", code: http.StatusOK, ids: []int64{10, 11}},
+		{title: "descendants", path: "/v2/assets/1/children?depth=2", code: http.StatusOK,
+			ids: []int64{10, 11, 100, 101}},
+		{title: "leaf", path: "/v2/assets/100/children", code: http.StatusOK, ids: []int64{}},
+		{title: "ancestors", path: "/v2/assets/101/ancestors", code: http.StatusOK, ids: []int64{10, 1}},
+		{title: "parent only", path: "/v2/assets/101/ancestors?depth=1", code: http.StatusOK, ids: []int64{10}},
+		{title: "not found", path: "/v2/assets/7/children", code: http.StatusNotFound},
+		{title: "depth limit", path: "/v2/assets/1/children?depth=11", code: http.StatusBadRequest},
+		{title: "wrong id", path: "/v2/assets/x/ancestors", code: http.StatusBadRequest},
+	}
+	s := &Server{log: zap.NewNop().Sugar(), assetRepo: newAssetHierarchy()}
+	e := echo.New()
+	e.GET("/v2/assets/:id/children", s.apiAssetChildren)
+	e.GET("/v2/assets/:id/ancestors", s.apiAssetAncestors)
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := AssetNodesResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected assets in response, but error %v", err)
+			}
+			if len(resp.Data) != len(tc.ids) {
+				t.Fatalf("Expected %d assets, but got %+v", len(tc.ids), resp.Data)
+			}
+			for i, node := range resp.Data {
+				if node.ServiceDeskID != tc.ids[i] {
+					t.Errorf("Expected asset %d at %d, but got %d", tc.ids[i], i, node.ServiceDeskID)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIAssetTree(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar(), assetRepo: newAssetHierarchy()}
+	e := echo.New()
+	e.GET("/v2/assets/tree", s.apiAssetTree)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/assets/tree", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected code=200, but got %d, body=%s", rec.Code, rec.Body.String())
+	}
+	resp := AssetNodesResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected tree in response, but error %v", err)
+	}
+	if len(resp.Data) != 2 || resp.ResultSet.Total != 6 {
+		t.Fatalf("Expected 2 customers of 6 assets, but got %d of %d", len(resp.Data), resp.ResultSet.Total)
+	}
+	customer := resp.Data[0]
+	if len(customer.Children) != 2 || len(customer.Children[0].Children) != 2 ||
+		customer.Children[0].Children[1].ID != 5002 {
+		t.Errorf("Expected customer -> 2 objects -> 2 devices, but got %+v", customer)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/assets/tree?root=10&depth=1", nil))
+	resp = AssetNodesResponse{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Data) != 1 || resp.Data[0].ServiceDeskID != 10 || len(resp.Data[0].Children) != 2 {
+		t.Errorf("Expected object 10 with 2 devices, but got %+v", resp.Data)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/assets/tree?root=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected code=400 of wrong root, but got %d", rec.Code)
+	}
+}
+
+func TestAPIAssetsFieldFilter(t *testing.T) {
+	tCases := []struct {
+		title   string
+		query   string
+		code    int
+		filters []domain.Filter
+	}{
+		{title: "custom field", query: "?field.serial=A123&filter[name][like]=mall", code: http.StatusOK,
+			filters: []domain.Filter{{Field: "name", Op: domain.OpLike, Value: "mall"},
+				{Field: "field.serial", Op: domain.OpEq, Value: "A123"}}},
+		{title: "not mapped field", query: "?field.color=red", code: http.StatusBadRequest},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := newAssetHierarchy()
+			s := &Server{log: zap.NewNop().Sugar(), assetRepo: repo,
+				assetFields: domain.AssetFields{"serial": {ID: 63, Type: domain.AssetFieldString}}}
+			e := echo.New()
+			e.GET("/v2/assets", s.apiAssets)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/assets"+tc.query, nil))
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.code == http.StatusOK && !reflect.DeepEqual(repo.query.Filters, tc.filters) {
+				t.Errorf("Expected filters %v, but got %v", tc.filters, repo.query.Filters)
+			}
+		})
+	}
+}
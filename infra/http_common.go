@@ -1,13 +1,16 @@
 package infra
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"git.countmax.ru/countmax/commonapi/domain"
 	"github.com/labstack/echo/v4"
 )
 
@@ -57,6 +60,15 @@ func (s *Server) getPageParams(c echo.Context) (offset, limit int64) {
 	return
 }
 
+// requestContext - middleware puts request id to the context of the request, repositories write it to the logs
+func (s *Server) requestContext(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rID := c.Response().Header().Get(echo.HeaderXRequestID)
+		c.SetRequest(c.Request().WithContext(domain.WithRequestID(c.Request().Context(), rID)))
+		return next(c)
+	}
+}
+
 // customHTTPLogger - middleware of logger and metric duration
 func (s *Server) customHTTPLogger(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -74,7 +86,7 @@ func (s *Server) customHTTPLogger(next echo.HandlerFunc) echo.HandlerFunc {
 			"code", code,
 			"size", c.Response().Size,
 			"duration", time.Since(start).String(),
-			requestIDName, rID)
+			domain.RequestIDKey, rID)
 		host, err := os.Hostname()
 		if err != nil {
 			host = "_localhost"
@@ -95,25 +107,33 @@ func (s *Server) customHTTPLogger(next echo.HandlerFunc) echo.HandlerFunc {
 // Generated by https://quicktype.io
 
 type TokenPayload struct {
-	AZP   string  `json:"azp"`
-	Exp   float64 `json:"exp"`
-	Name  string  `json:"name"`
-	Email string  `json:"email"`
-	Token string  `json:"token"`
-}
-
-// apiHealthCheck returk 200 ok if repository is connected
-// @Summary healthcheck service eq repository connected
-// @Tags health
-// @Success 200 {object} infra.SuccessResponse
-// @Failure 500 {object} infra.ErrResponse
-// @Router /health [get]
-func (s *Server) apiHealthCheck(c echo.Context) error {
-	err := s.healthCheck()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	Subject  string   `json:"sub"`
+	Username string   `json:"preferred_username"`
+	AZP      string   `json:"azp"`
+	Exp      float64  `json:"exp"`
+	Name     string   `json:"name"`
+	Email    string   `json:"email"`
+	Layouts  ACLClaim `json:"layouts"`
+	Token    string   `json:"token"`
+}
+
+// ACLClaim comma separated list of accessible layout ids,
+// keycloak mapper can put it to the token as string or as array of strings
+type ACLClaim string
+
+// UnmarshalJSON implementation of json.Unmarshaler
+func (a *ACLClaim) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*a = ACLClaim(strings.Join(list, ","))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
 	}
-	return c.JSON(http.StatusOK, OkStatus("OK"))
+	*a = ACLClaim(str)
+	return nil
 }
 
 // Metadata - metadata, page, limit, offset... etc...
@@ -126,7 +146,10 @@ type ResultSet struct {
 	Count  int64 `json:"count"`
 	Offset int64 `json:"offset"`
 	Limit  int64 `json:"limit"`
-	Total  int64 `json:"total"`
+	// Total -1 if count=false
+	Total int64 `json:"total"`
+	// NextCursor cursor for the parameter after of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // SuccessResponse structure for json response success results
@@ -212,6 +235,62 @@ func ErrUnAuthorized(err error) ErrResponse {
 	}
 }
 
+// ErrForbidden - wrapper for make err structure for not accessible resource
+func ErrForbidden(err error) ErrResponse {
+	Error := ""
+	if err != nil {
+		Error = err.Error()
+	}
+	return ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusForbidden,
+		StatusText:     http.StatusText(http.StatusForbidden),
+		ErrorText:      Error,
+	}
+}
+
+// ErrConflict - wrapper for make err structure for already existing resource
+func ErrConflict(err error) ErrResponse {
+	Error := ""
+	if err != nil {
+		Error = err.Error()
+	}
+	return ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     http.StatusText(http.StatusConflict),
+		ErrorText:      Error,
+	}
+}
+
+// ErrPreconditionFailed - wrapper for make err structure for resource changed after If-Match version
+func ErrPreconditionFailed(err error) ErrResponse {
+	Error := ""
+	if err != nil {
+		Error = err.Error()
+	}
+	return ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+		StatusText:     http.StatusText(http.StatusPreconditionFailed),
+		ErrorText:      Error,
+	}
+}
+
+// ErrServiceUnavailable - wrapper for make err structure for request while dependency is down
+func ErrServiceUnavailable(err error) ErrResponse {
+	Error := ""
+	if err != nil {
+		Error = err.Error()
+	}
+	return ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusServiceUnavailable,
+		StatusText:     http.StatusText(http.StatusServiceUnavailable),
+		ErrorText:      Error,
+	}
+}
+
 // ErrUnsupportedFormat - 415 error implementation
 var ErrUnsupportedFormat = &ErrResponse{HTTPStatusCode: http.StatusUnsupportedMediaType,
 	StatusText: "415 - Unsupported Media Type."}
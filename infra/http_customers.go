@@ -1,7 +1,9 @@
 package infra
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -19,8 +21,19 @@ var (
 	errDB                     error          = errors.New("internal db error")
 	reDBType                  *regexp.Regexp = regexp.MustCompile(`(?m)[1,2,3,4,10]`)
 	errWrongDBType            error          = errors.New("dbType wrong format, allow only 1,2,3,4,10")
+	errCustomerConfigExists   error          = errors.New("customer config already exists")
+	errWrongSdServiceID       error          = errors.New("sdServiceId must be positive")
+	errWrongSdCreatorID       error          = errors.New("sdCreatorId must be positive")
+	errSdServiceNotFound      error          = errors.New("sdServiceId not found in Intraservice")
+	errSdCreatorNotFound      error          = errors.New("sdCreatorId not found in Intraservice")
+	errIntraserviceAPI        error          = errors.New("intraservice api error")
 )
 
+// customerAuditResource returns resource of the audit records of the customer config
+func customerAuditResource(id int64) string {
+	return fmt.Sprintf("customer/%d", id)
+}
+
 // AssetsResponse http wrapper with metadata
 type CustomerResponse struct {
 	Data domain.CustomerConfigs `json:"data"`
@@ -30,12 +43,18 @@ type CustomerResponse struct {
 // apiCustomerConfigs godoc
 // @Summary Get all customer configurations
 // @Description get slice of customer configuration with offset, limit, active parameters
+// @Description next_cursor is returned for requests with filter, q, sort, after or count=false
 // @Produce  json
 // @Security ApiKeyAuth
 // @Tags cm_info
 // @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
 // @Param active query string false "default=true"
+// @Param filter[field] query string false "filter[field][op]=value, op: eq (default),ne,gt,gte,lt,lte,like; fields: customerId,customerName,customerTypeId,sdServiceId,sdCreatorId,sdDestination"
+// @Param q query string false "free-text search by customerName, sdDestination"
+// @Param sort query string false "comma separated fields, minus for descending order, example: -name,id"
+// @Param after query string false "opaque cursor next_cursor of the previous page, enables keyset paging instead of offset"
+// @Param count query bool false "default=true, false skips total count of rows (total=-1)"
 // @Success 200 {object} infra.CustomerResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
@@ -44,14 +63,24 @@ type CustomerResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/customers/configs [get]
 func (s *Server) apiCustomerConfigs(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
+	query, err := s.getListQuery(c, domain.CustomerConfigListFields)
+	if err != nil {
+		log.Warnf("bad request apiCustomerConfigs, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if len(query.After) > 0 {
+		// keyset paging, page starts after the cursor
+		offset = 0
+	}
 	enabled, err := strconv.ParseBool(c.QueryParam("active"))
 	if err != nil {
 		enabled = true
 	}
-	customers, count, err := s.infoRepo.FindCustomersConfig(offset, limit, enabled)
+	customers, count, err := s.getInfoRepo().FindCustomersConfig(c.Request().Context(), query, offset, limit, enabled)
 	if err != nil {
-		s.log.Errorf("infoRepo.FindCustomersConfig, error %v", err)
+		log.Errorf("infoRepo.FindCustomersConfig, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
 	}
 	if customers == nil || len(customers) == 0 {
@@ -61,10 +90,11 @@ func (s *Server) apiCustomerConfigs(c echo.Context) error {
 		Data: customers,
 		Metadata: Metadata{
 			ResultSet: ResultSet{
-				Count:  int64(len(customers)),
-				Offset: offset,
-				Limit:  limit,
-				Total:  count,
+				Count:      int64(len(customers)),
+				Offset:     offset,
+				Limit:      limit,
+				Total:      count,
+				NextCursor: infoNextCursor(query, domain.CustomerConfigListFields, customers, limit),
 			},
 		},
 	}
@@ -86,23 +116,268 @@ func (s *Server) apiCustomerConfigs(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/customers/{id}/configs [get]
 func (s *Server) apiCustomerConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiCustomerConfigByID, %v", errEmptyID)
+		log.Errorf("bad request apiCustomerConfigByID, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
-	customer, err := s.infoRepo.FindCustomerConfig(atoi64(id))
+	customer, err := s.getInfoRepo().FindCustomerConfig(c.Request().Context(), atoi64(id))
 	if err != nil {
-		s.log.Errorf("apiCustomerConfigByID, error %v", err)
+		log.Errorf("apiCustomerConfigByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
 	}
 	if customer == nil {
-		s.log.Warnf("apiCustomerConfigByID for id=%s not found", id)
+		log.Warnf("apiCustomerConfigByID for id=%s not found", id)
 		return c.JSON(http.StatusNotFound, ErrNotFound(errCustomerConfigNotFound))
 	}
 	return c.JSON(http.StatusOK, customer)
 }
 
+// CustomerConfigPatch partial update of the customer config, absent fields are not changed
+type CustomerConfigPatch struct {
+	SdServiceID   *int64  `json:"sdServiceId"`
+	SdCreatorID   *int64  `json:"sdCreatorId"`
+	SdDestination *string `json:"sdDestination"`
+}
+
+// validateCustomerConfig checks that service and creator exist in the Intraservice,
+// returns http status code and error
+func (s *Server) validateCustomerConfig(ctx context.Context, cfg domain.CustomerConfig) (int, error) {
+	return s.validateCustomerConfigPatch(ctx, fullCustomerConfigPatch(cfg))
+}
+
+// validateCustomerConfigPatch checks the changed fields like validateCustomerConfig,
+// so the fields can be applied to the locked config without requests to the Intraservice
+func (s *Server) validateCustomerConfigPatch(ctx context.Context, patch CustomerConfigPatch) (int, error) {
+	log := domain.RequestLogger(ctx, s.log)
+	if patch.SdServiceID != nil && *patch.SdServiceID <= 0 {
+		return http.StatusBadRequest, errWrongSdServiceID
+	}
+	if patch.SdCreatorID != nil && *patch.SdCreatorID <= 0 {
+		return http.StatusBadRequest, errWrongSdCreatorID
+	}
+	if patch.SdServiceID != nil {
+		ok, err := s.sdRepo.ServiceExists(ctx, *patch.SdServiceID)
+		if err != nil {
+			log.Errorf("sdRepo.ServiceExists for id=%d, error %v", *patch.SdServiceID, err)
+			return http.StatusInternalServerError, errIntraserviceAPI
+		}
+		if !ok {
+			return http.StatusBadRequest, errSdServiceNotFound
+		}
+	}
+	if patch.SdCreatorID != nil {
+		ok, err := s.sdRepo.UserExists(ctx, *patch.SdCreatorID)
+		if err != nil {
+			log.Errorf("sdRepo.UserExists for id=%d, error %v", *patch.SdCreatorID, err)
+			return http.StatusInternalServerError, errIntraserviceAPI
+		}
+		if !ok {
+			return http.StatusBadRequest, errSdCreatorNotFound
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// fullCustomerConfigPatch returns patch of all service desk parameters of the config
+func fullCustomerConfigPatch(cfg domain.CustomerConfig) CustomerConfigPatch {
+	return CustomerConfigPatch{SdServiceID: &cfg.SdServiceID, SdCreatorID: &cfg.SdCreatorID,
+		SdDestination: &cfg.SdDestination}
+}
+
+// existingCustomerConfig returns customer config by id path parameter, http status code and error
+func (s *Server) existingCustomerConfig(c echo.Context) (*domain.CustomerConfig, int, error) {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := c.Param("id")
+	if atoi64(id) == 0 {
+		return nil, http.StatusBadRequest, errEmptyID
+	}
+	customer, err := s.getInfoRepo().FindCustomerConfig(c.Request().Context(), atoi64(id))
+	if err != nil {
+		log.Errorf("infoRepo.FindCustomerConfig for id=%s, error %v", id, err)
+		return nil, http.StatusInternalServerError, errDB
+	}
+	return customer, http.StatusOK, nil
+}
+
+// apiNewCustomerConfig godoc
+// @Summary Inserts new customer config
+// @Description inserts service desk parameters of the customer, sdServiceId and sdCreatorId must exist in Intraservice
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Accept json
+// @Produce json
+// @Param id path integer true "Code 1S"
+// @Param customerConfig body domain.CustomerConfig true "New customer configuration"
+// @Success 201 {object} infra.SuccessResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 405 {object} infra.HTTPError
+// @Failure 409 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/customers/{id}/configs [post]
+func (s *Server) apiNewCustomerConfig(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	cfg := domain.CustomerConfig{}
+	if err := c.Bind(&cfg); err != nil {
+		log.Errorf("apiNewCustomerConfig, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	existing, code, err := s.existingCustomerConfig(c)
+	if err != nil {
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	if existing != nil {
+		return c.JSON(http.StatusConflict, ErrConflict(errCustomerConfigExists))
+	}
+	cfg.CustomerID = atoi64(c.Param("id"))
+	if code, err = s.validateCustomerConfig(c.Request().Context(), cfg); err != nil {
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	err = s.getInfoRepo().StoreCustomerConfig(c.Request().Context(), cfg)
+	if errors.Is(err, domain.ErrAlreadyExists) {
+		// created after the check by the concurrent request
+		log.Warnf("apiNewCustomerConfig for id=%d, error %v", cfg.CustomerID, err)
+		return c.JSON(http.StatusConflict, ErrConflict(errCustomerConfigExists))
+	}
+	if err != nil {
+		log.Errorf("apiNewCustomerConfig, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	s.recordAudit(c, customerAuditResource(cfg.CustomerID), domain.AuditCreate, nil, cfg)
+	c.Response().Header().Set("Location", fmt.Sprintf("/v2/customers/%d/configs", cfg.CustomerID))
+	return c.JSON(http.StatusCreated, CreatedStatus(fmt.Sprintf("%d", cfg.CustomerID)))
+}
+
+// apiUpdCustomerConfig godoc
+// @Summary Update customer config
+// @Description replaces service desk parameters of the customer, sdServiceId and sdCreatorId must exist in Intraservice
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Accept json
+// @Produce json
+// @Param id path integer true "Code 1S"
+// @Param customerConfig body domain.CustomerConfig true "Customer configuration"
+// @Success 200 {object} domain.CustomerConfig
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/customers/{id}/configs [put]
+func (s *Server) apiUpdCustomerConfig(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	cfg := domain.CustomerConfig{}
+	if err := c.Bind(&cfg); err != nil {
+		log.Errorf("apiUpdCustomerConfig, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	return s.saveCustomerConfig(c, fullCustomerConfigPatch(cfg))
+}
+
+// apiPatchCustomerConfig godoc
+// @Summary Partial update of customer config
+// @Description updates only specified service desk parameters of the customer,
+// @Description sdServiceId and sdCreatorId must exist in Intraservice
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Accept json
+// @Produce json
+// @Param id path integer true "Code 1S"
+// @Param customerConfig body infra.CustomerConfigPatch true "Changed fields of customer configuration"
+// @Success 200 {object} domain.CustomerConfig
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/customers/{id}/configs [patch]
+func (s *Server) apiPatchCustomerConfig(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	patch := CustomerConfigPatch{}
+	if err := c.Bind(&patch); err != nil {
+		log.Errorf("apiPatchCustomerConfig, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	return s.saveCustomerConfig(c, patch)
+}
+
+// saveCustomerConfig validates the changed fields and applies them to the config locked in CM_INFO,
+// so concurrent changes of the other fields aren't lost, responds with updated config
+func (s *Server) saveCustomerConfig(c echo.Context, patch CustomerConfigPatch) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id == 0 {
+		log.Errorf("bad request saveCustomerConfig, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	code, err := s.validateCustomerConfigPatch(c.Request().Context(), patch)
+	if err != nil {
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	before, after, err := s.getInfoRepo().UpdateCustomerConfig(c.Request().Context(), id,
+		func(cfg domain.CustomerConfig) domain.CustomerConfig {
+			if patch.SdServiceID != nil {
+				cfg.SdServiceID = *patch.SdServiceID
+			}
+			if patch.SdCreatorID != nil {
+				cfg.SdCreatorID = *patch.SdCreatorID
+			}
+			if patch.SdDestination != nil {
+				cfg.SdDestination = *patch.SdDestination
+			}
+			return cfg
+		})
+	if err != nil {
+		log.Errorf("infoRepo.UpdateCustomerConfig for id=%d, error %v", id, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if before == nil {
+		log.Warnf("saveCustomerConfig for id=%d not found", id)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errCustomerConfigNotFound))
+	}
+	s.recordAudit(c, customerAuditResource(id), domain.AuditUpdate, before, after)
+	return c.JSON(http.StatusOK, after)
+}
+
+// apiDelCustomerConfig godoc
+// @Summary Delete customer config
+// @Description deletes service desk parameters of the customer
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Produce json
+// @Param id path integer true "Code 1S"
+// @Success 200 {object} infra.SuccessResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/customers/{id}/configs [delete]
+func (s *Server) apiDelCustomerConfig(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id == 0 {
+		log.Errorf("bad request apiDelCustomerConfig, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	before, err := s.getInfoRepo().DeleteCustomerConfig(c.Request().Context(), id)
+	if err != nil {
+		log.Errorf("apiDelCustomerConfig, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if before == nil {
+		log.Warnf("apiDelCustomerConfig for id=%d not found", id)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errCustomerConfigNotFound))
+	}
+	s.recordAudit(c, customerAuditResource(id), domain.AuditDelete, before, nil)
+	return c.JSON(http.StatusOK, OkStatus("delete 1 records"))
+}
+
 // ProjectsResponse http wrapper with metadata
 type ProjectsResponse struct {
 	Data domain.Projects `json:"data"`
@@ -113,12 +388,18 @@ type ProjectsResponse struct {
 // apiProjects godoc
 // @Summary Get all projects configurations
 // @Description get slice of customer/project configuration with DataBase connection and offset, limit, active parameters
+// @Description next_cursor is returned for requests with filter, q, sort, after or count=false
 // @Produce  json
 // @Security ApiKeyAuth
 // @Tags cm_info
 // @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
 // @Param active query string false "default=true"
+// @Param filter[field] query string false "filter[field][op]=value, op: eq (default),ne,gt,gte,lt,lte,like; fields: id,name,typeId,typeName,parentId,managerId,managerName,isEnabled,db_type"
+// @Param q query string false "free-text search by name, managerName"
+// @Param sort query string false "comma separated fields, minus for descending order, example: -name,id"
+// @Param after query string false "opaque cursor next_cursor of the previous page, enables keyset paging instead of offset"
+// @Param count query bool false "default=true, false skips total count of rows (total=-1)"
 // @Success 200 {object} infra.ProjectsResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
@@ -127,12 +408,22 @@ type ProjectsResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/projects [get]
 func (s *Server) apiProjects(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
+	query, err := s.getListQuery(c, domain.ProjectListFields)
+	if err != nil {
+		log.Warnf("bad request apiProjects, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if len(query.After) > 0 {
+		// keyset paging, page starts after the cursor
+		offset = 0
+	}
 	enabled, err := strconv.ParseBool(c.QueryParam("active"))
 	if err != nil {
 		enabled = true
 	}
-	projects, count, err := s.infoRepo.FindProjects(offset, limit, enabled)
+	projects, count, err := s.getInfoRepo().FindProjects(c.Request().Context(), query, offset, limit, enabled)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
@@ -140,10 +431,11 @@ func (s *Server) apiProjects(c echo.Context) error {
 		Data: projects,
 		Metadata: Metadata{
 			ResultSet: ResultSet{
-				Count:  int64(len(projects)),
-				Offset: offset,
-				Limit:  limit,
-				Total:  count,
+				Count:      int64(len(projects)),
+				Offset:     offset,
+				Limit:      limit,
+				Total:      count,
+				NextCursor: infoNextCursor(query, domain.ProjectListFields, projects, limit),
 			},
 		},
 	}
@@ -167,24 +459,25 @@ func (s *Server) apiProjects(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/projects/{id} [get]
 func (s *Server) apiProjectByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	rawID := c.Param("id")
 	if rawID == "" {
-		s.log.Errorf("bad request apiProjectByID, %s", errEmptyID)
+		log.Errorf("bad request apiProjectByID, %s", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
 	id, dbType := colonSeparate(rawID)
 	ptr := &dbType
 	if dbType != "" && !reDBType.MatchString(dbType) {
-		s.log.Warnf("dbType=%s wrong format, allow only 1,2,3,4,10", dbType)
+		log.Warnf("dbType=%s wrong format, allow only 1,2,3,4,10", dbType)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errWrongDBType))
 	}
 	if dbType == "" {
 		ptr = nil
 	}
-	s.log.Debugf("id=%s, dbType=%s", id, dbType)
-	project, err := s.infoRepo.FindProjectByID(atoi64(id), ptr)
+	log.Debugf("id=%s, dbType=%s", id, dbType)
+	project, err := s.getInfoRepo().FindProjectByID(c.Request().Context(), atoi64(id), ptr)
 	if err != nil {
-		s.log.Errorf("apiProjectByID, error %v", err)
+		log.Errorf("apiProjectByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if project == nil {
@@ -221,18 +514,19 @@ func colonSeparate(raw string) (string, string) {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/projects/{id}/ftpinfo [get]
 func (s *Server) apiProjectFTPByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiProjectFTPByID, %v", errEmptyID)
+		log.Errorf("bad request apiProjectFTPByID, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
-	ftp, err := s.infoRepo.FindFTPByID(atoi64(id))
+	ftp, err := s.getInfoRepo().FindFTPByID(c.Request().Context(), atoi64(id))
 	if err != nil {
-		s.log.Errorf("apiProjectFTPByID, error %v", err)
+		log.Errorf("apiProjectFTPByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if ftp == nil {
-		s.log.Warnf("apiProjectFTPByID for id=%s not found", id)
+		log.Warnf("apiProjectFTPByID for id=%s not found", id)
 		return c.JSON(http.StatusNotFound, ErrNotFound(errProjectFTPNotFound))
 	}
 	return c.JSON(http.StatusOK, ftp)
@@ -263,18 +557,19 @@ type ProjectMCResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/projects/{id}/controllers/{cid}/manualcnts [get]
 func (s *Server) apiProjectMC(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiProjectMC, %v", errEmptyID)
+		log.Errorf("bad request apiProjectMC, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
 	cid := c.Param("cid")
 	if id == "" {
-		s.log.Errorf("bad request apiProjectMC, %v", errEmptyID)
+		log.Errorf("bad request apiProjectMC, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyCID))
 	}
-	mcs, count, err := s.infoRepo.FindManualCountings(atoi64(id), atoi64(cid), offset, limit)
+	mcs, count, err := s.getInfoRepo().FindManualCountings(c.Request().Context(), atoi64(id), atoi64(cid), offset, limit)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
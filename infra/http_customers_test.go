@@ -0,0 +1,226 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeCustomerRepo in memory implementation of the customer configs part of domain.CustomerRepo
+type fakeCustomerRepo struct {
+	domain.CustomerRepo
+	configs map[int64]domain.CustomerConfig
+	// locks shared by the servers of the same repository like the locks in CM_INFO
+	locks keyLocks
+}
+
+func (f *fakeCustomerRepo) FindCustomerConfig(_ context.Context, id int64) (*domain.CustomerConfig, error) {
+	cfg, ok := f.configs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+func (f *fakeCustomerRepo) StoreCustomerConfig(_ context.Context, cfg domain.CustomerConfig) error {
+	if _, ok := f.configs[cfg.CustomerID]; ok {
+		return domain.ErrAlreadyExists
+	}
+	f.configs[cfg.CustomerID] = cfg
+	return nil
+}
+
+func (f *fakeCustomerRepo) UpdateCustomerConfig(_ context.Context, id int64,
+	change domain.CustomerConfigChange) (*domain.CustomerConfig, *domain.CustomerConfig, error) {
+	before, err := f.FindCustomerConfig(context.Background(), id)
+	if before == nil || err != nil {
+		return nil, nil, err
+	}
+	after := change(*before)
+	f.configs[id] = after
+	return before, &after, nil
+}
+
+func (f *fakeCustomerRepo) DeleteCustomerConfig(_ context.Context, id int64) (*domain.CustomerConfig, error) {
+	before, err := f.FindCustomerConfig(context.Background(), id)
+	delete(f.configs, id)
+	return before, err
+}
+
+func (f *fakeCustomerRepo) WithLock(ctx context.Context, key string, fn func(context.Context) error) error {
+	unlock := f.locks.lock(key)
+	defer unlock()
+	return fn(ctx)
+}
+
+// fakeSDRepo Intraservice with known services and users
+type fakeSDRepo struct {
+	domain.SDRepo
+	services map[int64]bool
+	users    map[int64]bool
+}
+
+func (f *fakeSDRepo) ServiceExists(_ context.Context, id int64) (bool, error) {
+	return f.services[id], nil
+}
+
+func (f *fakeSDRepo) UserExists(_ context.Context, id int64) (bool, error) { return f.users[id], nil }
+
+func TestAPICustomerConfigChange(t *testing.T) {
+	withToken := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(tokenPayloadKey, &TokenPayload{Username: "operator", Subject: "uuid"})
+			return next(c)
+		}
+	}
+	tCases := []struct {
+		title    string
+		method   string
+		url      string
+		body     string
+		code     int
+		expected *domain.CustomerConfig
+	}{
+		{title: "create", method: http.MethodPost, url: "/v2/customers/2/configs",
+			body: `{"sdServiceId":10,"sdCreatorId":20,"sdDestination":"support"}`, code: http.StatusCreated,
+			expected: &domain.CustomerConfig{CustomerID: 2, SdServiceID: 10, SdCreatorID: 20, SdDestination: "support"}},
+		{title: "create existing", method: http.MethodPost, url: "/v2/customers/1/configs",
+			body: `{"sdServiceId":10,"sdCreatorId":20}`, code: http.StatusConflict},
+		{title: "create unknown service", method: http.MethodPost, url: "/v2/customers/2/configs",
+			body: `{"sdServiceId":99,"sdCreatorId":20}`, code: http.StatusBadRequest},
+		{title: "update", method: http.MethodPut, url: "/v2/customers/1/configs",
+			body: `{"sdServiceId":11,"sdCreatorId":21,"sdDestination":""}`, code: http.StatusOK,
+			expected: &domain.CustomerConfig{CustomerID: 1, CustomerName: "Mall", SdServiceID: 11, SdCreatorID: 21}},
+		{title: "update not found", method: http.MethodPut, url: "/v2/customers/2/configs",
+			body: `{"sdServiceId":11,"sdCreatorId":21}`, code: http.StatusNotFound},
+		{title: "patch", method: http.MethodPatch, url: "/v2/customers/1/configs",
+			body: `{"sdCreatorId":21}`, code: http.StatusOK,
+			expected: &domain.CustomerConfig{CustomerID: 1, CustomerName: "Mall", SdServiceID: 10, SdCreatorID: 21,
+				SdDestination: "support"}},
+		{title: "patch unknown creator", method: http.MethodPatch, url: "/v2/customers/1/configs",
+			body: `{"sdCreatorId":99}`, code: http.StatusBadRequest},
+		{title: "patch zero service", method: http.MethodPatch, url: "/v2/customers/1/configs",
+			body: `{"sdServiceId":0}`, code: http.StatusBadRequest},
+		{title: "delete", method: http.MethodDelete, url: "/v2/customers/1/configs", code: http.StatusOK},
+		{title: "delete not found", method: http.MethodDelete, url: "/v2/customers/2/configs",
+			code: http.StatusNotFound},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := &fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{
+				1: {CustomerID: 1, CustomerName: "Mall", SdServiceID: 10, SdCreatorID: 20, SdDestination: "support"},
+			}}
+			audit, err := repos.NewFileAudit(filepath.Join(t.TempDir(), "audit.jsonl"))
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			s := &Server{
+				log:      zap.NewNop().Sugar(),
+				infoRepo: repo,
+				audit:    audit,
+				sdRepo: &fakeSDRepo{services: map[int64]bool{10: true, 11: true},
+					users: map[int64]bool{20: true, 21: true}},
+			}
+			e := echo.New()
+			e.POST("/v2/customers/:id/configs", s.apiNewCustomerConfig, withToken)
+			e.PUT("/v2/customers/:id/configs", s.apiUpdCustomerConfig, withToken)
+			e.PATCH("/v2/customers/:id/configs", s.apiPatchCustomerConfig, withToken)
+			e.DELETE("/v2/customers/:id/configs", s.apiDelCustomerConfig, withToken)
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			records, _ := audit.Find(context.Background(), domain.AuditFilter{}, 10)
+			if rec.Code >= http.StatusBadRequest {
+				if len(records) != 0 {
+					t.Errorf("Expected no changes, but got audit records %+v", records)
+				}
+				return
+			}
+			actions := map[string]string{http.MethodPost: domain.AuditCreate, http.MethodPut: domain.AuditUpdate,
+				http.MethodPatch: domain.AuditUpdate, http.MethodDelete: domain.AuditDelete}
+			if len(records) != 1 || records[0].Actor != "operator" || records[0].Action != actions[tc.method] ||
+				records[0].Resource != "customer/"+strings.Split(tc.url, "/")[3] {
+				t.Errorf("Expected one change %s by operator, but got audit records %+v", actions[tc.method], records)
+			}
+			if tc.expected == nil {
+				return
+			}
+			if got := repo.configs[tc.expected.CustomerID]; got != *tc.expected {
+				t.Errorf("Expected stored config %+v, but got %+v", *tc.expected, got)
+			}
+			if tc.method == http.MethodPost {
+				return
+			}
+			got := domain.CustomerConfig{}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("Expected success decode response, but error %v", err)
+			}
+			if got != *tc.expected {
+				t.Errorf("Expected response %+v, but got %+v", *tc.expected, got)
+			}
+		})
+	}
+}
+
+// staleCustomerRepo reads configs of the stale copy, as the cache of the other replica
+type staleCustomerRepo struct {
+	*fakeCustomerRepo
+	stale map[int64]domain.CustomerConfig
+}
+
+func (f *staleCustomerRepo) FindCustomerConfig(_ context.Context, id int64) (*domain.CustomerConfig, error) {
+	cfg, ok := f.stale[id]
+	if !ok {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+func TestAPICustomerConfigStale(t *testing.T) {
+	repo := &fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{
+		1: {CustomerID: 1, SdServiceID: 10, SdCreatorID: 20, SdDestination: "support"},
+		2: {CustomerID: 2, SdServiceID: 10, SdCreatorID: 20},
+	}}
+	stale := map[int64]domain.CustomerConfig{1: {CustomerID: 1}}
+	s := &Server{
+		log:      zap.NewNop().Sugar(),
+		infoRepo: &staleCustomerRepo{fakeCustomerRepo: repo, stale: stale},
+		sdRepo:   &fakeSDRepo{services: map[int64]bool{10: true}, users: map[int64]bool{20: true, 21: true}},
+	}
+	e := echo.New()
+	e.POST("/v2/customers/:id/configs", s.apiNewCustomerConfig)
+	e.PATCH("/v2/customers/:id/configs", s.apiPatchCustomerConfig)
+	do := func(method, url, body string) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// the patch is applied to the current config, not to the stale copy
+	if code := do(http.MethodPatch, "/v2/customers/1/configs", `{"sdCreatorId":21}`); code != http.StatusOK {
+		t.Fatalf("Expected code=200 of PATCH, but got %d", code)
+	}
+	expected := domain.CustomerConfig{CustomerID: 1, SdServiceID: 10, SdCreatorID: 21, SdDestination: "support"}
+	if repo.configs[1] != expected {
+		t.Errorf("Expected stored config %+v, but got %+v", expected, repo.configs[1])
+	}
+	// config created after the check
+	if code := do(http.MethodPost, "/v2/customers/2/configs", `{"sdServiceId":10,"sdCreatorId":20}`); code !=
+		http.StatusConflict {
+		t.Errorf("Expected code=409 of POST of existing config, but got %d", code)
+	}
+}
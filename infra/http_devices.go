@@ -0,0 +1,161 @@
+package infra
+
+import (
+	"errors"
+	"net/http"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errDeviceNotFound    error = errors.New("devices not found")
+	errEmptyProjectParam error = errors.New("empty project query parameter not allowed")
+	errLayoutRequired    error = errors.New("layout query parameter required for the token with partial layouts access")
+)
+
+// DevicesResponse http wrapper with metadata
+type DevicesResponse struct {
+	Data domain.Devices `json:"data"`
+	Metadata
+}
+
+// deviceProject returns project from the query parameter project=<id>[:<dbType>]
+// with connection parameters to the project database
+func (s *Server) deviceProject(c echo.Context) (*domain.Project, int, error) {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	rawID := c.QueryParam("project")
+	if rawID == "" {
+		return nil, http.StatusBadRequest, errEmptyProjectParam
+	}
+	id, dbType := colonSeparate(rawID)
+	ptr := &dbType
+	if dbType != "" && !reDBType.MatchString(dbType) {
+		return nil, http.StatusBadRequest, errWrongDBType
+	}
+	if dbType == "" {
+		ptr = nil
+	}
+	project, err := s.getInfoRepo().FindProjectByID(c.Request().Context(), atoi64(id), ptr)
+	if err != nil {
+		log.Errorf("deviceProject infoRepo.FindProjectByID, error %v", err)
+		return nil, http.StatusInternalServerError, errDB
+	}
+	if project == nil {
+		return nil, http.StatusNotFound, errProjectNotFound
+	}
+	return project, http.StatusOK, nil
+}
+
+// apiDevices godoc
+// @Summary Get devices of the project
+// @Description get slice of devices from the project database with layout, offset, limit parameters,
+// @Description project can be colon separated format <customerID>:<DBTypeID>
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags layouts
+// @Param project query string true "Code 1S[:DBTypeID]"
+// @Param layout query string false "LayoutID, required for the token with partial layouts access"
+// @Param offset query integer false "default=0"
+// @Param limit query integer false "default=10"
+// @Success 200 {object} infra.DevicesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 403 {object} infra.ErrResponse
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/devices [get]
+func (s *Server) apiDevices(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	offset, limit := s.getPageParams(c)
+	claim, ok := layoutClaim(c)
+	if !ok {
+		log.Warnf("apiDevices, %v", errEmptyLayoutACL)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errEmptyLayoutACL))
+	}
+	layoutID := c.QueryParam("layout")
+	if layoutID == "" && claim != layoutsAll {
+		return c.JSON(http.StatusForbidden, ErrForbidden(errLayoutRequired))
+	}
+	if layoutID != "" {
+		if _, cnt := (domain.Layouts{{LayoutID: layoutID}}).ACL(claim); cnt == 0 {
+			log.Warnf("apiDevices for layout=%s, %v", layoutID, errLayoutForbidden)
+			return c.JSON(http.StatusForbidden, ErrForbidden(errLayoutForbidden))
+		}
+	}
+	project, code, err := s.deviceProject(c)
+	if err != nil {
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	devices, count, err := s.deviceRepo.FindDevices(c.Request().Context(), *project, layoutID, offset, limit)
+	if err != nil {
+		log.Errorf("deviceRepo.FindDevices for project=%d, error %v", project.ID, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if len(devices) == 0 {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errDeviceNotFound))
+	}
+	response := DevicesResponse{
+		Data: devices,
+		Metadata: Metadata{
+			ResultSet: ResultSet{
+				Count:  int64(len(devices)),
+				Offset: offset,
+				Limit:  limit,
+				Total:  count,
+			},
+		},
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// apiDeviceBySN godoc
+// @Summary Get device by serial number
+// @Description get device of the project by serial number, the same SN as in /v2/tasks/controllers/{sn}
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags layouts
+// @Param sn path string true "SerialNumber of controller"
+// @Param project query string true "Code 1S[:DBTypeID]"
+// @Success 200 {object} domain.Device
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 403 {object} infra.ErrResponse
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/devices/{sn} [get]
+func (s *Server) apiDeviceBySN(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	sn := c.Param("sn")
+	if sn == "" {
+		log.Errorf("bad request apiDeviceBySN, %v", errEmptySN)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptySN))
+	}
+	claim, ok := layoutClaim(c)
+	if !ok {
+		log.Warnf("apiDeviceBySN, %v", errEmptyLayoutACL)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errEmptyLayoutACL))
+	}
+	project, code, err := s.deviceProject(c)
+	if err != nil {
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	device, err := s.deviceRepo.FindDeviceBySN(c.Request().Context(), *project, sn)
+	if err != nil {
+		log.Errorf("deviceRepo.FindDeviceBySN for project=%d, error %v", project.ID, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if device == nil {
+		log.Warnf("apiDeviceBySN for sn=%s not found", sn)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errDeviceNotFound))
+	}
+	if _, cnt := (domain.Layouts{{LayoutID: device.LayoutID}}).ACL(claim); cnt == 0 {
+		log.Warnf("apiDeviceBySN for sn=%s, %v", sn, errLayoutForbidden)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errLayoutForbidden))
+	}
+	return c.JSON(http.StatusOK, device)
+}
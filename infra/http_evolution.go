@@ -25,6 +25,11 @@ type ReferenceResponse struct {
 // @Tags reference
 // @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
+// @Param filter[field] query string false "filter[field][op]=value, op: eq (default),ne,gt,gte,lt,lte,like; fields: id,description"
+// @Param q query string false "free-text search by id, description"
+// @Param sort query string false "comma separated fields, minus for descending order, example: -name,id"
+// @Param after query string false "opaque cursor next_cursor of the previous page, enables keyset paging instead of offset"
+// @Param count query bool false "default=true, false skips total count of rows (total=-1)"
 // @Success 200 {object} infra.ReferenceResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 404 {object} infra.ErrResponse
@@ -32,8 +37,18 @@ type ReferenceResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/entities [get]
 func (s *Server) apiEntities(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
-	entities, count, err := s.refRepo.FindEntities(offset, limit)
+	query, err := s.getListQuery(c, domain.EntityListFields)
+	if err != nil {
+		log.Warnf("bad request apiEntities, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if len(query.After) > 0 {
+		// keyset paging, page starts after the cursor
+		offset = 0
+	}
+	entities, count, err := s.getRefRepo().FindEntities(c.Request().Context(), query, offset, limit)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
@@ -44,10 +59,11 @@ func (s *Server) apiEntities(c echo.Context) error {
 		Data: entities,
 		Metadata: Metadata{
 			ResultSet: ResultSet{
-				Count:  int64(len(entities)),
-				Offset: offset,
-				Limit:  limit,
-				Total:  count,
+				Count:      int64(len(entities)),
+				Offset:     offset,
+				Limit:      limit,
+				Total:      count,
+				NextCursor: nextCursor(query, domain.EntityListFields, entities, limit),
 			},
 		},
 	}
@@ -67,18 +83,19 @@ func (s *Server) apiEntities(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/entities/{id} [get]
 func (s *Server) apiEntityByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiEntityByID, %v", errEmptyEntityID)
+		log.Errorf("bad request apiEntityByID, %v", errEmptyEntityID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyEntityID))
 	}
-	e, err := s.refRepo.FindEntityByID(id)
+	e, err := s.getRefRepo().FindEntityByID(c.Request().Context(), id)
 	if err != nil {
-		s.log.Errorf("apiEntityByID, error %v", err)
+		log.Errorf("apiEntityByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if e == nil {
-		s.log.Warnf("apiEntityByID for id=%s not found", id)
+		log.Warnf("apiEntityByID for id=%s not found", id)
 		return c.JSON(http.StatusNotFound, ErrNotFound(errVCConfigNotFound))
 	}
 	return c.JSON(http.StatusOK, *e)
@@ -0,0 +1,158 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// states of the dependencies and the service in the health report
+const (
+	healthOK       string = "ok"
+	healthDegraded string = "degraded"
+	healthCritical string = "critical"
+)
+
+var errNotChecked error = errors.New("health check hasn't completed yet")
+
+// DependencyStatus state of the dependency in the health report
+type DependencyStatus struct {
+	// Ready repository is connected
+	Ready bool `json:"ready"`
+	// Status ok, degraded (non-critical dependency fails), critical
+	Status      string     `json:"status"`
+	Critical    bool       `json:"critical"`
+	Error       string     `json:"error,omitempty"`
+	LatencyMS   int64      `json:"latency_ms"`
+	LastCheck   time.Time  `json:"last_check"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// HealthResponse health report of the service with state of each dependency
+type HealthResponse struct {
+	// Status ok, degraded, critical - the worst state of the dependencies
+	Status       string                      `json:"status"`
+	Ready        bool                        `json:"ready"`
+	Checked      time.Time                   `json:"checked"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// checkDependencies checks each dependency, updates metrics and returns health report,
+// previous report is used for last success and last error of the dependencies
+func (s *Server) checkDependencies(prev HealthResponse) HealthResponse {
+	report := HealthResponse{Status: healthOK, Ready: true, Checked: time.Now(),
+		Dependencies: make(map[string]DependencyStatus, len(s.deps))}
+	for _, dep := range s.deps {
+		state := prev.Dependencies[dep.name]
+		state.Critical = dep.critical
+		state.Ready = s.isReadyRepo(dep.name)
+		checked := time.Now()
+		state.LastCheck = checked
+		err := errDependencyUnavailable
+		if state.Ready {
+			err = dep.health(context.Background())
+		}
+		state.LatencyMS = time.Since(checked).Milliseconds()
+		if err == nil {
+			state.Status, state.Error = healthOK, ""
+			state.LastSuccess = &checked
+			report.Dependencies[dep.name] = state
+			s.mService.WithLabelValues(dep.label, dep.dest, s.version, s.githash, s.build).Set(1)
+			continue
+		}
+		s.log.With(zap.String("repository", dep.dest)).Errorf("healthCheck error, %v", err)
+		s.mService.WithLabelValues(dep.label, dep.dest, s.version, s.githash, s.build).Set(0)
+		state.Error, state.LastError, state.LastErrorAt = err.Error(), err.Error(), &checked
+		state.Status = healthDegraded
+		if dep.critical {
+			state.Status = healthCritical
+			report.Status, report.Ready = healthCritical, false
+		} else if report.Status == healthOK {
+			report.Status = healthDegraded
+		}
+		report.Dependencies[dep.name] = state
+	}
+	return report
+}
+
+// getHealth returns last report of the background healthChecker
+func (s *Server) getHealth() HealthResponse {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+	if s.health.Dependencies == nil {
+		return HealthResponse{Status: healthCritical, Dependencies: map[string]DependencyStatus{}}
+	}
+	return s.health
+}
+
+// setHealth saves report of the healthChecker
+func (s *Server) setHealth(report HealthResponse) {
+	s.healthMu.Lock()
+	s.health = report
+	s.healthMu.Unlock()
+}
+
+// apiHealthCheck returk 200 ok if all dependencies are ok by the last background check
+// @Summary healthcheck service eq repository connected
+// @Description state of each dependency by the last background check
+// @Tags health
+// @Success 200 {object} infra.HealthResponse
+// @Failure 500 {object} infra.HealthResponse
+// @Router /health [get]
+func (s *Server) apiHealthCheck(c echo.Context) error {
+	report := s.getHealth()
+	if report.Status != healthOK {
+		return c.JSON(http.StatusInternalServerError, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// apiHealthLive godoc
+// @Summary liveness of the process
+// @Tags health
+// @Success 200 {object} infra.SuccessResponse
+// @Router /health/live [get]
+func (s *Server) apiHealthLive(c echo.Context) error {
+	return c.JSON(http.StatusOK, OkStatus("OK"))
+}
+
+// apiHealthReady godoc
+// @Summary readiness of the service
+// @Description ready if all critical dependencies are ok by the last background check,
+// @Description service stays ready when only non-critical dependencies fail (degraded)
+// @Tags health
+// @Success 200 {object} infra.SuccessResponse
+// @Failure 503 {object} infra.ErrResponse
+// @Router /health/ready [get]
+func (s *Server) apiHealthReady(c echo.Context) error {
+	report := s.getHealth()
+	if report.Checked.IsZero() {
+		return c.JSON(http.StatusServiceUnavailable, ErrServiceUnavailable(errNotChecked))
+	}
+	if !report.Ready {
+		return c.JSON(http.StatusServiceUnavailable, ErrServiceUnavailable(fmt.Errorf("status %s", report.Status)))
+	}
+	return c.JSON(http.StatusOK, OkStatus(report.Status))
+}
+
+// apiHealthDetails godoc
+// @Summary detailed health report of the dependencies
+// @Description latency, last success, last error and classification of each dependency by the last background check
+// @Tags health
+// @Success 200 {object} infra.HealthResponse
+// @Failure 503 {object} infra.HealthResponse
+// @Router /health/details [get]
+func (s *Server) apiHealthDetails(c echo.Context) error {
+	report := s.getHealth()
+	if !report.Ready {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,60 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var refErr, infoErr error
+	s := &Server{
+		log:       zap.NewNop().Sugar(),
+		mService:  common,
+		repoReady: map[string]bool{repoINFO: true, repoRef: true},
+		deps: []dependency{
+			{name: repoINFO, label: "test_info", critical: true, health: func(context.Context) error { return infoErr }},
+			{name: repoRef, label: "test_ref", health: func(context.Context) error { return refErr }},
+		},
+	}
+	tCases := []struct {
+		title    string
+		refErr   error
+		infoErr  error
+		status   string
+		expected int
+	}{
+		{title: "all ok", status: healthOK, expected: http.StatusOK},
+		{title: "non-critical fails", refErr: errors.New("ref down"), status: healthDegraded,
+			expected: http.StatusOK},
+		{title: "critical fails", infoErr: errors.New("info down"), status: healthCritical,
+			expected: http.StatusServiceUnavailable},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			refErr, infoErr = tc.refErr, tc.infoErr
+			_ = s.healthCheck()
+			report := s.getHealth()
+			if report.Status != tc.status {
+				t.Errorf("Expected status %s, but got %s", tc.status, report.Status)
+			}
+			if report.Dependencies[repoINFO].LastSuccess == nil {
+				t.Errorf("Expected last success of %s, but it's empty", repoINFO)
+			}
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/health/ready", nil), rec)
+			if err := s.apiHealthReady(c); err != nil {
+				t.Fatalf("Expected success apiHealthReady, but error %v", err)
+			}
+			if rec.Code != tc.expected {
+				t.Errorf("Expected ready status %d, but got %d", tc.expected, rec.Code)
+			}
+		})
+	}
+}
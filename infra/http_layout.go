@@ -0,0 +1,129 @@
+package infra
+
+import (
+	"errors"
+	"net/http"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errLayoutNotFound  error = errors.New("layouts not found")
+	errLayoutForbidden error = errors.New("layout is not accessible for the token")
+	errEmptyLayoutACL  error = errors.New("token does not contain layouts claim")
+)
+
+// LayoutsResponse http wrapper with metadata
+type LayoutsResponse struct {
+	Data domain.Layouts `json:"data"`
+	Metadata
+}
+
+// layoutClaim returns list of layout ids from the token claims,
+// false if the token does not grant access to any layout
+func layoutClaim(c echo.Context) (string, bool) {
+	payload := tokenFromContext(c)
+	if payload == nil || payload.Layouts == "" {
+		return "", false
+	}
+	return string(payload.Layouts), true
+}
+
+// apiLayouts godoc
+// @Summary Get all accessible layouts
+// @Description get slice of layouts accessible for the token (claim layouts) with offset, limit parameters
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags layouts
+// @Param offset query integer false "default=0"
+// @Param limit query integer false "default=10"
+// @Success 200 {object} infra.LayoutsResponse
+// @Failure 400 {object} infra.HTTPError
+// @Failure 401 {object} infra.HTTPError
+// @Failure 403 {object} infra.ErrResponse
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/layouts [get]
+func (s *Server) apiLayouts(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	offset, limit := s.getPageParams(c)
+	claim, ok := layoutClaim(c)
+	if !ok {
+		log.Warnf("apiLayouts, %v", errEmptyLayoutACL)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errEmptyLayoutACL))
+	}
+	all, err := s.getLayoutRepo().FindLayoutIDs(c.Request().Context())
+	if err != nil {
+		log.Errorf("layoutRepo.FindLayoutIDs, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	allowed, cnt := all.ACL(claim)
+	if cnt == 0 {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errLayoutNotFound))
+	}
+	layouts, count, err := s.getLayoutRepo().FindLayouts(c.Request().Context(), allowed, offset, limit)
+	if err != nil {
+		log.Errorf("layoutRepo.FindLayouts, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if len(layouts) == 0 {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errLayoutNotFound))
+	}
+	response := LayoutsResponse{
+		Data: layouts,
+		Metadata: Metadata{
+			ResultSet: ResultSet{
+				Count:  int64(len(layouts)),
+				Offset: offset,
+				Limit:  limit,
+				Total:  count,
+			},
+		},
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// apiLayoutByID godoc
+// @Summary Get layout by id
+// @Description get layout by id if it is accessible for the token (claim layouts)
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags layouts
+// @Param id path string true "LayoutID"
+// @Success 200 {object} domain.Layout
+// @Failure 400 {object} infra.HTTPError
+// @Failure 401 {object} infra.HTTPError
+// @Failure 403 {object} infra.ErrResponse
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/layouts/{id} [get]
+func (s *Server) apiLayoutByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := c.Param("id")
+	if id == "" {
+		log.Errorf("bad request apiLayoutByID, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	claim, ok := layoutClaim(c)
+	if !ok {
+		log.Warnf("apiLayoutByID, %v", errEmptyLayoutACL)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errEmptyLayoutACL))
+	}
+	layout, err := s.getLayoutRepo().FindLayoutByID(c.Request().Context(), id)
+	if err != nil {
+		log.Errorf("apiLayoutByID, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if layout == nil {
+		log.Warnf("apiLayoutByID for id=%s not found", id)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errLayoutNotFound))
+	}
+	if _, cnt := (domain.Layouts{*layout}).ACL(claim); cnt == 0 {
+		log.Warnf("apiLayoutByID for id=%s, %v", id, errLayoutForbidden)
+		return c.JSON(http.StatusForbidden, ErrForbidden(errLayoutForbidden))
+	}
+	return c.JSON(http.StatusOK, layout)
+}
@@ -0,0 +1,146 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeLayoutRepo in memory implementation of domain.LayoutRepo
+type fakeLayoutRepo struct {
+	layouts domain.Layouts
+}
+
+func (f *fakeLayoutRepo) FindLayoutIDs(_ context.Context) (domain.Layouts, error) {
+	result := make(domain.Layouts, 0, len(f.layouts))
+	for _, l := range f.layouts {
+		result = append(result, domain.Layout{LayoutID: l.LayoutID})
+	}
+	return result, nil
+}
+
+func (f *fakeLayoutRepo) FindLayouts(_ context.Context, listIDs string, offset,
+	limit int64) (domain.Layouts, int64, error) {
+	ids := strings.Split(listIDs, ",")
+	found := make(domain.Layouts, 0)
+	for _, l := range f.layouts {
+		if containsString(ids, l.LayoutID) {
+			found = append(found, l)
+		}
+	}
+	total := int64(len(found))
+	if offset >= total {
+		return domain.Layouts{}, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return found[offset:end], total, nil
+}
+
+func (f *fakeLayoutRepo) FindLayoutByID(_ context.Context, id string) (*domain.Layout, error) {
+	for _, l := range f.layouts {
+		if l.LayoutID == id {
+			item := l
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeLayoutRepo) Health(_ context.Context) error { return nil }
+
+func TestAPILayoutsACL(t *testing.T) {
+	s := &Server{
+		log: zap.NewNop().Sugar(),
+		layoutRepo: &fakeLayoutRepo{layouts: domain.Layouts{
+			{LayoutID: "mall-1", Title: "Mall 1"},
+			{LayoutID: "mall-2", Title: "Mall 2"},
+			{LayoutID: "store-3", Title: "Store 3"},
+		}},
+	}
+	withToken := func(claim ACLClaim) echo.MiddlewareFunc {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				c.Set(tokenPayloadKey, &TokenPayload{Layouts: claim})
+				return next(c)
+			}
+		}
+	}
+	tCases := []struct {
+		title string
+		claim ACLClaim
+		url   string
+		code  int
+		ids   []string
+	}{
+		{title: "all layouts", claim: "*", url: "/v2/layouts", code: http.StatusOK,
+			ids: []string{"mall-1", "mall-2", "store-3"}},
+		{title: "part of layouts", claim: "mall-2,store-3,unknown", url: "/v2/layouts", code: http.StatusOK,
+			ids: []string{"mall-2", "store-3"}},
+		{title: "paging", claim: "*", url: "/v2/layouts?offset=1&limit=1", code: http.StatusOK,
+			ids: []string{"mall-2"}},
+		{title: "no claim", claim: "", url: "/v2/layouts", code: http.StatusForbidden},
+		{title: "no accessible", claim: "unknown", url: "/v2/layouts", code: http.StatusNotFound},
+		{title: "by id allowed", claim: "mall-1", url: "/v2/layouts/mall-1", code: http.StatusOK},
+		{title: "by id forbidden", claim: "mall-1", url: "/v2/layouts/mall-2", code: http.StatusForbidden},
+		{title: "by id not found", claim: "*", url: "/v2/layouts/none", code: http.StatusNotFound},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/v2/layouts", s.apiLayouts, withToken(tc.claim))
+			e.GET("/v2/layouts/:id", s.apiLayoutByID, withToken(tc.claim))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.ids == nil {
+				return
+			}
+			response := LayoutsResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("Expected success decode response, but error %v", err)
+			}
+			got := make([]string, 0, len(response.Data))
+			for _, l := range response.Data {
+				got = append(got, l.LayoutID)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.ids, ",") {
+				t.Errorf("Expected layouts %v, but got %v", tc.ids, got)
+			}
+		})
+	}
+}
+
+func TestACLClaimUnmarshal(t *testing.T) {
+	tCases := []struct {
+		title    string
+		raw      string
+		expected ACLClaim
+	}{
+		{title: "string", raw: `{"layouts":"a,b"}`, expected: "a,b"},
+		{title: "array", raw: `{"layouts":["a","b"]}`, expected: "a,b"},
+		{title: "absent", raw: `{}`, expected: ""},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			payload := TokenPayload{}
+			if err := json.Unmarshal([]byte(tc.raw), &payload); err != nil {
+				t.Fatalf("Expected success unmarshal, but error %v", err)
+			}
+			if payload.Layouts != tc.expected {
+				t.Errorf("Expected layouts=%s, but got %s", tc.expected, payload.Layouts)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// reFilterParam query parameter filter[field] or filter[field][op]
+var reFilterParam *regexp.Regexp = regexp.MustCompile(`^filter\[([A-Za-z_]+)\](?:\[([a-z]+)\])?$`)
+
+// listCursor content of the opaque cursor of the keyset paging
+type listCursor struct {
+	// Sort sorting of the list, cursor is valid only for the same sorting
+	Sort string `json:"s"`
+	// Values values of the sort fields of the last row of the page
+	Values []string `json:"v"`
+}
+
+// sortSignature string of the sort fields, example: -name,id
+func sortSignature(sortFields []domain.SortField) string {
+	parts := make([]string, 0, len(sortFields))
+	for _, f := range sortFields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Field)
+			continue
+		}
+		parts = append(parts, f.Field)
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodeCursor returns opaque cursor of the list
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parse opaque cursor of the list
+func decodeCursor(raw string) (listCursor, error) {
+	cursor := listCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, fmt.Errorf("wrong cursor %s", raw)
+	}
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("wrong cursor %s", raw)
+	}
+	return cursor, nil
+}
+
+// nextCursor returns cursor of the next page after the last element of items (slice of the resources),
+// empty if the page isn't full
+func nextCursor(q domain.ListQuery, fields domain.ListFields, items interface{}, limit int64) string {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice || v.Len() == 0 || int64(v.Len()) < limit {
+		return ""
+	}
+	// values of the fields by json names of the resource
+	data, err := json.Marshal(v.Index(v.Len() - 1).Interface())
+	if err != nil {
+		return ""
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	last := make(map[string]interface{})
+	if err = dec.Decode(&last); err != nil {
+		return ""
+	}
+	sortFields := q.SortFields(fields.Key())
+	cursor := listCursor{Sort: sortSignature(sortFields), Values: make([]string, 0, len(sortFields))}
+	for _, f := range sortFields {
+		switch value := last[f.Field].(type) {
+		case string:
+			cursor.Values = append(cursor.Values, value)
+		case json.Number:
+			cursor.Values = append(cursor.Values, value.String())
+		case bool:
+			cursor.Values = append(cursor.Values, strconv.FormatBool(value))
+		default:
+			// keyset by null values isn't supported
+			return ""
+		}
+	}
+	return encodeCursor(cursor)
+}
+
+// infoNextCursor returns cursor of the next page of the CM_INFO list, empty query is served by cminfo package
+// in its own order, not by the sort fields of the cursor, so such pages have no cursor
+func infoNextCursor(q domain.ListQuery, fields domain.ListFields, items interface{}, limit int64) string {
+	if q.IsEmpty() {
+		return ""
+	}
+	return nextCursor(q, fields, items, limit)
+}
+
+// getListQuery parse http request parameters filter[field][op]=value, q=text, sort=-field1,field2,
+// after=cursor, count=false and validates fields against whitelist of the resource
+func (s *Server) getListQuery(c echo.Context, fields domain.ListFields) (domain.ListQuery, error) {
+	q := domain.ListQuery{Search: strings.TrimSpace(c.QueryParam("q"))}
+	if count := c.QueryParam("count"); count != "" {
+		withCount, err := strconv.ParseBool(count)
+		if err != nil {
+			return q, fmt.Errorf("wrong count parameter %s, allowed true or false", count)
+		}
+		q.NoCount = !withCount
+	}
+	params := c.QueryParams()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	// stable order of the filters for the same query
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		match := reFilterParam.FindStringSubmatch(key)
+		if match == nil {
+			return q, fmt.Errorf("wrong filter parameter %s, allowed format filter[field] or filter[field][op]", key)
+		}
+		field, op := match[1], match[2]
+		if !fields.Has(field) {
+			return q, fmt.Errorf("filter by field %s not allowed, allowed fields: %s", field, strings.Join(fields, ","))
+		}
+		if op == "" {
+			op = domain.OpEq
+		}
+		if !domain.ListFields(domain.FilterOps).Has(op) {
+			return q, fmt.Errorf("filter operator %s not allowed, allowed operators: %s", op,
+				strings.Join(domain.FilterOps, ","))
+		}
+		for _, value := range params[key] {
+			q.Filters = append(q.Filters, domain.Filter{Field: field, Op: op, Value: value})
+		}
+	}
+	if rawSort := strings.TrimSpace(c.QueryParam("sort")); rawSort != "" {
+		for _, part := range strings.Split(rawSort, ",") {
+			part = strings.TrimSpace(part)
+			item := domain.SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+			if !fields.Has(item.Field) {
+				return q, fmt.Errorf("sort by field %s not allowed, allowed fields: %s", item.Field,
+					strings.Join(fields, ","))
+			}
+			q.Sort = append(q.Sort, item)
+		}
+	}
+	rawAfter := c.QueryParam("after")
+	if rawAfter == "" {
+		return q, nil
+	}
+	cursor, err := decodeCursor(rawAfter)
+	if err != nil {
+		return q, err
+	}
+	sortFields := q.SortFields(fields.Key())
+	if cursor.Sort != sortSignature(sortFields) || len(cursor.Values) != len(sortFields) {
+		return q, fmt.Errorf("cursor doesn't match sorting %s", sortSignature(sortFields))
+	}
+	q.After = cursor.Values
+	return q, nil
+}
@@ -0,0 +1,91 @@
+package infra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestGetListQuery(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar()}
+	tCases := []struct {
+		title    string
+		url      string
+		expected string
+		isErr    bool
+	}{
+		{title: "empty", url: "/v2/projects", expected: ""},
+		{title: "filters", url: "/v2/projects?filter[managerId]=5&filter%5BtypeId%5D%5Bne%5D=2&offset=10",
+			expected: "filter[managerId][eq]=5&filter[typeId][ne]=2"},
+		{title: "search and sort", url: "/v2/projects?q=mega+mall&sort=-name,id",
+			expected: "q=mega+mall&sort=-name,id"},
+		{title: "not allowed field", url: "/v2/projects?filter[password]=1", isErr: true},
+		{title: "not allowed op", url: "/v2/projects?filter[id][in]=1", isErr: true},
+		{title: "wrong format", url: "/v2/projects?filter[id]]=1", isErr: true},
+		{title: "not allowed sort", url: "/v2/projects?sort=-password", isErr: true},
+		{title: "keyset without count", url: "/v2/projects?sort=-name&count=false&after=" +
+			encodeCursor(listCursor{Sort: "-name,id", Values: []string{"mall", "7"}}),
+			expected: "sort=-name&after=mall,7&count=false"},
+		{title: "cursor of other sorting", url: "/v2/projects?after=" +
+			encodeCursor(listCursor{Sort: "-name,id", Values: []string{"mall", "7"}}), isErr: true},
+		{title: "wrong cursor", url: "/v2/projects?after=abc", isErr: true},
+		{title: "wrong count", url: "/v2/projects?count=no", isErr: true},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			e := echo.New()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, tc.url, nil), httptest.NewRecorder())
+			q, err := s.getListQuery(c, domain.ProjectListFields)
+			if tc.isErr {
+				if err == nil {
+					t.Errorf("Expected error, but got query %s", q)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected success getListQuery, but error %v", err)
+			}
+			if q.String() != tc.expected {
+				t.Errorf("Expected query %s, but got %s", tc.expected, q)
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	q := domain.ListQuery{Sort: []domain.SortField{{Field: "name", Desc: true}}}
+	projects := domain.Projects{{ID: 1, Name: "b"}, {ID: 7, Name: "a"}}
+	tCases := []struct {
+		title    string
+		limit    int64
+		expected string
+	}{
+		{title: "full page", limit: 2,
+			expected: encodeCursor(listCursor{Sort: "-name,id", Values: []string{"a", "7"}})},
+		{title: "last page", limit: 3, expected: ""},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			cursor := nextCursor(q, domain.ProjectListFields, projects, tc.limit)
+			if cursor != tc.expected {
+				t.Errorf("Expected cursor %s, but got %s", tc.expected, cursor)
+			}
+		})
+	}
+}
+
+func TestInfoNextCursor(t *testing.T) {
+	projects := domain.Projects{{ID: 1, Name: "b"}, {ID: 7, Name: "a"}}
+	if cursor := infoNextCursor(domain.ListQuery{}, domain.ProjectListFields, projects, 2); cursor != "" {
+		t.Errorf("Expected no cursor of the page in cminfo order, but got %s", cursor)
+	}
+	expected := encodeCursor(listCursor{Sort: "id", Values: []string{"7"}})
+	cursor := infoNextCursor(domain.ListQuery{NoCount: true}, domain.ProjectListFields, projects, 2)
+	if cursor != expected {
+		t.Errorf("Expected cursor %s, but got %s", expected, cursor)
+	}
+}
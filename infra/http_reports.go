@@ -0,0 +1,198 @@
+package infra
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// kinds of the mismatches of the assets and CM_INFO projects
+const (
+	mismatchMissingProject      string = "missing_project"
+	mismatchDisabledProject     string = "disabled_project"
+	mismatchProjectWithoutAsset string = "project_without_asset"
+	mismatchNameDrift           string = "name_drift"
+)
+
+const (
+	// reportPageSize page of the repositories read by the reports
+	reportPageSize  int64  = 1000
+	reportFormatCSV string = "csv"
+)
+
+// AssetProjectMismatch asset of the Intraservice which id (field 62) doesn't match CM_INFO project
+type AssetProjectMismatch struct {
+	Kind          string `json:"kind"`
+	AssetID       int64  `json:"assetId"`
+	ServiceDeskID int64  `json:"serviceDeskId,omitempty"`
+	AssetName     string `json:"assetName,omitempty"`
+	ProjectID     int64  `json:"projectId,omitempty"`
+	ProjectName   string `json:"projectName,omitempty"`
+}
+
+// AssetProjectMismatchesResponse report with count of the mismatches by kinds
+type AssetProjectMismatchesResponse struct {
+	Data    []AssetProjectMismatch `json:"data"`
+	Summary map[string]int         `json:"summary"`
+	Metadata
+}
+
+// apiAssetProjectMismatches godoc
+// @Summary Report of the mismatches of the assets and projects
+// @Description Reconciles ids (field 62) of the Intraservice assets with CM_INFO projects: assets of the missing
+// @Description or disabled projects, enabled projects without asset and different names.
+// @Description format=csv or Accept: text/csv returns csv file
+// @Produce json
+// @Produce text/csv
+// @Security ApiKeyAuth
+// @Tags reports
+// @Param format query string false "json (default) or csv"
+// @Param typeId query integer false "type of the projects checked for project_without_asset, default - all"
+// @Success 200 {object} infra.AssetProjectMismatchesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/reports/asset-project-mismatches [get]
+func (s *Server) apiAssetProjectMismatches(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	var typeID int64
+	if param := c.QueryParam("typeId"); param != "" {
+		if typeID = atoi64(param); typeID <= 0 {
+			log.Warnf("bad request apiAssetProjectMismatches, typeId=%s", param)
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(fmt.Errorf("wrong typeId %s", param)))
+		}
+	}
+	ctx := c.Request().Context()
+	assets, err := s.allAssets(ctx)
+	if err != nil {
+		log.Errorf("apiAssetProjectMismatches, assets error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	projects, err := s.allProjects(ctx)
+	if err != nil {
+		log.Errorf("apiAssetProjectMismatches, projects error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	report := assetProjectMismatches(assets, projects, typeID)
+	if c.QueryParam("format") == reportFormatCSV ||
+		strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv") {
+		return writeMismatchesCSV(c, report)
+	}
+	summary := make(map[string]int)
+	for _, m := range report {
+		summary[m.Kind]++
+	}
+	count := int64(len(report))
+	return c.JSON(http.StatusOK, AssetProjectMismatchesResponse{Data: report, Summary: summary,
+		Metadata: Metadata{ResultSet: ResultSet{Count: count, Limit: count, Total: count}}})
+}
+
+// allAssets reads all assets by keyset pages
+func (s *Server) allAssets(ctx context.Context) (domain.Assets, error) {
+	result := make(domain.Assets, 0)
+	q := domain.ListQuery{NoCount: true}
+	for {
+		page, _, err := s.getAssetRepo().FindAll(ctx, q, 0, reportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+		if int64(len(page)) < reportPageSize {
+			return result, nil
+		}
+		q.After = []string{strconv.FormatInt(page[len(page)-1].ServiceDeskID, 10)}
+	}
+}
+
+// allProjects reads enabled and disabled projects by keyset pages
+func (s *Server) allProjects(ctx context.Context) (domain.Projects, error) {
+	result := make(domain.Projects, 0)
+	for _, enabled := range []bool{true, false} {
+		q := domain.ListQuery{NoCount: true}
+		for {
+			page, _, err := s.getInfoRepo().FindProjects(ctx, q, 0, reportPageSize, enabled)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, page...)
+			if int64(len(page)) < reportPageSize {
+				break
+			}
+			q.After = []string{strconv.FormatInt(page[len(page)-1].ID, 10)}
+		}
+	}
+	return result, nil
+}
+
+// assetProjectMismatches joins assets and projects by id, typeID > 0 limits projects checked for the missing asset
+func assetProjectMismatches(assets domain.Assets, projects domain.Projects, typeID int64) []AssetProjectMismatch {
+	byID := make(map[int64]domain.Project, len(projects))
+	for _, p := range projects {
+		byID[p.ID] = p
+	}
+	withAsset := make(map[int64]bool, len(assets))
+	result := make([]AssetProjectMismatch, 0)
+	for _, a := range assets {
+		m := AssetProjectMismatch{AssetID: a.ID, ServiceDeskID: a.ServiceDeskID, AssetName: a.Name}
+		p, ok := byID[a.ID]
+		if ok {
+			withAsset[p.ID] = true
+			m.ProjectID, m.ProjectName = p.ID, p.Name
+		}
+		switch {
+		case !ok:
+			m.Kind = mismatchMissingProject
+		case !p.IsEnabled:
+			m.Kind = mismatchDisabledProject
+		case normalizeName(a.Name) != normalizeName(p.Name):
+			m.Kind = mismatchNameDrift
+		default:
+			continue
+		}
+		result = append(result, m)
+	}
+	for _, p := range projects {
+		if p.IsEnabled && !withAsset[p.ID] && (typeID == 0 || p.TypeID == typeID) {
+			result = append(result, AssetProjectMismatch{Kind: mismatchProjectWithoutAsset, ProjectID: p.ID,
+				ProjectName: p.Name})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		if result[i].ProjectID != result[j].ProjectID {
+			return result[i].ProjectID < result[j].ProjectID
+		}
+		return result[i].ServiceDeskID < result[j].ServiceDeskID
+	})
+	return result
+}
+
+// normalizeName name for comparison: case, spaces, quotes and ё are ignored
+func normalizeName(name string) string {
+	name = strings.NewReplacer("ё", "е", "\"", "", "«", "", "»", "").Replace(strings.ToLower(name))
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// writeMismatchesCSV writes report as csv file
+func writeMismatchesCSV(c echo.Context, report []AssetProjectMismatch) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="asset-project-mismatches.csv"`)
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	_ = w.Write([]string{"kind", "assetId", "serviceDeskId", "assetName", "projectId", "projectName"})
+	for _, m := range report {
+		_ = w.Write([]string{m.Kind, strconv.FormatInt(m.AssetID, 10), strconv.FormatInt(m.ServiceDeskID, 10),
+			m.AssetName, strconv.FormatInt(m.ProjectID, 10), m.ProjectName})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,94 @@
+package infra
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeProjectRepo CM_INFO projects in memory
+type fakeProjectRepo struct {
+	domain.CustomerRepo
+	projects domain.Projects
+}
+
+func (f *fakeProjectRepo) FindProjects(_ context.Context, _ domain.ListQuery, _, _ int64,
+	enabled bool) (domain.Projects, int64, error) {
+	result := make(domain.Projects, 0)
+	for _, p := range f.projects {
+		if p.IsEnabled == enabled {
+			result = append(result, p)
+		}
+	}
+	return result, int64(len(result)), nil
+}
+
+func TestAPIAssetProjectMismatches(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar(),
+		assetRepo: &fakeAssetRepo{assets: domain.Assets{
+			{ID: 1, ServiceDeskID: 11, Name: "ТЦ «Ёлка»"},
+			{ID: 2, ServiceDeskID: 12, Name: "Mall"},
+			{ID: 3, ServiceDeskID: 13, Name: "Closed store"},
+			{ID: 9, ServiceDeskID: 19, Name: "Unknown"},
+		}},
+		infoRepo: &fakeProjectRepo{projects: domain.Projects{
+			{ID: 1, Name: "тц  елка", IsEnabled: true, TypeID: 2},
+			{ID: 2, Name: "Mega Mall", IsEnabled: true, TypeID: 2},
+			{ID: 3, Name: "Closed store", TypeID: 2},
+			{ID: 4, Name: "New store", IsEnabled: true, TypeID: 2},
+			{ID: 5, Name: "Customer", IsEnabled: true, TypeID: 1},
+		}},
+	}
+	e := echo.New()
+	e.GET("/v2/reports/asset-project-mismatches", s.apiAssetProjectMismatches)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/reports/asset-project-mismatches?typeId=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected code=200, but got %d, body=%s", rec.Code, rec.Body.String())
+	}
+	resp := AssetProjectMismatchesResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Expected report, but error %v", err)
+	}
+	expected := []struct {
+		kind      string
+		projectID int64
+		assetID   int64
+	}{
+		{kind: mismatchDisabledProject, projectID: 3, assetID: 3},
+		{kind: mismatchMissingProject, assetID: 9},
+		{kind: mismatchNameDrift, projectID: 2, assetID: 2},
+		{kind: mismatchProjectWithoutAsset, projectID: 4},
+	}
+	if len(resp.Data) != len(expected) {
+		t.Fatalf("Expected %d mismatches, but got %+v", len(expected), resp.Data)
+	}
+	for i, m := range resp.Data {
+		if m.Kind != expected[i].kind || m.ProjectID != expected[i].projectID || m.AssetID != expected[i].assetID {
+			t.Errorf("Expected %+v, but got %+v", expected[i], m)
+		}
+	}
+	if resp.Summary[mismatchNameDrift] != 1 {
+		t.Errorf("Expected summary with 1 name drift, but got %v", resp.Summary)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v2/reports/asset-project-mismatches", nil)
+	req.Header.Set(echo.HeaderAccept, "text/csv")
+	e.ServeHTTP(rec, req)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected csv, but error %v", err)
+	}
+	// header and 5 mismatches, customer 5 is checked without typeId
+	if len(rows) != 6 || rows[0][0] != "kind" || rows[5][4] != "5" {
+		t.Errorf("Expected header and 5 rows, but got %v", rows)
+	}
+}
@@ -1,8 +1,14 @@
 package infra
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"git.countmax.ru/countmax/commonapi/domain"
 	"github.com/labstack/echo/v4"
@@ -11,23 +17,59 @@ import (
 var (
 	errTasksCntrlNotFound error = errors.New("tasks for controller not found")
 	errEmptySN            error = errors.New("empty sn not allowed")
+	errTaskFormat         error = errors.New("format must be empty or raw")
+	errEmptyProjectID     error = errors.New("projectId must be positive")
+	errEmptyCategory      error = errors.New("empty category not allowed")
+	errEmptyProblem       error = errors.New("empty problem not allowed")
+	errTaskDateRange      error = errors.New("from must be before to")
+	errTaskNotFound       error = errors.New("task not found")
+	errUnknownStatus      error = errors.New("unknown statusId")
+	errUnknownResultField error = errors.New("unknown resultFieldName")
 )
 
-// SDTasksResponse http wrapper with metadata
+// taskDateLayout date without time in the from and to parameters
+const taskDateLayout string = "2006-01-02"
+
+// taskDedupPage page of the tasks of the controller checked for the open task with the same category
+const taskDedupPage int64 = 100
+
+// taskFormatRaw legacy format of the tasks, json strings of the ServiceDesk as is
+const taskFormatRaw string = "raw"
+
+// SDTasksResponse http wrapper with metadata, legacy format of the tasks (format=raw)
 type SDTasksResponse struct {
 	Data domain.ControllerTasks `json:"data"`
 	Metadata
 }
 
+// SDReferencesResponse http wrapper with metadata
+type SDReferencesResponse struct {
+	Data domain.SDReferences `json:"data"`
+	Metadata
+}
+
+// TasksResponse http wrapper with metadata
+type TasksResponse struct {
+	Data domain.Tasks `json:"data"`
+	Metadata
+}
+
 // apiTasksByControllerSN godoc
 // @Summary Get all tasks for controller's serial number
-// @Description Get slice of tasks (json) for controller by serial number with limit parameters
+// @Description Get slice of tasks for controller by serial number with limit parameters,
+// @Description format=raw returns json strings of the ServiceDesk tasks (legacy format)
 // @Produce  json
 // @Security ApiKeyAuth
 // @Tags intraservice
+// @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
+// @Param status query string false "comma separated status ids"
+// @Param service query string false "comma separated service ids"
+// @Param from query string false "created from, RFC3339 or 2006-01-02"
+// @Param to query string false "created to inclusive, RFC3339 or 2006-01-02 (whole day)"
+// @Param format query string false "raw - legacy format" Enums(raw)
 // @Param sn path string true "SerialNumber of controller"
-// @Success 200 {object} infra.SDTasksResponse
+// @Success 200 {object} infra.TasksResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
 // @Failure 405 {object} infra.HTTPError
@@ -35,31 +77,119 @@ type SDTasksResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/tasks/controllers/{sn} [get]
 func (s *Server) apiTasksByControllerSN(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
 	sn := c.Param("sn")
 	if sn == "" {
-		s.log.Errorf("bad request apiTasksByControllerSN, %v", errEmptySN)
+		log.Errorf("bad request apiTasksByControllerSN, %v", errEmptySN)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptySN))
 	}
-	tasks, count, err := s.sdRepo.FindAllBySN(sn, offset, limit)
+	format := c.QueryParam("format")
+	if format != "" && format != taskFormatRaw {
+		log.Errorf("bad request apiTasksByControllerSN, %v", errTaskFormat)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errTaskFormat))
+	}
+	filter, err := getTaskFilter(c)
+	if err != nil {
+		log.Errorf("bad request apiTasksByControllerSN, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	tasks, count, err := s.sdRepo.FindAllBySN(c.Request().Context(), sn, filter, offset, limit)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if tasks == nil || len(tasks) == 0 {
 		return c.JSON(http.StatusNotFound, ErrNotFound(errTasksCntrlNotFound))
 	}
-	response := SDTasksResponse{
-		Data: tasks,
-		Metadata: Metadata{
-			ResultSet: ResultSet{
-				Count:  int64(len(tasks)),
-				Offset: 0,
-				Limit:  limit,
-				Total:  count,
-			},
+	metadata := Metadata{
+		ResultSet: ResultSet{
+			Count:  int64(len(tasks)),
+			Offset: offset,
+			Limit:  limit,
+			Total:  count,
 		},
 	}
-	return c.JSON(http.StatusOK, response)
+	if format == taskFormatRaw {
+		raw := make(domain.ControllerTasks, 0, len(tasks))
+		for i := range tasks {
+			raw = append(raw, domain.ControllerTask{Task: string(tasks[i].Raw)})
+		}
+		return c.JSON(http.StatusOK, SDTasksResponse{Data: raw, Metadata: metadata})
+	}
+	return c.JSON(http.StatusOK, TasksResponse{Data: tasks, Metadata: metadata})
+}
+
+// apiTaskByID godoc
+// @Summary Get task by id
+// @Description Get task of the ServiceDesk by TaskID, format=raw returns json string of the task (legacy format)
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "TaskID"
+// @Param format query string false "raw - legacy format" Enums(raw)
+// @Success 200 {object} domain.Task
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/{id} [get]
+func (s *Server) apiTaskByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id <= 0 {
+		log.Errorf("bad request apiTaskByID, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	format := c.QueryParam("format")
+	if format != "" && format != taskFormatRaw {
+		log.Errorf("bad request apiTaskByID, %v", errTaskFormat)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errTaskFormat))
+	}
+	task, err := s.sdRepo.FindTaskByID(c.Request().Context(), id)
+	if err != nil {
+		log.Errorf("sdRepo.FindTaskByID for id=%d, error %v", id, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+	}
+	if task == nil {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errTaskNotFound))
+	}
+	if format == taskFormatRaw {
+		return c.JSON(http.StatusOK, domain.ControllerTask{Task: string(task.Raw)})
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// apiTaskLifetime godoc
+// @Summary Get history of the task
+// @Description comments (author, date, private flag, text) and status transitions of the Task in order of date
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "TaskID"
+// @Success 200 {object} domain.TaskLifetime
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/{id}/lifetime [get]
+func (s *Server) apiTaskLifetime(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id <= 0 {
+		log.Errorf("bad request apiTaskLifetime, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	lifetime, err := s.sdRepo.TaskLifetime(c.Request().Context(), id)
+	if err != nil {
+		log.Errorf("sdRepo.TaskLifetime for id=%d, error %v", id, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+	}
+	if lifetime == nil {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errTaskNotFound))
+	}
+	return c.JSON(http.StatusOK, lifetime)
 }
 
 // apiTaskAddComment godoc
@@ -78,20 +208,22 @@ func (s *Server) apiTasksByControllerSN(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/tasks/{id}/comment [put]
 func (s *Server) apiTaskAddComment(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiTaskAddComment, %v", errEmptyID)
+		log.Errorf("bad request apiTaskAddComment, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
 	comment := &domain.TaskComment{}
 	if err := c.Bind(comment); err != nil {
-		s.log.Errorf("apiTaskAddComment, bad request error %v", err)
+		log.Errorf("apiTaskAddComment, bad request error %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidRequest(err))
 	}
-	err := s.sdRepo.TaskAddComment(id, comment.Comment)
+	err := s.sdRepo.TaskAddComment(c.Request().Context(), id, comment.Comment)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
+	s.recordAudit(c, "task/"+id, domain.AuditComment, nil, comment)
 	return c.JSON(http.StatusOK, OkStatus("success"))
 }
 
@@ -101,6 +233,8 @@ func (s *Server) apiTaskAddComment(c echo.Context) error {
 // @Produce  json
 // @Security ApiKeyAuth
 // @Tags intraservice
+// @Description statusId must be in /v2/tasks/statuses, resultFieldName must be key (Field1101) or name
+// @Description of the field in /v2/tasks/fields, name is replaced by key
 // @Param status body domain.TaskStatus true "status data description"
 // @Param id path string true "TaskID"
 // @Success 200 {object} infra.SuccessResponse
@@ -111,19 +245,375 @@ func (s *Server) apiTaskAddComment(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/tasks/{id}/status [put]
 func (s *Server) apiTaskChangeStatus(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	id := c.Param("id")
 	if id == "" {
-		s.log.Errorf("bad request apiTaskChangeStatus, %v", errEmptyID)
+		log.Errorf("bad request apiTaskChangeStatus, %v", errEmptyID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
 	}
 	status := domain.TaskStatus{}
 	if err := c.Bind(&status); err != nil {
-		s.log.Errorf("apiTaskChangeStatus, bad request error %v", err)
+		log.Errorf("apiTaskChangeStatus, bad request error %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidRequest(err))
 	}
-	err := s.sdRepo.TaskSetStatus(id, status)
+	if code, err := s.validateTaskStatus(c.Request().Context(), &status); err != nil {
+		log.Errorf("apiTaskChangeStatus, validation error %v", err)
+		return c.JSON(code, ErrResponse{Err: err, HTTPStatusCode: code,
+			StatusText: http.StatusText(code), ErrorText: err.Error()})
+	}
+	before := s.taskAuditState(c.Request().Context(), id)
+	err := s.sdRepo.TaskSetStatus(c.Request().Context(), id, status)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
+	s.recordAudit(c, "task/"+id, domain.AuditStatus, before, status)
 	return c.JSON(http.StatusOK, OkStatus("success"))
 }
+
+// taskAuditState returns status of the task before the change for the audit, nil if it isn't read
+func (s *Server) taskAuditState(ctx context.Context, id string) interface{} {
+	log := domain.RequestLogger(ctx, s.log)
+	if s.audit == nil {
+		return nil
+	}
+	task, err := s.sdRepo.FindTaskByID(ctx, atoi64(id))
+	if err != nil || task == nil {
+		log.Warnf("audit of the task id=%s, previous status isn't read, %v", id, err)
+		return nil
+	}
+	return domain.TaskStatus{StatusID: int(task.StatusID)}
+}
+
+// getTaskFilter parses status, service, from and to parameters of the tasks search
+func getTaskFilter(c echo.Context) (domain.TaskFilter, error) {
+	filter := domain.TaskFilter{}
+	var err error
+	if filter.StatusIDs, err = parseIDs(c.QueryParam("status")); err != nil {
+		return filter, fmt.Errorf("status %w", err)
+	}
+	if filter.ServiceIDs, err = parseIDs(c.QueryParam("service")); err != nil {
+		return filter, fmt.Errorf("service %w", err)
+	}
+	if filter.CreatedFrom, err = parseTaskDate(c.QueryParam("from"), false); err != nil {
+		return filter, fmt.Errorf("from %w", err)
+	}
+	if filter.CreatedTo, err = parseTaskDate(c.QueryParam("to"), true); err != nil {
+		return filter, fmt.Errorf("to %w", err)
+	}
+	if filter.CreatedFrom != nil && filter.CreatedTo != nil && filter.CreatedTo.Before(*filter.CreatedFrom) {
+		return filter, errTaskDateRange
+	}
+	return filter, nil
+}
+
+// parseIDs parses comma separated list of the positive ids
+func parseIDs(value string) ([]int64, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	ids := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("wrong id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseTaskDate parses RFC3339 or date, date of the end of range includes the whole day
+func parseTaskDate(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation(taskDateLayout, value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("wrong date %q, expected RFC3339 or %s", value, taskDateLayout)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return &t, nil
+}
+
+// keyLocks mutexes by key, unused mutexes are removed, zero value is ready to use
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks mutex of the key, returns unlock function
+func (kl *keyLocks) lock(key string) func() {
+	kl.mu.Lock()
+	if kl.locks == nil {
+		kl.locks = make(map[string]*keyLock)
+	}
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.refs++
+	kl.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		kl.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(kl.locks, key)
+		}
+		kl.mu.Unlock()
+	}
+}
+
+// taskTitle title of the controller task, used to find open task with the same SN and category
+func taskTitle(category, sn string) string {
+	return fmt.Sprintf("[%s] %s", strings.TrimSpace(category), strings.TrimSpace(sn))
+}
+
+// findOpenTask returns open task of the controller with the same category or nil, tasks of the open statuses
+// are checked from the newest one until the end of the search
+func (s *Server) findOpenTask(c echo.Context, req domain.NewTask) (*domain.Task, error) {
+	filter := domain.TaskFilter{StatusIDs: s.sdOpenStatuses, NewestFirst: true}
+	title := taskTitle(req.Category, req.SN)
+	for offset := int64(0); ; offset += taskDedupPage {
+		tasks, count, err := s.sdRepo.FindAllBySN(c.Request().Context(), req.SN, filter, offset, taskDedupPage)
+		if err != nil {
+			return nil, err
+		}
+		for i := range tasks {
+			if tasks[i].Closed == nil && strings.EqualFold(strings.TrimSpace(tasks[i].Title), title) {
+				return &tasks[i], nil
+			}
+		}
+		if int64(len(tasks)) < taskDedupPage || offset+taskDedupPage >= count {
+			return nil, nil
+		}
+	}
+}
+
+// apiNewTask godoc
+// @Summary Create task for controller
+// @Description creates task in the ServiceDesk for controller of the project, service, creator and destination
+// @Description are taken from the customer config of the project. If there is an open task for the same sn
+// @Description and category, the task isn't created and the open task is returned with 200,
+// @Description requests of the same sn and category are serialized by the lock in CM_INFO for all replicas
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Accept json
+// @Produce json
+// @Param task body domain.NewTask true "controller and problem"
+// @Success 201 {object} domain.Task
+// @Success 200 {object} domain.Task
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks [post]
+func (s *Server) apiNewTask(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	req := domain.NewTask{}
+	if err := c.Bind(&req); err != nil {
+		log.Errorf("apiNewTask, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	req.SN, req.Category = strings.TrimSpace(req.SN), strings.TrimSpace(req.Category)
+	var errReq error
+	switch {
+	case req.SN == "":
+		errReq = errEmptySN
+	case req.ProjectID <= 0:
+		errReq = errEmptyProjectID
+	case req.Category == "":
+		errReq = errEmptyCategory
+	case strings.TrimSpace(req.Problem) == "":
+		errReq = errEmptyProblem
+	}
+	if errReq != nil {
+		log.Errorf("bad request apiNewTask, %v", errReq)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errReq))
+	}
+	cfg, err := s.getInfoRepo().FindCustomerConfig(c.Request().Context(), req.ProjectID)
+	if err != nil {
+		log.Errorf("infoRepo.FindCustomerConfig for id=%d, error %v", req.ProjectID, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	}
+	if cfg == nil {
+		log.Warnf("apiNewTask, customer config for project=%d not found", req.ProjectID)
+		return c.JSON(http.StatusNotFound, ErrNotFound(errCustomerConfigNotFound))
+	}
+	if cfg.SdServiceID <= 0 || cfg.SdCreatorID <= 0 {
+		log.Warnf("apiNewTask, customer config for project=%d has no service desk parameters", req.ProjectID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errWrongSdServiceID))
+	}
+	task := domain.Task{
+		Title:     taskTitle(req.Category, req.SN),
+		ServiceID: cfg.SdServiceID,
+		CreatorID: cfg.SdCreatorID,
+		Description: fmt.Sprintf("%s\n\nSN: %s\nProject: %d %s", strings.TrimSpace(req.Problem), req.SN,
+			req.ProjectID, cfg.CustomerName),
+	}
+	if cfg.SdDestination != "" {
+		if s.sdDestinationField != "" {
+			task.Fields = map[string]string{s.sdDestinationField: cfg.SdDestination}
+		} else {
+			task.Description += "\nDestination: " + cfg.SdDestination
+		}
+	}
+	// the same controller and category are processed one by one to avoid duplicates of the task,
+	// in this process by taskLocks and by all replicas of the service by the lock in CM_INFO
+	key := taskTitle(strings.ToLower(req.Category), req.SN)
+	unlock := s.taskLocks.lock(key)
+	defer unlock()
+	var open, created *domain.Task
+	var errSD error
+	err = s.getInfoRepo().WithLock(c.Request().Context(), "task:"+key, func(ctx context.Context) error {
+		if open, errSD = s.findOpenTask(c, req); errSD != nil || open != nil {
+			return errSD
+		}
+		created, errSD = s.sdRepo.CreateTask(ctx, task)
+		return errSD
+	})
+	switch {
+	case errSD != nil:
+		log.Errorf("apiNewTask, search or create task for sn=%s, error %v", req.SN, errSD)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+	case err != nil:
+		log.Errorf("infoRepo.WithLock for sn=%s, error %v", req.SN, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errDB))
+	case open != nil:
+		log.Infof("apiNewTask, open task %d for sn=%s, category=%s already exists", open.ID, req.SN, req.Category)
+		c.Response().Header().Set("Location", "/v2/tasks/"+strconv.FormatInt(open.ID, 10))
+		return c.JSON(http.StatusOK, open)
+	}
+	s.recordAudit(c, "task/"+strconv.FormatInt(created.ID, 10), domain.AuditCreate, nil, created)
+	c.Response().Header().Set("Location", "/v2/tasks/"+strconv.FormatInt(created.ID, 10))
+	return c.JSON(http.StatusCreated, created)
+}
+
+// validateTaskStatus checks status and result field by the dictionaries of the ServiceDesk,
+// name of the result field is replaced by its key, returns http status code and error
+func (s *Server) validateTaskStatus(ctx context.Context, status *domain.TaskStatus) (int, error) {
+	log := domain.RequestLogger(ctx, s.log)
+	if status.StatusID != 0 {
+		statuses, err := s.sdRepo.FindDictionary(ctx, domain.SDStatuses)
+		if err != nil {
+			log.Errorf("sdRepo.FindDictionary %s, error %v", domain.SDStatuses, err)
+			return http.StatusInternalServerError, errIntraserviceAPI
+		}
+		if statuses.FindByID(int64(status.StatusID)) == nil {
+			return http.StatusBadRequest, fmt.Errorf("%w %d", errUnknownStatus, status.StatusID)
+		}
+	}
+	if status.ResultFieldName == "" {
+		return http.StatusOK, nil
+	}
+	fields, err := s.sdRepo.FindDictionary(ctx, domain.SDTaskFields)
+	if err != nil {
+		log.Errorf("sdRepo.FindDictionary %s, error %v", domain.SDTaskFields, err)
+		return http.StatusInternalServerError, errIntraserviceAPI
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.Key, status.ResultFieldName) || strings.EqualFold(f.Name, status.ResultFieldName) {
+			status.ResultFieldName = f.Key
+			return http.StatusOK, nil
+		}
+	}
+	return http.StatusBadRequest, fmt.Errorf("%w %s", errUnknownResultField, status.ResultFieldName)
+}
+
+// apiSDDictionary returns handler of the ServiceDesk dictionary
+func (s *Server) apiSDDictionary(name string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refs, err := s.sdRepo.FindDictionary(c.Request().Context(), name)
+		if err != nil {
+			s.log.Errorf("sdRepo.FindDictionary %s, error %v", name, err)
+			return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+		}
+		count := int64(len(refs))
+		return c.JSON(http.StatusOK, SDReferencesResponse{Data: refs,
+			Metadata: Metadata{ResultSet: ResultSet{Count: count, Limit: count, Total: count}}})
+	}
+}
+
+// apiSDStatuses godoc
+// @Summary Statuses of the tasks
+// @Description dictionary of the ServiceDesk, cached
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Success 200 {object} infra.SDReferencesResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/statuses [get]
+func (s *Server) apiSDStatuses(c echo.Context) error {
+	return s.apiSDDictionary(domain.SDStatuses)(c)
+}
+
+// apiSDPriorities godoc
+// @Summary Priorities of the tasks
+// @Description dictionary of the ServiceDesk, cached
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Success 200 {object} infra.SDReferencesResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/priorities [get]
+func (s *Server) apiSDPriorities(c echo.Context) error {
+	return s.apiSDDictionary(domain.SDPriorities)(c)
+}
+
+// apiSDServices godoc
+// @Summary Services of the ServiceDesk
+// @Description dictionary of the ServiceDesk, cached
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Success 200 {object} infra.SDReferencesResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/services [get]
+func (s *Server) apiSDServices(c echo.Context) error {
+	return s.apiSDDictionary(domain.SDServices)(c)
+}
+
+// apiSDTaskTypes godoc
+// @Summary Types of the tasks
+// @Description dictionary of the ServiceDesk, cached
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Success 200 {object} infra.SDReferencesResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/types [get]
+func (s *Server) apiSDTaskTypes(c echo.Context) error {
+	return s.apiSDDictionary(domain.SDTaskTypes)(c)
+}
+
+// apiSDTaskFields godoc
+// @Summary Custom fields of the tasks
+// @Description dictionary of the ServiceDesk with type and key of the field (Field1101), cached
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Success 200 {object} infra.SDReferencesResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/fields [get]
+func (s *Server) apiSDTaskFields(c echo.Context) error {
+	return s.apiSDDictionary(domain.SDTaskFields)(c)
+}
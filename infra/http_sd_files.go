@@ -0,0 +1,279 @@
+package infra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// defaults of the limits of the files attached to the tasks
+const (
+	defaultTaskFileMaxSize int64 = 50 << 20
+	// taskFileSniffLen count of the bytes used to detect content type of the file
+	taskFileSniffLen int = 512
+	// taskFileFormOverhead size of the multipart headers and other fields of the form allowed above the file size
+	taskFileFormOverhead int64 = 64 << 10
+)
+
+var (
+	errTaskFileNotFound  error = errors.New("file of the task not found")
+	errTaskFileMissing   error = errors.New("multipart field file not found")
+	errTaskFileEmptyName error = errors.New("empty file name not allowed")
+	errTaskFileTooLarge  error = errors.New("file is too large")
+	errTaskFileType      error = errors.New("file type not allowed")
+	defaultTaskFileTypes       = []string{"image/*", "video/*", "application/pdf"}
+	// declaredFileTypes types not detected by content, declared Content-Type of the part is used only for them
+	declaredFileTypes = domain.ListFields{"video/quicktime", "video/x-msvideo", "video/x-matroska", "video/3gpp",
+		"image/heic", "image/heif"}
+)
+
+// TaskFilesResponse http wrapper with metadata
+type TaskFilesResponse struct {
+	Data domain.TaskFiles `json:"data"`
+	Metadata
+}
+
+// taskFileLimits returns max size and allowed types of the files, defaults if not configured
+func (s *Server) taskFileLimits() (int64, []string) {
+	maxSize, types := s.sdFileMaxSize, s.sdFileTypes
+	if maxSize <= 0 {
+		maxSize = defaultTaskFileMaxSize
+	}
+	if len(types) == 0 {
+		types = defaultTaskFileTypes
+	}
+	return maxSize, types
+}
+
+// allowedFileType checks media type by list of the types, type/* allows all subtypes
+func allowedFileType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileContentType returns content type detected by content, declared type is trusted
+// only if the content isn't detected and the type is one of the declaredFileTypes
+func fileContentType(head []byte, declared string) string {
+	contentType := http.DetectContentType(head)
+	if !strings.HasPrefix(contentType, echo.MIMEOctetStream) || declared == "" {
+		return contentType
+	}
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err == nil && declaredFileTypes.Has(mediaType) {
+		return mediaType
+	}
+	return contentType
+}
+
+// sizeLimitReader returns errTaskFileTooLarge when content exceeds the limit
+type sizeLimitReader struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		l.exceeded = true
+		return 0, errTaskFileTooLarge
+	}
+	// reads one byte more than the limit to find out that the content is too large
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		l.exceeded = true
+		return 0, errTaskFileTooLarge
+	}
+	return n, err
+}
+
+// errTooLarge response 413 for the file
+func errTooLarge(err error) ErrResponse {
+	return ErrResponse{Err: err, HTTPStatusCode: http.StatusRequestEntityTooLarge,
+		StatusText: http.StatusText(http.StatusRequestEntityTooLarge), ErrorText: err.Error()}
+}
+
+// apiTaskFiles godoc
+// @Summary Get files of the task
+// @Description list of the files attached to the Task in the ServiceDesk
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "TaskID"
+// @Success 200 {object} infra.TaskFilesResponse
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/{id}/files [get]
+func (s *Server) apiTaskFiles(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id <= 0 {
+		log.Errorf("bad request apiTaskFiles, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	files, err := s.sdRepo.TaskFiles(c.Request().Context(), id)
+	if err != nil {
+		log.Errorf("sdRepo.TaskFiles for task=%d, error %v", id, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+	}
+	count := int64(len(files))
+	return c.JSON(http.StatusOK, TaskFilesResponse{Data: files,
+		Metadata: Metadata{ResultSet: ResultSet{Count: count, Limit: count, Total: count}}})
+}
+
+// apiTaskFileContent godoc
+// @Summary Download file of the task
+// @Description content of the file attached to the Task in the ServiceDesk
+// @Produce  octet-stream
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "TaskID"
+// @Param fid path integer true "FileID"
+// @Success 200 {file} binary
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 405 {object} infra.HTTPError
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/{id}/files/{fid} [get]
+func (s *Server) apiTaskFileContent(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id, fid := atoi64(c.Param("id")), atoi64(c.Param("fid"))
+	if id <= 0 || fid <= 0 {
+		log.Errorf("bad request apiTaskFileContent, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	file, content, err := s.sdRepo.TaskFile(c.Request().Context(), id, fid)
+	if err != nil {
+		log.Errorf("sdRepo.TaskFile for task=%d, file=%d, error %v", id, fid, err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+	}
+	if file == nil {
+		return c.JSON(http.StatusNotFound, ErrNotFound(errTaskFileNotFound))
+	}
+	defer content.Close()
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	if file.Name != "" {
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	}
+	if file.Size > 0 {
+		c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(file.Size, 10))
+	}
+	return c.Stream(http.StatusOK, contentType, content)
+}
+
+// apiTaskAttachFile godoc
+// @Summary Attach file to the task
+// @Description streams file of the multipart field file to the Task in the ServiceDesk,
+// @Description size and type of the file are limited by intraservice.files settings, type is detected by content,
+// @Description declared Content-Type of the part is used only for quicktime, avi, mkv, 3gp, heic and heif
+// @Accept  multipart/form-data
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags intraservice
+// @Param id path integer true "TaskID"
+// @Param file formData file true "photo, video or document"
+// @Success 201 {object} domain.TaskFile
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 405 {object} infra.HTTPError
+// @Failure 413 {object} infra.ErrResponse
+// @Failure 415 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/tasks/{id}/files [post]
+func (s *Server) apiTaskAttachFile(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	id := atoi64(c.Param("id"))
+	if id <= 0 {
+		log.Errorf("bad request apiTaskAttachFile, %v", errEmptyID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyID))
+	}
+	maxSize, types := s.taskFileLimits()
+	if c.Request().ContentLength > maxSize+taskFileFormOverhead {
+		log.Warnf("apiTaskAttachFile for task=%d, %v: %d bytes", id, errTaskFileTooLarge,
+			c.Request().ContentLength)
+		return c.JSON(http.StatusRequestEntityTooLarge, errTooLarge(errTaskFileTooLarge))
+	}
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		log.Errorf("apiTaskAttachFile, bad request error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	// the file is read part by part and streamed to the ServiceDesk without buffering
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errTaskFileMissing))
+		}
+		if err != nil {
+			log.Errorf("apiTaskAttachFile, bad request error %v", err)
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+		name := strings.TrimSpace(part.FileName())
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errTaskFileEmptyName))
+		}
+		head := make([]byte, taskFileSniffLen)
+		n, err := io.ReadFull(part, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Errorf("apiTaskAttachFile, read file error %v", err)
+			return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+		}
+		head = head[:n]
+		contentType := fileContentType(head, part.Header.Get(echo.HeaderContentType))
+		if !allowedFileType(contentType, types) {
+			log.Warnf("apiTaskAttachFile for task=%d, %v: %s", id, errTaskFileType, contentType)
+			return c.JSON(http.StatusUnsupportedMediaType, ErrResponse{Err: errTaskFileType,
+				HTTPStatusCode: http.StatusUnsupportedMediaType,
+				StatusText:     http.StatusText(http.StatusUnsupportedMediaType),
+				ErrorText:      fmt.Sprintf("%v: %s", errTaskFileType, contentType)})
+		}
+		content := &sizeLimitReader{r: io.MultiReader(bytes.NewReader(head), part), left: maxSize}
+		file, err := s.sdRepo.AttachTaskFile(c.Request().Context(), id, name, contentType, content)
+		if content.exceeded {
+			log.Warnf("apiTaskAttachFile for task=%d, %v", id, errTaskFileTooLarge)
+			return c.JSON(http.StatusRequestEntityTooLarge, errTooLarge(errTaskFileTooLarge))
+		}
+		if err != nil {
+			log.Errorf("sdRepo.AttachTaskFile for task=%d, error %v", id, err)
+			return c.JSON(http.StatusInternalServerError, ErrServerInternal(errIntraserviceAPI))
+		}
+		s.recordAudit(c, fmt.Sprintf("task/%d", id), domain.AuditAttach, nil, file)
+		c.Response().Header().Set("Location", fmt.Sprintf("/v2/tasks/%d/files/%d", id, file.ID))
+		return c.JSON(http.StatusCreated, file)
+	}
+}
@@ -0,0 +1,433 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeTaskRepo Intraservice with tasks in memory
+type fakeTaskRepo struct {
+	domain.SDRepo
+	mu       sync.Mutex
+	tasks    domain.Tasks
+	created  []domain.Task
+	statuses []domain.TaskStatus
+	comments []string
+}
+
+func (f *fakeTaskRepo) FindAllBySN(_ context.Context, sn string, filter domain.TaskFilter, offset,
+	limit int64) (domain.Tasks, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(domain.Tasks, 0)
+	for _, t := range f.tasks {
+		if strings.Contains(t.Title, sn) &&
+			(len(filter.StatusIDs) == 0 || hasID(filter.StatusIDs, t.StatusID)) {
+			result = append(result, t)
+		}
+	}
+	if filter.NewestFirst {
+		sort.Slice(result, func(i, j int) bool { return result[i].ID > result[j].ID })
+	}
+	count := int64(len(result))
+	if offset > count {
+		offset = count
+	}
+	if offset+limit < count {
+		result = result[:offset+limit]
+	}
+	return result[offset:], count, nil
+}
+
+// hasID returns true if id is in the list
+func hasID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeTaskRepo) CreateTask(_ context.Context, task domain.Task) (*domain.Task, error) {
+	// slow Intraservice, concurrent requests must not create duplicates
+	time.Sleep(10 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task.ID = int64(100 + len(f.tasks))
+	f.tasks = append(f.tasks, task)
+	f.created = append(f.created, task)
+	return &task, nil
+}
+
+func TestAPINewTask(t *testing.T) {
+	closed := time.Now()
+	tCases := []struct {
+		title       string
+		body        string
+		field       string
+		code        int
+		id          int64
+		destination string
+	}{
+		{title: "create", body: `{"sn":"S2","projectId":1,"category":"offline","problem":"no data"}`,
+			code: http.StatusCreated, id: 102},
+		{title: "destination field", body: `{"sn":"S2","projectId":1,"category":"offline","problem":"no data"}`,
+			field: "1105", code: http.StatusCreated, id: 102, destination: "support"},
+		{title: "open task exists", body: `{"sn":"S1","projectId":1,"category":"Offline","problem":"no data"}`,
+			code: http.StatusOK, id: 100},
+		{title: "closed task only", body: `{"sn":"S1","projectId":1,"category":"video","problem":"no video"}`,
+			code: http.StatusCreated, id: 102},
+		{title: "unknown project", body: `{"sn":"S1","projectId":2,"category":"offline","problem":"no data"}`,
+			code: http.StatusNotFound},
+		{title: "empty category", body: `{"sn":"S1","projectId":1,"problem":"no data"}`,
+			code: http.StatusBadRequest},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			sd := &fakeTaskRepo{tasks: domain.Tasks{
+				{ID: 100, Title: "[offline] S1"},
+				{ID: 101, Title: "[video] S1", Closed: &closed},
+			}}
+			audit, _ := repos.NewFileAudit(filepath.Join(t.TempDir(), "audit.jsonl"))
+			s := &Server{
+				log: zap.NewNop().Sugar(),
+				infoRepo: &fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{
+					1: {CustomerID: 1, CustomerName: "Mall", SdServiceID: 10, SdCreatorID: 20,
+						SdDestination: "support"},
+				}},
+				sdRepo:             sd,
+				sdDestinationField: tc.field,
+				audit:              audit,
+			}
+			e := echo.New()
+			e.POST("/v2/tasks", s.apiNewTask)
+			req := httptest.NewRequest(http.MethodPost, "/v2/tasks", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code >= http.StatusBadRequest {
+				return
+			}
+			task := domain.Task{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+				t.Fatalf("Expected task in response, but error %v", err)
+			}
+			if task.ID != tc.id {
+				t.Errorf("Expected task id %d, but got %d", tc.id, task.ID)
+			}
+			records, _ := audit.Find(context.Background(), domain.AuditFilter{}, 10)
+			if created := rec.Code == http.StatusCreated; created != (len(records) == 1) || created &&
+				(records[0].Resource != fmt.Sprintf("task/%d", tc.id) || records[0].Action != domain.AuditCreate) {
+				t.Errorf("Expected audit record of the created task only, but got %+v", records)
+			}
+			if rec.Code == http.StatusOK {
+				if len(sd.created) != 0 {
+					t.Errorf("Expected no new tasks, but got %v", sd.created)
+				}
+				return
+			}
+			if task.ServiceID != 10 || task.CreatorID != 20 {
+				t.Errorf("Expected service 10 and creator 20, but got %d and %d", task.ServiceID, task.CreatorID)
+			}
+			if tc.destination != "" && task.Fields[tc.field] != tc.destination {
+				t.Errorf("Expected destination %s in field %s, but got %v", tc.destination, tc.field, task.Fields)
+			}
+			if tc.destination == "" && !strings.Contains(task.Description, "support") {
+				t.Errorf("Expected destination in description, but got %s", task.Description)
+			}
+		})
+	}
+}
+
+func TestAPINewTaskOpenStatuses(t *testing.T) {
+	// the open task of the category is older than the page of the tasks of the controller
+	tasks := domain.Tasks{{ID: 1, Title: "[offline] S1", StatusID: 31}, {ID: 2, Title: "[power] S1", StatusID: 29}}
+	for id := int64(10); id < 260; id++ {
+		tasks = append(tasks, domain.Task{ID: id, Title: "[video] S1", StatusID: 27})
+	}
+	tCases := []struct {
+		title    string
+		category string
+		code     int
+		id       int64
+	}{
+		{title: "open task after the first page", category: "offline", code: http.StatusOK, id: 1},
+		{title: "done task", category: "power", code: http.StatusCreated},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			sd := &fakeTaskRepo{tasks: append(domain.Tasks{}, tasks...)}
+			s := &Server{
+				log: zap.NewNop().Sugar(),
+				infoRepo: &fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{
+					1: {CustomerID: 1, SdServiceID: 10, SdCreatorID: 20},
+				}},
+				sdRepo:         sd,
+				sdOpenStatuses: []int64{31, 27},
+			}
+			e := echo.New()
+			e.POST("/v2/tasks", s.apiNewTask)
+			req := httptest.NewRequest(http.MethodPost, "/v2/tasks", strings.NewReader(
+				`{"sn":"S1","projectId":1,"category":"`+tc.category+`","problem":"no data"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			task := domain.Task{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+				t.Fatalf("Expected task in response, but error %v", err)
+			}
+			if tc.id != 0 && task.ID != tc.id {
+				t.Errorf("Expected task id %d, but got %d", tc.id, task.ID)
+			}
+		})
+	}
+}
+
+func TestAPINewTaskConcurrent(t *testing.T) {
+	sd := &fakeTaskRepo{}
+	info := &fakeCustomerRepo{configs: map[int64]domain.CustomerConfig{
+		1: {CustomerID: 1, SdServiceID: 10, SdCreatorID: 20},
+	}}
+	// replicas of the service have own taskLocks and share CM_INFO
+	replicas := make([]*echo.Echo, 2)
+	for i := range replicas {
+		s := &Server{log: zap.NewNop().Sugar(), infoRepo: info, sdRepo: sd}
+		replicas[i] = echo.New()
+		replicas[i].POST("/v2/tasks", s.apiNewTask)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(e *echo.Echo) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/v2/tasks",
+				strings.NewReader(`{"sn":"S1","projectId":1,"category":"offline","problem":"no data"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+	if len(sd.created) != 1 {
+		t.Errorf("Expected one created task, but got %d", len(sd.created))
+	}
+}
+
+func TestGetTaskFilter(t *testing.T) {
+	tCases := []struct {
+		title    string
+		query    string
+		statuses int
+		to       string
+		isErr    bool
+	}{
+		{title: "empty", query: ""},
+		{title: "statuses and dates", query: "status=31,%2032&service=10&from=2021-03-01&to=2021-03-31",
+			statuses: 2, to: "2021-03-31T23:59:59"},
+		{title: "rfc3339", query: "to=2021-03-31T10:00:00Z", to: "2021-03-31T10:00:00"},
+		{title: "wrong status", query: "status=open", isErr: true},
+		{title: "wrong date", query: "from=31.03.2021", isErr: true},
+		{title: "wrong range", query: "from=2021-03-31&to=2021-03-01", isErr: true},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			e := echo.New()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/tasks/controllers/S1?"+tc.query, nil),
+				httptest.NewRecorder())
+			filter, err := getTaskFilter(c)
+			if tc.isErr {
+				if err == nil {
+					t.Errorf("Expected error, but got %+v", filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected success getTaskFilter, but error %v", err)
+			}
+			if len(filter.StatusIDs) != tc.statuses {
+				t.Errorf("Expected %d statuses, but got %v", tc.statuses, filter.StatusIDs)
+			}
+			if tc.to != "" && (filter.CreatedTo == nil || filter.CreatedTo.Format("2006-01-02T15:04:05") != tc.to) {
+				t.Errorf("Expected to %s, but got %v", tc.to, filter.CreatedTo)
+			}
+		})
+	}
+}
+
+func (f *fakeTaskRepo) AttachTaskFile(_ context.Context, taskID int64, name, contentType string,
+	content io.Reader) (*domain.TaskFile, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.TaskFile{ID: 1, TaskID: taskID, Name: name, Size: int64(len(data)), ContentType: contentType}, nil
+}
+
+func TestAPITaskAttachFile(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+	exe := append([]byte("MZ\x90\x00"), bytes.Repeat([]byte{0}, 100)...)
+	mov := append([]byte("\x00\x00\x00\x14ftypqt  "), bytes.Repeat([]byte{0}, 100)...)
+	tCases := []struct {
+		title    string
+		field    string
+		content  []byte
+		declared string
+		code     int
+		ctype    string
+	}{
+		{title: "image", field: "file", content: png, code: http.StatusCreated, ctype: "image/png"},
+		{title: "text", field: "file", content: []byte("plain text"), code: http.StatusUnsupportedMediaType},
+		{title: "too large", field: "file", content: append(png, bytes.Repeat([]byte{0}, 1024)...),
+			code: http.StatusRequestEntityTooLarge},
+		{title: "missing file", field: "photo", content: png, code: http.StatusBadRequest},
+		{title: "executable declared as image", field: "file", content: exe, declared: "image/png",
+			code: http.StatusUnsupportedMediaType},
+		{title: "quicktime declared", field: "file", content: mov, declared: "video/quicktime",
+			code: http.StatusCreated, ctype: "video/quicktime"},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			audit, _ := repos.NewFileAudit(filepath.Join(t.TempDir(), "audit.jsonl"))
+			s := &Server{log: zap.NewNop().Sugar(), sdRepo: &fakeTaskRepo{}, sdFileMaxSize: 1000,
+				sdFileTypes: []string{"image/*", "video/*"}, audit: audit}
+			e := echo.New()
+			e.POST("/v2/tasks/:id/files", s.apiTaskAttachFile)
+			body := &bytes.Buffer{}
+			form := multipart.NewWriter(body)
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="photo.png"`, tc.field))
+			header.Set(echo.HeaderContentType, echo.MIMEOctetStream)
+			if tc.declared != "" {
+				header.Set(echo.HeaderContentType, tc.declared)
+			}
+			part, _ := form.CreatePart(header)
+			_, _ = part.Write(tc.content)
+			form.Close()
+			req := httptest.NewRequest(http.MethodPost, "/v2/tasks/5/files", body)
+			req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+			// unknown length, the limit is checked while streaming
+			req.ContentLength = -1
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			records, _ := audit.Find(context.Background(), domain.AuditFilter{Resource: "task/5"}, 10)
+			if created := rec.Code == http.StatusCreated; created != (len(records) == 1) || created &&
+				records[0].Action != domain.AuditAttach {
+				t.Errorf("Expected audit record of the attached file only, but got %+v", records)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			file := domain.TaskFile{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &file); err != nil {
+				t.Fatalf("Expected file in response, but error %v", err)
+			}
+			if file.TaskID != 5 || file.Size != int64(len(tc.content)) || file.ContentType != tc.ctype {
+				t.Errorf("Expected file of task 5 with %d bytes of %s, but got %+v", len(tc.content), tc.ctype, file)
+			}
+		})
+	}
+}
+
+func (f *fakeTaskRepo) FindDictionary(_ context.Context, name string) (domain.SDReferences, error) {
+	switch name {
+	case domain.SDStatuses:
+		return domain.SDReferences{{ID: 27, Name: "Open"}, {ID: 29, Name: "Closed"}}, nil
+	case domain.SDTaskFields:
+		return domain.SDReferences{{ID: 1101, Name: "Result", Key: "Field1101"}}, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeTaskRepo) FindTaskByID(_ context.Context, id int64) (*domain.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tasks {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTaskRepo) TaskAddComment(_ context.Context, _ string, comment string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.comments = append(f.comments, comment)
+	return nil
+}
+
+func (f *fakeTaskRepo) TaskSetStatus(_ context.Context, _ string, status domain.TaskStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses = append(f.statuses, status)
+	return nil
+}
+
+func TestAPITaskChangeStatus(t *testing.T) {
+	tCases := []struct {
+		title string
+		body  string
+		code  int
+		field string
+	}{
+		{title: "known status", body: `{"statusId":29}`, code: http.StatusOK},
+		{title: "field by name", body: `{"statusId":29,"resultFieldName":"result","resultFieldValue":"ok"}`,
+			code: http.StatusOK, field: "Field1101"},
+		{title: "field by key", body: `{"resultFieldName":"Field1101","resultFieldValue":"ok"}`,
+			code: http.StatusOK, field: "Field1101"},
+		{title: "unknown status", body: `{"statusId":35}`, code: http.StatusBadRequest},
+		{title: "unknown field", body: `{"statusId":29,"resultFieldName":"Field9"}`, code: http.StatusBadRequest},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			sd := &fakeTaskRepo{}
+			s := &Server{log: zap.NewNop().Sugar(), sdRepo: sd}
+			e := echo.New()
+			e.PUT("/v2/tasks/:id/status", s.apiTaskChangeStatus)
+			req := httptest.NewRequest(http.MethodPut, "/v2/tasks/7/status", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				if len(sd.statuses) != 0 {
+					t.Errorf("Expected no requests to Intraservice, but got %+v", sd.statuses)
+				}
+				return
+			}
+			if len(sd.statuses) != 1 || sd.statuses[0].ResultFieldName != tc.field {
+				t.Errorf("Expected status with field %q, but got %+v", tc.field, sd.statuses)
+			}
+		})
+	}
+}
@@ -1,9 +1,17 @@
 package infra
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
 
 	"git.countmax.ru/countmax/commonapi/domain"
 	"github.com/labstack/echo/v4"
@@ -13,6 +21,23 @@ var (
 	errVCConfigNotFound error = errors.New("videocheck configs not found")
 	errEmptyPID         error = errors.New("empty pid not allowed")
 	errEmptyPrjID       error = errors.New("empty projectId not allowed")
+	errVCConfigChanged  error = errors.New("videocheck config is changed, If-Match doesn't match current ETag")
+	errPatchProjectID   error = errors.New("projectId of the patch doesn't match pid")
+	errPatchType        error = errors.New("application/merge-patch+json or application/json body required")
+)
+
+// vcPatchMaxBody limit of the body of the merge patch
+const vcPatchMaxBody int64 = 1 << 20
+
+// vcAuditResource returns resource of the audit records of the videocheck config
+func vcAuditResource(pid int64) string {
+	return fmt.Sprintf("videocheck/%d", pid)
+}
+
+// forms of the videocheck options in responses, parameter optionsFormat
+const (
+	optionsFormatObject string = "object"
+	optionsFormatString string = "string"
 )
 
 // AssetsResponse http wrapper with metadata
@@ -21,13 +46,75 @@ type VideoCheckResponse struct {
 	Metadata
 }
 
+// legacyVideoCheckResponse response with options of the configurations as json string
+type legacyVideoCheckResponse struct {
+	Data []legacyVideocheckConfig `json:"data"`
+	Metadata
+}
+
+// legacyVideocheckConfig videocheck configuration with options as json string, as before typed options
+type legacyVideocheckConfig struct {
+	domain.VideocheckConfig
+	Options string `json:"options"`
+}
+
+// vcOptionsAsString returns true if options are returned as json string by optionsFormat parameter or config
+func (s *Server) vcOptionsAsString(c echo.Context) (bool, error) {
+	switch format := c.QueryParam("optionsFormat"); format {
+	case "":
+		return s.vcLegacyOptions, nil
+	case optionsFormatObject:
+		return false, nil
+	case optionsFormatString:
+		return true, nil
+	default:
+		return false, fmt.Errorf("wrong optionsFormat %s, allowed %s, %s", format,
+			optionsFormatObject, optionsFormatString)
+	}
+}
+
+// legacyVideocheckConfigs returns configurations with options as json string
+func legacyVideocheckConfigs(vcs domain.VideocheckConfigs) []legacyVideocheckConfig {
+	result := make([]legacyVideocheckConfig, len(vcs))
+	for i, vc := range vcs {
+		result[i] = legacyVideocheckConfig{VideocheckConfig: vc, Options: vc.Options.String()}
+	}
+	return result
+}
+
+// validateVCOptions returns error with all violations of VideocheckOptionsSchema
+func validateVCOptions(vc domain.VideocheckConfig) error {
+	if errs := vc.Options.Validate(); len(errs) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// apiVCOptionsSchema godoc
+// @Summary JSON Schema of the videocheck options
+// @Description schema of the options checked on insert and update of the videocheck configuration
+// @Produce json
+// @Tags cm_info
+// @Success 200 {object} object
+// @Router /v2/videochecks/configs/schema [get]
+func (s *Server) apiVCOptionsSchema(c echo.Context) error {
+	return c.Blob(http.StatusOK, "application/schema+json", []byte(domain.VideocheckOptionsSchema))
+}
+
 // apiCustomerVCConfigs godoc
 // @Summary Get all videocheck configurations
 // @Description get slice of customer videocheck configuration with offset, limit parameters
+// @Description next_cursor is returned for requests with filter, q, sort, after or count=false
 // @Produce  json
 // @Tags cm_info
 // @Param offset query integer false "default=0"
 // @Param limit query integer false "default=10"
+// @Param filter[field] query string false "filter[field][op]=value, op: eq (default),ne,gt,gte,lt,lte,like; fields: projectId,localServer,localCam,localFtp"
+// @Param q query string false "free-text search by options"
+// @Param sort query string false "comma separated fields, minus for descending order, example: -name,id"
+// @Param after query string false "opaque cursor next_cursor of the previous page, enables keyset paging instead of offset"
+// @Param count query bool false "default=true, false skips total count of rows (total=-1)"
+// @Param optionsFormat query string false "object or string (legacy), default from config"
 // @Success 200 {object} infra.VideoCheckResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 404 {object} infra.ErrResponse
@@ -35,8 +122,23 @@ type VideoCheckResponse struct {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/videochecks/configs [get]
 func (s *Server) apiCustomerVCConfigs(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	offset, limit := s.getPageParams(c)
-	vcs, count, err := s.infoRepo.FindVideoChechCfgs(offset, limit)
+	query, err := s.getListQuery(c, domain.VideocheckConfigListFields)
+	if err != nil {
+		log.Warnf("bad request apiCustomerVCConfigs, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	legacy, err := s.vcOptionsAsString(c)
+	if err != nil {
+		log.Warnf("bad request apiCustomerVCConfigs, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	if len(query.After) > 0 {
+		// keyset paging, page starts after the cursor
+		offset = 0
+	}
+	vcs, count, err := s.getInfoRepo().FindVideoChechCfgs(c.Request().Context(), query, offset, limit)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
@@ -47,13 +149,18 @@ func (s *Server) apiCustomerVCConfigs(c echo.Context) error {
 		Data: vcs,
 		Metadata: Metadata{
 			ResultSet: ResultSet{
-				Count:  int64(len(vcs)),
-				Offset: offset,
-				Limit:  limit,
-				Total:  count,
+				Count:      int64(len(vcs)),
+				Offset:     offset,
+				Limit:      limit,
+				Total:      count,
+				NextCursor: infoNextCursor(query, domain.VideocheckConfigListFields, vcs, limit),
 			},
 		},
 	}
+	if legacy {
+		return c.JSON(http.StatusOK, legacyVideoCheckResponse{Data: legacyVideocheckConfigs(vcs),
+			Metadata: response.Metadata})
+	}
 	return c.JSON(http.StatusOK, response)
 }
 
@@ -63,33 +170,109 @@ func (s *Server) apiCustomerVCConfigs(c echo.Context) error {
 // @Produce  json
 // @Tags cm_info
 // @Param pid path integer true "Code 1S"
-// @Success 200 {object} domain.CustomerConfig
+// @Param optionsFormat query string false "object or string (legacy), default from config"
+// @Success 200 {object} domain.VideocheckConfig
+// @Header 200 {string} ETag "version of the config for If-Match of PUT, PATCH, DELETE"
 // @Failure 400 {object} infra.HTTPError
 // @Failure 405 {object} infra.HTTPError
 // @Failure 404 {object} infra.ErrResponse
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/videochecks/configs/{pid} [get]
 func (s *Server) apiGetCustomerVCConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	pid := c.Param("pid")
 	if pid == "" {
-		s.log.Errorf("bad request apiGetCustomerVCConfigByID, %v", errEmptyPID)
+		log.Errorf("bad request apiGetCustomerVCConfigByID, %v", errEmptyPID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyPID))
 	}
-	vc, err := s.infoRepo.FindVideoCheckCfgByID(atoi64(pid))
+	legacy, err := s.vcOptionsAsString(c)
+	if err != nil {
+		log.Warnf("bad request apiGetCustomerVCConfigByID, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	vc, err := s.getInfoRepo().FindVideoCheckCfgByID(c.Request().Context(), atoi64(pid))
 	if err != nil {
-		s.log.Errorf("apiGetCustomerVCConfigByID, error %v", err)
+		log.Errorf("apiGetCustomerVCConfigByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
 	if vc == nil {
-		s.log.Warnf("apiGetCustomerVCConfigByID for id=%s not found", pid)
+		log.Warnf("apiGetCustomerVCConfigByID for id=%s not found", pid)
 		return c.JSON(http.StatusNotFound, ErrNotFound(errVCConfigNotFound))
 	}
+	return videocheckResponse(c, *vc, legacy)
+}
+
+// videocheckResponse responds with config and its ETag
+func videocheckResponse(c echo.Context, vc domain.VideocheckConfig, legacy bool) error {
+	c.Response().Header().Set("ETag", videocheckETag(vc))
+	if legacy {
+		return c.JSON(http.StatusOK, legacyVideocheckConfigs(domain.VideocheckConfigs{vc})[0])
+	}
 	return c.JSON(http.StatusOK, vc)
 }
 
+// videocheckETag returns strong ETag of the config, hash of its json
+func videocheckETag(vc domain.VideocheckConfig) string {
+	data, _ := json.Marshal(vc)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// matchETag returns true if If-Match header allows change of the resource with etag,
+// etag of the missing resource is empty, empty header allows any change
+func matchETag(header, etag string) bool {
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if (tag == "*" && etag != "") || (etag != "" && tag == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// changeVCConfig writes config returned by change (nil deletes it) for the current config (nil if not found)
+// read in the same transaction and checked by If-Match header, returns the current config
+func (s *Server) changeVCConfig(c echo.Context, pid int64,
+	change func(*domain.VideocheckConfig) (*domain.VideocheckConfig, error)) (*domain.VideocheckConfig, error) {
+	ifMatch := c.Request().Header.Get("If-Match")
+	var current *domain.VideocheckConfig
+	err := s.getInfoRepo().ChangeVideoCheckCfgs(c.Request().Context(), []int64{pid},
+		func(locked map[int64]*domain.VideocheckConfig) (map[int64]*domain.VideocheckConfig, error) {
+			current = locked[pid]
+			etag := ""
+			if current != nil {
+				etag = videocheckETag(*current)
+			}
+			if !matchETag(ifMatch, etag) {
+				return nil, errVCConfigChanged
+			}
+			vc, err := change(current)
+			if err != nil {
+				return nil, err
+			}
+			return map[int64]*domain.VideocheckConfig{pid: vc}, nil
+		})
+	return current, err
+}
+
+// vcErrorResponse responds with error of changeVCConfig
+func vcErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errVCConfigChanged):
+		return c.JSON(http.StatusPreconditionFailed, ErrPreconditionFailed(err))
+	case errors.Is(err, errVCConfigNotFound):
+		return c.JSON(http.StatusNotFound, ErrNotFound(err))
+	}
+	return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+}
+
 // apiNewCustomerVCConfigByID godoc
 // @Summary Inserts new customer's videocheck configuration
-// @Description inserts customer videocheck configuration
+// @Description inserts customer videocheck configuration, options are json object (or json string with it)
+// @Description checked by the schema /v2/videochecks/configs/schema, unknown keys are kept
 // @Security ApiKeyAuth
 // @Tags cm_info
 // @Accept json
@@ -103,61 +286,215 @@ func (s *Server) apiGetCustomerVCConfigByID(c echo.Context) error {
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/videochecks/configs [post]
 func (s *Server) apiNewCustomerVCConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	vchkcfg := domain.VideocheckConfig{}
 	if err := c.Bind(&vchkcfg); err != nil {
-		s.log.Errorf("apiNewCustomerVCConfigByID, bad request error %v", err)
+		log.Errorf("apiNewCustomerVCConfigByID, bad request error %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidRequest(err))
 	}
 	if vchkcfg.ProjectID == 0 {
-		s.log.Errorf("c.Bind incorrect parse payload, empty ProjectID, payload=%v", vchkcfg)
+		log.Errorf("c.Bind incorrect parse payload, empty ProjectID, payload=%v", vchkcfg)
 		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidRequest(errEmptyPrjID))
 	}
-	err := s.infoRepo.StoreVideoCheckCfg(vchkcfg)
+	if err := validateVCOptions(vchkcfg); err != nil {
+		log.Warnf("bad request apiNewCustomerVCConfigByID, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	err := s.getInfoRepo().StoreVideoCheckCfg(c.Request().Context(), vchkcfg)
 	if err != nil {
-		s.log.Errorf("apiNewCustomerVCConfigByID, error %v", err)
+		log.Errorf("apiNewCustomerVCConfigByID, error %v", err)
 		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
 	}
+	s.recordAudit(c, vcAuditResource(vchkcfg.ProjectID), domain.AuditCreate, nil, vchkcfg)
 	c.Response().Header().Set("Location", fmt.Sprintf("/v2/videochecks/configs/%d", vchkcfg.ProjectID))
 	return c.JSON(http.StatusCreated, CreatedStatus(fmt.Sprintf("%d", vchkcfg.ProjectID)))
 }
 
 // apiUpdCustomerVCConfigByID godoc
 // @Summary Update customer's videocheck configuration
-// @Description updates customer videocheck configuration
+// @Description updates customer videocheck configuration, options are json object (or json string with it)
+// @Description checked by the schema /v2/videochecks/configs/schema, unknown keys are kept
 // @Security ApiKeyAuth
 // @Tags cm_info
 // @Accept json
 // @Produce json
 // @Param pid path integer true "Code 1S"
+// @Param If-Match header string false "ETag of the config read by GET, 412 if config is changed"
 // @Param videocheckConfig body domain.VideocheckConfig true "New videocheck configuration"
 // @Success 200 {object} infra.SuccessResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
 // @Failure 405 {object} infra.HTTPError
 // @Failure 404 {object} infra.ErrResponse
+// @Failure 412 {object} infra.ErrResponse
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/videochecks/configs/{pid} [put]
 func (s *Server) apiUpdCustomerVCConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	vchkcfg := domain.VideocheckConfig{}
 	if err := c.Bind(&vchkcfg); err != nil {
-		s.log.Errorf("apiUpdCustomerVCConfigByID, bad request error %v", err)
+		log.Errorf("apiUpdCustomerVCConfigByID, bad request error %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, ErrInvalidRequest(err))
 	}
 	pid := c.Param("pid")
 	if pid == "" || vchkcfg.ProjectID == 0 {
-		s.log.Errorf("bad request apiUpdCustomerVCConfigByID, %v", errEmptyPID)
+		log.Errorf("bad request apiUpdCustomerVCConfigByID, %v", errEmptyPID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyPID))
 	}
 	vchkcfg.ProjectID = atoi64(pid)
-	err := s.infoRepo.UpSertVideoCheckCfg(vchkcfg)
+	if err := validateVCOptions(vchkcfg); err != nil {
+		log.Warnf("bad request apiUpdCustomerVCConfigByID, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	current, err := s.changeVCConfig(c, vchkcfg.ProjectID,
+		func(*domain.VideocheckConfig) (*domain.VideocheckConfig, error) {
+			return &vchkcfg, nil
+		})
 	if err != nil {
-		s.log.Errorf("apiUpdCustomerVCConfigByID, error %v", err)
-		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+		log.Errorf("apiUpdCustomerVCConfigByID for id=%s, error %v", pid, err)
+		return vcErrorResponse(c, err)
+	}
+	action := domain.AuditUpdate
+	if current == nil {
+		action = domain.AuditCreate
 	}
+	s.recordAudit(c, vcAuditResource(vchkcfg.ProjectID), action, current, vchkcfg)
 	c.Response().Header().Set("Location", fmt.Sprintf("/v2/videochecks/configs/%d", vchkcfg.ProjectID))
+	c.Response().Header().Set("ETag", videocheckETag(vchkcfg))
 	return c.JSON(http.StatusOK, OkStatus("updated"))
 }
 
+// apiPatchCustomerVCConfigByID godoc
+// @Summary Partial update of customer's videocheck configuration
+// @Description applies JSON Merge Patch (RFC 7396) to the videocheck configuration: only present fields
+// @Description are changed, null removes the key (false for flags), options are merged by keys
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Accept json
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param pid path integer true "Code 1S"
+// @Param If-Match header string false "ETag of the config read by GET, 412 if config is changed"
+// @Param optionsFormat query string false "object or string (legacy), default from config"
+// @Param videocheckConfig body object true "Changed fields of videocheck configuration"
+// @Success 200 {object} domain.VideocheckConfig
+// @Header 200 {string} ETag "version of the updated config"
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 404 {object} infra.ErrResponse
+// @Failure 412 {object} infra.ErrResponse
+// @Failure 415 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/videochecks/configs/{pid} [patch]
+func (s *Server) apiPatchCustomerVCConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	pid := atoi64(c.Param("pid"))
+	if pid == 0 {
+		log.Errorf("bad request apiPatchCustomerVCConfigByID, %v", errEmptyPID)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyPID))
+	}
+	legacy, err := s.vcOptionsAsString(c)
+	if err != nil {
+		log.Warnf("bad request apiPatchCustomerVCConfigByID, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != "application/merge-patch+json" && mediaType != echo.MIMEApplicationJSON {
+		log.Warnf("bad request apiPatchCustomerVCConfigByID, content type %s", mediaType)
+		return c.JSON(http.StatusUnsupportedMediaType, ErrResponse{Err: errPatchType,
+			HTTPStatusCode: http.StatusUnsupportedMediaType,
+			StatusText:     http.StatusText(http.StatusUnsupportedMediaType), ErrorText: errPatchType.Error()})
+	}
+	patch, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, vcPatchMaxBody))
+	if err != nil {
+		log.Errorf("apiPatchCustomerVCConfigByID, read body error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	var vc domain.VideocheckConfig
+	// badRequest error of the patch, it cancels the change
+	var badRequest error
+	current, err := s.changeVCConfig(c, pid,
+		func(current *domain.VideocheckConfig) (*domain.VideocheckConfig, error) {
+			if current == nil {
+				return nil, errVCConfigNotFound
+			}
+			vc, badRequest = patchVCConfig(*current, patch)
+			if badRequest == nil && vc.ProjectID != pid {
+				badRequest = errPatchProjectID
+			}
+			if badRequest == nil {
+				badRequest = validateVCOptions(vc)
+			}
+			if badRequest != nil {
+				return nil, badRequest
+			}
+			return &vc, nil
+		})
+	if badRequest != nil {
+		log.Warnf("bad request apiPatchCustomerVCConfigByID, %v", badRequest)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(badRequest))
+	}
+	if err != nil {
+		log.Errorf("apiPatchCustomerVCConfigByID for id=%d, error %v", pid, err)
+		return vcErrorResponse(c, err)
+	}
+	s.recordAudit(c, vcAuditResource(pid), domain.AuditUpdate, current, vc)
+	return videocheckResponse(c, vc, legacy)
+}
+
+// patchVCConfig applies JSON Merge Patch to the config
+func patchVCConfig(vc domain.VideocheckConfig, patch []byte) (domain.VideocheckConfig, error) {
+	data, err := json.Marshal(vc)
+	if err != nil {
+		return vc, err
+	}
+	var target, changes interface{}
+	if err = decodeJSONNumbers(data, &target); err != nil {
+		return vc, err
+	}
+	if err = decodeJSONNumbers(patch, &changes); err != nil {
+		return vc, fmt.Errorf("wrong merge patch, %v", err)
+	}
+	if _, ok := changes.(map[string]interface{}); !ok {
+		return vc, fmt.Errorf("merge patch must be json object")
+	}
+	if data, err = json.Marshal(mergePatch(target, changes)); err != nil {
+		return vc, err
+	}
+	result := domain.VideocheckConfig{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return vc, err
+	}
+	return result, nil
+}
+
+// decodeJSONNumbers decodes json keeping numbers as is
+func decodeJSONNumbers(data []byte, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(dst)
+}
+
+// mergePatch returns target changed by patch by RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	result, ok := target.(map[string]interface{})
+	if !ok {
+		result = make(map[string]interface{}, len(changes))
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = mergePatch(result[k], v)
+	}
+	return result
+}
+
 // apiDelCustomerVCConfigByID godoc
 // @Summary Delete customer's videocheck configuration
 // @Description delete customer videocheck configuration
@@ -166,27 +503,33 @@ func (s *Server) apiUpdCustomerVCConfigByID(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param pid path integer true "Code 1S"
+// @Param If-Match header string false "ETag of the config read by GET, 412 if config is changed"
 // @Success 200 {object} infra.SuccessResponse
 // @Failure 400 {object} infra.HTTPError
 // @Failure 401 {object} infra.HTTPError
 // @Failure 405 {object} infra.HTTPError
 // @Failure 404 {object} infra.ErrResponse
+// @Failure 412 {object} infra.ErrResponse
 // @Failure 500 {object} infra.ErrResponse
 // @Router /v2/videochecks/configs/{pid} [delete]
 func (s *Server) apiDelCustomerVCConfigByID(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
 	pid := c.Param("pid")
 	if pid == "" {
-		s.log.Errorf("bad request apiDelCustomerVCConfigByID, %v", errEmptyPID)
+		log.Errorf("bad request apiDelCustomerVCConfigByID, %v", errEmptyPID)
 		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errEmptyPID))
 	}
-	rowCount, err := s.infoRepo.DeleteVideoCheckCfgByID(atoi64(pid))
+	current, err := s.changeVCConfig(c, atoi64(pid),
+		func(current *domain.VideocheckConfig) (*domain.VideocheckConfig, error) {
+			if current == nil {
+				return nil, errVCConfigNotFound
+			}
+			return nil, nil
+		})
 	if err != nil {
-		s.log.Errorf("apiDelCustomerVCConfigByID, error %v", err)
-		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
-	}
-	if rowCount == 0 {
-		s.log.Warnf("apiDelCustomerVCConfigByID for id=%s not found", pid)
-		return c.JSON(http.StatusNotFound, ErrNotFound(errVCConfigNotFound))
+		log.Errorf("apiDelCustomerVCConfigByID for id=%s, error %v", pid, err)
+		return vcErrorResponse(c, err)
 	}
-	return c.JSON(http.StatusOK, OkStatus(fmt.Sprintf("delete %d records", rowCount)))
+	s.recordAudit(c, vcAuditResource(atoi64(pid)), domain.AuditDelete, current, nil)
+	return c.JSON(http.StatusOK, OkStatus("delete 1 records"))
 }
@@ -0,0 +1,447 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+)
+
+// formats and modes of the bulk export and import of the videocheck configs
+const (
+	vcFormatJSONL      string = "jsonl"
+	vcMIMEJSONL        string = "application/x-ndjson"
+	vcImportAtomic     string = "atomic"
+	vcImportBestEffort string = "best-effort"
+	vcImportMaxBody    int64  = 16 << 20
+	vcImportMaxRows    int    = 10000
+	vcImportProjectCSV string = "projectId"
+	vcImportOptionsCSV string = "options"
+	vcImportRowInvalid string = "invalid"
+	vcImportRowValid   string = "valid"
+	vcImportRowFailed  string = "failed"
+	vcImportRowSkipped string = "skipped"
+	vcImportRowApplied string = "applied"
+)
+
+// actions of the rows of the import
+const (
+	vcActionCreate    string = "create"
+	vcActionUpdate    string = "update"
+	vcActionUnchanged string = "unchanged"
+)
+
+var (
+	vcCSVHeader = []string{vcImportProjectCSV, "localServer", "localCam", "localFtp", vcImportOptionsCSV}
+
+	errVCImportMode    error = errors.New("wrong mode, allowed atomic, best-effort")
+	errVCImportType    error = errors.New("application/x-ndjson or text/csv body required")
+	errVCImportRows    error = fmt.Errorf("import is limited by %d rows", vcImportMaxRows)
+	errVCImportInvalid error = errors.New("import has invalid rows, nothing is changed")
+	errVCImportFailed  error = errors.New("import is failed, nothing is changed")
+	errVCDuplicate     error = errors.New("duplicate projectId in the import")
+)
+
+// VCImportRow result of the row of the import
+type VCImportRow struct {
+	// Row line of the json lines or record of the csv (without header) from 1
+	Row       int64  `json:"row"`
+	ProjectID int64  `json:"projectId,omitempty"`
+	Action    string `json:"action,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// VCImportResponse per-row results of the import and count of the rows by statuses
+type VCImportResponse struct {
+	Mode    string         `json:"mode"`
+	DryRun  bool           `json:"dryRun"`
+	Error   string         `json:"error,omitempty"`
+	Data    []VCImportRow  `json:"data"`
+	Summary map[string]int `json:"summary"`
+	Metadata
+}
+
+// vcImportItem parsed row of the import
+type vcImportItem struct {
+	result VCImportRow
+	config domain.VideocheckConfig
+	// previous version of the updated config for the audit
+	previous *domain.VideocheckConfig
+}
+
+// apiExportVCConfigs godoc
+// @Summary Export all videocheck configurations
+// @Description exports all videocheck configurations ordered by projectId as JSON Lines (default)
+// @Description or CSV (format=csv or Accept: text/csv), options of the csv are json text
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Tags cm_info
+// @Param format query string false "jsonl (default) or csv"
+// @Success 200 {object} domain.VideocheckConfig
+// @Failure 400 {object} infra.ErrResponse
+// @Failure 500 {object} infra.ErrResponse
+// @Router /v2/videochecks/configs/export [get]
+func (s *Server) apiExportVCConfigs(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	format := c.QueryParam("format")
+	if format == "" && strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv") {
+		format = reportFormatCSV
+	}
+	if format != "" && format != vcFormatJSONL && format != reportFormatCSV {
+		err := fmt.Errorf("wrong format %s, allowed %s, %s", format, vcFormatJSONL, reportFormatCSV)
+		log.Warnf("bad request apiExportVCConfigs, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	vcs, err := s.allVideocheckConfigs(c.Request().Context())
+	if err != nil {
+		log.Errorf("apiExportVCConfigs, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	w := c.Response()
+	if format == reportFormatCSV {
+		w.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		w.Header().Set(echo.HeaderContentDisposition, `attachment; filename="videocheck-configs.csv"`)
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(w)
+		_ = cw.Write(vcCSVHeader)
+		for _, vc := range vcs {
+			_ = cw.Write([]string{strconv.FormatInt(vc.ProjectID, 10), strconv.FormatBool(vc.LocalServer),
+				strconv.FormatBool(vc.LocalCam), strconv.FormatBool(vc.LocalFtp), vc.Options.String()})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	w.Header().Set(echo.HeaderContentType, vcMIMEJSONL)
+	w.Header().Set(echo.HeaderContentDisposition, `attachment; filename="videocheck-configs.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, vc := range vcs {
+		if err = enc.Encode(vc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allVideocheckConfigs reads all videocheck configs by keyset pages
+func (s *Server) allVideocheckConfigs(ctx context.Context) (domain.VideocheckConfigs, error) {
+	result := make(domain.VideocheckConfigs, 0)
+	q := domain.ListQuery{NoCount: true}
+	for {
+		page, _, err := s.getInfoRepo().FindVideoChechCfgs(ctx, q, 0, reportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+		if int64(len(page)) < reportPageSize {
+			return result, nil
+		}
+		q.After = []string{strconv.FormatInt(page[len(page)-1].ProjectID, 10)}
+	}
+}
+
+// apiImportVCConfigs godoc
+// @Summary Import videocheck configurations
+// @Description creates or updates videocheck configurations of the JSON Lines or CSV body (as export),
+// @Description every row is validated as POST. Mode atomic (default) writes all rows in one CM_INFO transaction,
+// @Description nothing is changed if any row is invalid or writing fails, best-effort applies valid rows only.
+// @Description dryRun=true validates rows and returns actions without changes
+// @Security ApiKeyAuth
+// @Tags cm_info
+// @Accept application/x-ndjson
+// @Accept text/csv
+// @Produce json
+// @Param mode query string false "atomic (default) or best-effort"
+// @Param dryRun query bool false "default=false, validate only"
+// @Success 200 {object} infra.VCImportResponse
+// @Failure 400 {object} infra.VCImportResponse
+// @Failure 401 {object} infra.HTTPError
+// @Failure 415 {object} infra.ErrResponse
+// @Failure 500 {object} infra.VCImportResponse
+// @Router /v2/videochecks/configs/import [post]
+func (s *Server) apiImportVCConfigs(c echo.Context) error {
+	log := domain.RequestLogger(c.Request().Context(), s.log)
+	mode := c.QueryParam("mode")
+	if mode == "" {
+		mode = vcImportAtomic
+	}
+	if mode != vcImportAtomic && mode != vcImportBestEffort {
+		log.Warnf("bad request apiImportVCConfigs, mode=%s", mode)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(errVCImportMode))
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, vcImportMaxBody+1))
+	if err == nil && int64(len(body)) > vcImportMaxBody {
+		err = fmt.Errorf("import is limited by %d bytes", vcImportMaxBody)
+	}
+	if err != nil {
+		log.Errorf("apiImportVCConfigs, read body error %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	var items []*vcImportItem
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case vcMIMEJSONL, "application/jsonl", "application/x-jsonlines":
+		items, err = parseVCJSONLines(body)
+	case "text/csv":
+		items, err = parseVCCSV(body)
+	default:
+		log.Warnf("bad request apiImportVCConfigs, content type %s", mediaType)
+		return c.JSON(http.StatusUnsupportedMediaType, ErrResponse{Err: errVCImportType,
+			HTTPStatusCode: http.StatusUnsupportedMediaType,
+			StatusText:     http.StatusText(http.StatusUnsupportedMediaType), ErrorText: errVCImportType.Error()})
+	}
+	if err != nil {
+		log.Warnf("bad request apiImportVCConfigs, %v", err)
+		return c.JSON(http.StatusBadRequest, ErrInvalidRequest(err))
+	}
+	ctx := c.Request().Context()
+	existing, err := s.allVideocheckConfigs(ctx)
+	if err != nil {
+		log.Errorf("apiImportVCConfigs, error %v", err)
+		return c.JSON(http.StatusInternalServerError, ErrServerInternal(err))
+	}
+	current := make(map[int64]*domain.VideocheckConfig, len(existing))
+	for i := range existing {
+		current[existing[i].ProjectID] = &existing[i]
+	}
+	invalid := planVCImport(items, current)
+	response := VCImportResponse{Mode: mode, DryRun: dryRun, Summary: make(map[string]int)}
+	code := http.StatusOK
+	switch {
+	case invalid && mode == vcImportAtomic:
+		response.Error, code = errVCImportInvalid.Error(), http.StatusBadRequest
+		markVCImport(items, vcImportRowSkipped)
+	case dryRun:
+		markVCImport(items, vcImportRowValid)
+	case mode == vcImportAtomic:
+		if err = s.applyVCImportAtomic(ctx, items); err != nil {
+			log.Errorf("apiImportVCConfigs, error %v", err)
+			response.Error, code = fmt.Sprintf("%v, %v", errVCImportFailed, err), http.StatusInternalServerError
+		}
+	default:
+		s.applyVCImport(ctx, items)
+	}
+	for _, item := range items {
+		if item.result.Status == vcImportRowApplied && item.result.Action != vcActionUnchanged {
+			s.recordAudit(c, vcAuditResource(item.config.ProjectID), item.result.Action, item.previous, item.config)
+		}
+	}
+	response.Data = make([]VCImportRow, len(items))
+	for i, item := range items {
+		response.Data[i] = item.result
+		response.Summary[item.result.Status]++
+	}
+	count := int64(len(items))
+	response.Metadata = Metadata{ResultSet: ResultSet{Count: count, Limit: count, Total: count}}
+	return c.JSON(code, response)
+}
+
+// planVCImport validates rows and sets their actions by current configs, returns true if any row is invalid
+func planVCImport(items []*vcImportItem, current map[int64]*domain.VideocheckConfig) bool {
+	seen := make(map[int64]int64, len(items))
+	invalid := false
+	for _, item := range items {
+		if item.result.Status == vcImportRowInvalid {
+			invalid = true
+			continue
+		}
+		var err error
+		switch row, ok := seen[item.config.ProjectID]; {
+		case item.config.ProjectID <= 0:
+			err = errEmptyPrjID
+		case ok:
+			err = fmt.Errorf("%v, row %d", errVCDuplicate, row)
+		default:
+			err = validateVCOptions(item.config)
+		}
+		seen[item.config.ProjectID] = item.result.Row
+		if err != nil {
+			item.result.Status, item.result.Error, invalid = vcImportRowInvalid, err.Error(), true
+			continue
+		}
+		planVCImportRow(item, current[item.config.ProjectID])
+	}
+	return invalid
+}
+
+// planVCImportRow sets action of the valid row by the current config (nil if not found),
+// returns config to write or nil if the row doesn't change the config
+func planVCImportRow(item *vcImportItem, current *domain.VideocheckConfig) *domain.VideocheckConfig {
+	item.previous = current
+	switch {
+	case current == nil:
+		item.result.Action = vcActionCreate
+	case videocheckETag(*current) == videocheckETag(item.config):
+		item.result.Action = vcActionUnchanged
+		return nil
+	default:
+		item.result.Action = vcActionUpdate
+	}
+	vc := item.config
+	return &vc
+}
+
+// markVCImport sets status of the valid rows, which aren't applied
+func markVCImport(items []*vcImportItem, status string) {
+	for _, item := range items {
+		if item.result.Status == "" {
+			item.result.Status = status
+		}
+	}
+}
+
+// applyVCImportAtomic writes valid rows in one transaction, actions are planned again by the locked configs
+func (s *Server) applyVCImportAtomic(ctx context.Context, items []*vcImportItem) error {
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		if item.result.Status == "" {
+			ids = append(ids, item.config.ProjectID)
+		}
+	}
+	err := s.getInfoRepo().ChangeVideoCheckCfgs(ctx, ids,
+		func(current map[int64]*domain.VideocheckConfig) (map[int64]*domain.VideocheckConfig, error) {
+			changes := make(map[int64]*domain.VideocheckConfig, len(ids))
+			for _, item := range items {
+				if item.result.Status != "" {
+					continue
+				}
+				if vc := planVCImportRow(item, current[item.config.ProjectID]); vc != nil {
+					changes[vc.ProjectID] = vc
+				}
+			}
+			return changes, nil
+		})
+	if err != nil {
+		markVCImport(items, vcImportRowSkipped)
+		return err
+	}
+	markVCImport(items, vcImportRowApplied)
+	return nil
+}
+
+// applyVCImport writes valid rows in the transaction by row, failed rows don't stop the import
+func (s *Server) applyVCImport(ctx context.Context, items []*vcImportItem) {
+	log := domain.RequestLogger(ctx, s.log)
+	for _, item := range items {
+		if item.result.Status != "" {
+			continue
+		}
+		pid := item.config.ProjectID
+		err := s.getInfoRepo().ChangeVideoCheckCfgs(ctx, []int64{pid},
+			func(current map[int64]*domain.VideocheckConfig) (map[int64]*domain.VideocheckConfig, error) {
+				vc := planVCImportRow(item, current[pid])
+				if vc == nil {
+					return nil, nil
+				}
+				return map[int64]*domain.VideocheckConfig{pid: vc}, nil
+			})
+		if err != nil {
+			log.Errorf("apiImportVCConfigs, row %d projectId=%d error %v", item.result.Row, pid, err)
+			item.result.Status, item.result.Error = vcImportRowFailed, err.Error()
+			continue
+		}
+		item.result.Status = vcImportRowApplied
+	}
+}
+
+// parseVCJSONLines returns rows of the json lines, empty lines are skipped, wrong rows are invalid
+func parseVCJSONLines(body []byte) ([]*vcImportItem, error) {
+	items := make([]*vcImportItem, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64<<10), int(vcImportMaxBody))
+	var line int64
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(items) == vcImportMaxRows {
+			return nil, errVCImportRows
+		}
+		item := &vcImportItem{result: VCImportRow{Row: line}}
+		if err := json.Unmarshal(text, &item.config); err != nil {
+			item.result.Status, item.result.Error = vcImportRowInvalid, err.Error()
+		}
+		item.result.ProjectID = item.config.ProjectID
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// parseVCCSV returns rows of the csv with header, columns are found by names of the header
+func parseVCCSV(body []byte) ([]*vcImportItem, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header required, %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := columns[vcImportProjectCSV]; !ok {
+		return nil, fmt.Errorf("csv column %s required", vcImportProjectCSV)
+	}
+	items := make([]*vcImportItem, 0)
+	var row int64
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row++
+		if len(items) == vcImportMaxRows {
+			return nil, errVCImportRows
+		}
+		item := &vcImportItem{result: VCImportRow{Row: row}}
+		if err = parseVCRecord(record, columns, &item.config); err != nil {
+			item.result.Status, item.result.Error = vcImportRowInvalid, err.Error()
+		}
+		item.result.ProjectID = item.config.ProjectID
+		items = append(items, item)
+	}
+}
+
+// parseVCRecord fills config by the csv record, empty flags are false
+func parseVCRecord(record []string, columns map[string]int, vc *domain.VideocheckConfig) error {
+	value := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	id, err := strconv.ParseInt(value(vcImportProjectCSV), 10, 64)
+	if err != nil {
+		return fmt.Errorf("wrong %s %s", vcImportProjectCSV, value(vcImportProjectCSV))
+	}
+	vc.ProjectID = id
+	flags := map[string]*bool{"localServer": &vc.LocalServer, "localCam": &vc.LocalCam, "localFtp": &vc.LocalFtp}
+	for name, dst := range flags {
+		if raw := value(name); raw != "" {
+			if *dst, err = strconv.ParseBool(raw); err != nil {
+				return fmt.Errorf("wrong %s %s", name, raw)
+			}
+		}
+	}
+	vc.Options = domain.ParseVideocheckOptions(value(vcImportOptionsCSV))
+	return nil
+}
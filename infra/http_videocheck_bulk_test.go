@@ -0,0 +1,131 @@
+package infra
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// newVCBulkRepo configs of the projects 1 and 2
+func newVCBulkRepo() *fakeVideocheckRepo {
+	repo := newFakeVideocheckRepo()
+	repo.configs[1] = domain.VideocheckConfig{ProjectID: 1, LocalServer: true}
+	repo.options[1] = `{"ftpMask":"##_##","unknown":1}`
+	repo.configs[2] = domain.VideocheckConfig{ProjectID: 2, LocalFtp: true}
+	return repo
+}
+
+func TestAPIExportVCConfigs(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar(), infoRepo: newVCBulkRepo()}
+	e := echo.New()
+	e.GET("/v2/videochecks/configs/export", s.apiExportVCConfigs)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/export", nil))
+	expected := `{"projectId":1,"localServer":true,"localCam":false,"localFtp":false,` +
+		`"options":{"ftpMask":"##_##","unknown":1}}` + "\n" +
+		`{"projectId":2,"localServer":false,"localCam":false,"localFtp":true,"options":{}}` + "\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != expected {
+		t.Fatalf("Expected json lines %s, but got %d %s", expected, rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/export?format=csv", nil))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected csv, but error %v", err)
+	}
+	if len(records) != 3 || !reflect.DeepEqual(records[1],
+		[]string{"1", "true", "false", "false", `{"ftpMask":"##_##","unknown":1}`}) {
+		t.Errorf("Expected header and 2 configs, but got %v", records)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/export?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected code=400 of wrong format, but got %d", rec.Code)
+	}
+}
+
+func TestAPIImportVCConfigs(t *testing.T) {
+	jsonl := `{"projectId":1,"localServer":true,"options":{"ftpMask":"##_##","unknown":1}}` + "\n\n" +
+		`{"projectId":2,"localCam":true}` + "\n" +
+		`{"projectId":3,"options":"{\"merged_code1c\":\"7\"}"}` + "\n"
+	tCases := []struct {
+		title       string
+		query       string
+		contentType string
+		body        string
+		fail        int64
+		code        int
+		statuses    []string
+		actions     []string
+		updated     bool
+		created     bool
+	}{
+		{title: "atomic", body: jsonl, code: http.StatusOK, updated: true, created: true,
+			statuses: []string{"applied", "applied", "applied"}, actions: []string{"unchanged", "update", "create"}},
+		{title: "dry run", query: "?dryRun=true", body: jsonl, code: http.StatusOK,
+			statuses: []string{"valid", "valid", "valid"}, actions: []string{"unchanged", "update", "create"}},
+		{title: "csv", contentType: "text/csv", code: http.StatusOK, updated: true, created: true,
+			body:     "projectId,localCam,options\n2,true,\n3,,\"{\"\"merged_code1c\"\":\"\"7\"\"}\"\n",
+			statuses: []string{"applied", "applied"}, actions: []string{"update", "create"}},
+		{title: "atomic invalid", body: jsonl + `{"projectId":2}` + "\n" + `{"options":{}}` + "\n",
+			code: http.StatusBadRequest, statuses: []string{"skipped", "skipped", "skipped", "invalid", "invalid"}},
+		{title: "best effort invalid", query: "?mode=best-effort", code: http.StatusOK,
+			body:     `{"projectId":2,"localCam":true}` + "\n" + `{"projectId":4,"options":{"ftpMask":"a b"}}` + "\n",
+			statuses: []string{"applied", "invalid"}, updated: true},
+		{title: "atomic failed", body: jsonl, fail: 3, code: http.StatusInternalServerError,
+			statuses: []string{"skipped", "skipped", "skipped"}},
+		{title: "best effort failed", query: "?mode=best-effort", body: jsonl, fail: 3, code: http.StatusOK,
+			statuses: []string{"applied", "applied", "failed"}, updated: true},
+		{title: "wrong mode", query: "?mode=all", body: jsonl, code: http.StatusBadRequest},
+		{title: "content type", contentType: echo.MIMEApplicationJSON, body: jsonl,
+			code: http.StatusUnsupportedMediaType},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := newVCBulkRepo()
+			repo.fail = tc.fail
+			s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo}
+			e := echo.New()
+			e.POST("/v2/videochecks/configs/import", s.apiImportVCConfigs)
+			req := httptest.NewRequest(http.MethodPost, "/v2/videochecks/configs/import"+tc.query,
+				strings.NewReader(tc.body))
+			if tc.contentType == "" {
+				tc.contentType = vcMIMEJSONL
+			}
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.statuses == nil {
+				return
+			}
+			resp := VCImportResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected import results, but error %v", err)
+			}
+			statuses, actions := make([]string, 0), make([]string, 0)
+			for _, row := range resp.Data {
+				statuses, actions = append(statuses, row.Status), append(actions, row.Action)
+			}
+			if !reflect.DeepEqual(statuses, tc.statuses) {
+				t.Errorf("Expected statuses %v, but got %v", tc.statuses, statuses)
+			}
+			if tc.actions != nil && !reflect.DeepEqual(actions, tc.actions) {
+				t.Errorf("Expected actions %v, but got %v", tc.actions, actions)
+			}
+			if _, created := repo.configs[3]; created != tc.created || repo.configs[2].LocalCam != tc.updated {
+				t.Errorf("Expected updated=%v, created=%v, but got %+v", tc.updated, tc.created, repo.configs)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"git.countmax.ru/countmax/commonapi/repos"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// fakeVideocheckRepo videocheck configs in memory, options are kept as text like in CM_INFO
+type fakeVideocheckRepo struct {
+	domain.CustomerRepo
+	configs map[int64]domain.VideocheckConfig
+	options map[int64]string
+	// fail projectId which writing fails
+	fail int64
+}
+
+func newFakeVideocheckRepo() *fakeVideocheckRepo {
+	return &fakeVideocheckRepo{configs: map[int64]domain.VideocheckConfig{}, options: map[int64]string{}}
+}
+
+func (f *fakeVideocheckRepo) FindVideoChechCfgs(_ context.Context, _ domain.ListQuery, _,
+	_ int64) (domain.VideocheckConfigs, int64, error) {
+	result := make(domain.VideocheckConfigs, 0, len(f.configs))
+	for id := range f.configs {
+		vc, _ := f.FindVideoCheckCfgByID(context.Background(), id)
+		result = append(result, *vc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ProjectID < result[j].ProjectID })
+	return result, int64(len(result)), nil
+}
+
+func (f *fakeVideocheckRepo) FindVideoCheckCfgByID(_ context.Context, id int64) (*domain.VideocheckConfig, error) {
+	vc, ok := f.configs[id]
+	if !ok {
+		return nil, nil
+	}
+	vc.Options = domain.ParseVideocheckOptions(f.options[id])
+	return &vc, nil
+}
+
+func (f *fakeVideocheckRepo) StoreVideoCheckCfg(ctx context.Context, vc domain.VideocheckConfig) error {
+	return f.UpSertVideoCheckCfg(ctx, vc)
+}
+
+func (f *fakeVideocheckRepo) UpSertVideoCheckCfg(_ context.Context, vc domain.VideocheckConfig) error {
+	if f.fail != 0 && vc.ProjectID == f.fail {
+		return errors.New("write error")
+	}
+	f.options[vc.ProjectID] = vc.Options.String()
+	vc.Options = domain.VideocheckOptions{}
+	f.configs[vc.ProjectID] = vc
+	return nil
+}
+
+func (f *fakeVideocheckRepo) DeleteVideoCheckCfgByID(_ context.Context, id int64) (int64, error) {
+	if _, ok := f.configs[id]; !ok {
+		return 0, nil
+	}
+	delete(f.configs, id)
+	delete(f.options, id)
+	return 1, nil
+}
+
+// ChangeVideoCheckCfgs writes nothing if change or any write fails
+func (f *fakeVideocheckRepo) ChangeVideoCheckCfgs(ctx context.Context, ids []int64,
+	change domain.VideocheckChange) error {
+	current := make(map[int64]*domain.VideocheckConfig, len(ids))
+	for _, id := range ids {
+		if vc, _ := f.FindVideoCheckCfgByID(ctx, id); vc != nil {
+			current[id] = vc
+		}
+	}
+	changes, err := change(current)
+	if err != nil {
+		return err
+	}
+	if _, ok := changes[f.fail]; ok && f.fail != 0 {
+		return errors.New("write error")
+	}
+	for id, vc := range changes {
+		if vc == nil {
+			_, _ = f.DeleteVideoCheckCfgByID(ctx, id)
+			continue
+		}
+		_ = f.UpSertVideoCheckCfg(ctx, *vc)
+	}
+	return nil
+}
+
+func TestAPINewCustomerVCConfigOptions(t *testing.T) {
+	tCases := []struct {
+		title   string
+		body    string
+		code    int
+		stored  string
+		message string
+	}{
+		{title: "object", code: http.StatusCreated,
+			body:   `{"projectId":1,"options":{"ftpMask":"##_##","ftpProxyUpload":true,"custom":[1,2]}}`,
+			stored: `{"custom":[1,2],"ftpMask":"##_##","ftpProxyUpload":true}`},
+		{title: "legacy string", code: http.StatusCreated,
+			body:   `{"projectId":1,"options":"{\"trac_comment\":\"\",\"merged_code1c\":\"42\"}"}`,
+			stored: `{"merged_code1c":"42","trac_comment":""}`},
+		{title: "empty", code: http.StatusCreated, body: `{"projectId":1,"options":""}`, stored: ""},
+		{title: "wrong type", code: http.StatusBadRequest, message: "options.ftpProxyUpload must be boolean",
+			body: `{"projectId":1,"options":{"ftpProxyUpload":"yes"}}`},
+		{title: "wrong type in string", code: http.StatusBadRequest, message: "options.ftpMask must be string",
+			body: `{"projectId":1,"options":"{\"ftpMask\":1}"}`},
+		{title: "not object", code: http.StatusBadRequest, message: "options must be json object",
+			body: `{"projectId":1,"options":"ftp"}`},
+		{title: "pattern", code: http.StatusBadRequest, message: "options.merged_code1c must contain digits only",
+			body: `{"projectId":1,"options":{"merged_code1c":"12a"}}`},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := newFakeVideocheckRepo()
+			s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo}
+			e := echo.New()
+			e.POST("/v2/videochecks/configs", s.apiNewCustomerVCConfigByID)
+			req := httptest.NewRequest(http.MethodPost, "/v2/videochecks/configs", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.message != "" && !strings.Contains(rec.Body.String(), tc.message) {
+				t.Errorf("Expected error %q, but got %s", tc.message, rec.Body.String())
+			}
+			if tc.code == http.StatusCreated && repo.options[1] != tc.stored {
+				t.Errorf("Expected stored options %s, but got %s", tc.stored, repo.options[1])
+			}
+		})
+	}
+}
+
+func TestAPIGetCustomerVCConfigOptions(t *testing.T) {
+	stored := `{"ftpMask":"##_##","unknown":{"a":1}}`
+	tCases := []struct {
+		title   string
+		query   string
+		legacy  bool
+		code    int
+		options string
+	}{
+		{title: "object", code: http.StatusOK, options: stored},
+		{title: "legacy config", legacy: true, code: http.StatusOK, options: `"{\"ftpMask\":\"##_##\",` +
+			`\"unknown\":{\"a\":1}}"`},
+		{title: "legacy parameter", query: "?optionsFormat=string", code: http.StatusOK,
+			options: `"{\"ftpMask\":\"##_##\",\"unknown\":{\"a\":1}}"`},
+		{title: "object parameter", query: "?optionsFormat=object", legacy: true, code: http.StatusOK,
+			options: stored},
+		{title: "wrong format", query: "?optionsFormat=xml", code: http.StatusBadRequest},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := newFakeVideocheckRepo()
+			repo.configs[7] = domain.VideocheckConfig{ProjectID: 7, LocalFtp: true}
+			repo.options[7] = stored
+			s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo, vcLegacyOptions: tc.legacy}
+			e := echo.New()
+			e.GET("/v2/videochecks/configs/:pid", s.apiGetCustomerVCConfigByID)
+			e.GET("/v2/videochecks/configs", s.apiCustomerVCConfigs)
+			for _, path := range []string{"/v2/videochecks/configs/7", "/v2/videochecks/configs"} {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+tc.query, nil))
+				if rec.Code != tc.code {
+					t.Fatalf("Expected code=%d of %s, but got %d, body=%s", tc.code, path, rec.Code, rec.Body.String())
+				}
+				if rec.Code != http.StatusOK {
+					continue
+				}
+				var vc struct {
+					Options json.RawMessage `json:"options"`
+					Data    []struct {
+						Options json.RawMessage `json:"options"`
+					} `json:"data"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &vc); err != nil {
+					t.Fatalf("Expected config in response, but error %v", err)
+				}
+				if len(vc.Data) > 0 {
+					vc.Options = vc.Data[0].Options
+				}
+				if string(vc.Options) != tc.options {
+					t.Errorf("Expected options %s of %s, but got %s", tc.options, path, vc.Options)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIVCOptionsSchema(t *testing.T) {
+	s := &Server{log: zap.NewNop().Sugar()}
+	e := echo.New()
+	e.GET("/v2/videochecks/configs/schema", s.apiVCOptionsSchema)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/schema", nil))
+	schema := struct {
+		Properties map[string]struct {
+			Type string `json:"type"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("Expected json schema, but error %v", err)
+	}
+	if len(schema.Properties) != 4 || schema.Properties["ftpProxyUpload"].Type != "boolean" {
+		t.Errorf("Expected 4 options in schema, but got %+v", schema.Properties)
+	}
+}
+
+func TestAPIPatchCustomerVCConfig(t *testing.T) {
+	tCases := []struct {
+		title       string
+		body        string
+		contentType string
+		code        int
+		expected    domain.VideocheckConfig
+		stored      string
+	}{
+		{title: "flag", body: `{"localCam":true}`, code: http.StatusOK,
+			expected: domain.VideocheckConfig{ProjectID: 7, LocalServer: true, LocalCam: true},
+			stored:   `{"ftpMask":"##_##","unknown":{"a":1}}`},
+		{title: "merge options", body: `{"options":{"ftpMask":null,"merged_code1c":"42","unknown":{"b":2}}}`,
+			contentType: "application/merge-patch+json", code: http.StatusOK,
+			expected: domain.VideocheckConfig{ProjectID: 7, LocalServer: true},
+			stored:   `{"merged_code1c":"42","unknown":{"a":1,"b":2}}`},
+		{title: "remove", body: `{"localServer":null,"options":null}`, code: http.StatusOK,
+			expected: domain.VideocheckConfig{ProjectID: 7}, stored: ""},
+		{title: "invalid options", body: `{"options":{"ftpMask":"a b"}}`, code: http.StatusBadRequest},
+		{title: "wrong type", body: `{"localCam":"yes"}`, code: http.StatusBadRequest},
+		{title: "other project", body: `{"projectId":8}`, code: http.StatusBadRequest},
+		{title: "not object", body: `[1]`, code: http.StatusBadRequest},
+		{title: "content type", body: `{}`, contentType: "text/plain", code: http.StatusUnsupportedMediaType},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			repo := newFakeVideocheckRepo()
+			repo.configs[7] = domain.VideocheckConfig{ProjectID: 7, LocalServer: true}
+			repo.options[7] = `{"ftpMask":"##_##","unknown":{"a":1}}`
+			s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo}
+			e := echo.New()
+			e.PATCH("/v2/videochecks/configs/:pid", s.apiPatchCustomerVCConfigByID)
+			req := httptest.NewRequest(http.MethodPatch, "/v2/videochecks/configs/7", strings.NewReader(tc.body))
+			if tc.contentType == "" {
+				tc.contentType = echo.MIMEApplicationJSON
+			}
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected code=%d, but got %d, body=%s", tc.code, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			stored := repo.configs[7]
+			if !reflect.DeepEqual(stored, tc.expected) || repo.options[7] != tc.stored {
+				t.Errorf("Expected %+v with options %s, but got %+v with %s", tc.expected, tc.stored,
+					stored, repo.options[7])
+			}
+			current, _ := repo.FindVideoCheckCfgByID(context.Background(), 7)
+			if etag := rec.Header().Get("ETag"); etag != videocheckETag(*current) {
+				t.Errorf("Expected ETag of the stored config %s, but got %s", videocheckETag(*current), etag)
+			}
+		})
+	}
+}
+
+func TestVCConfigIfMatch(t *testing.T) {
+	repo := newFakeVideocheckRepo()
+	repo.configs[7] = domain.VideocheckConfig{ProjectID: 7}
+	s := &Server{log: zap.NewNop().Sugar(), infoRepo: repo}
+	e := echo.New()
+	e.GET("/v2/videochecks/configs/:pid", s.apiGetCustomerVCConfigByID)
+	e.PUT("/v2/videochecks/configs/:pid", s.apiUpdCustomerVCConfigByID)
+	e.PATCH("/v2/videochecks/configs/:pid", s.apiPatchCustomerVCConfigByID)
+	e.DELETE("/v2/videochecks/configs/:pid", s.apiDelCustomerVCConfigByID)
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v2/videochecks/configs/7", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	etag := do(http.MethodGet, "", "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected ETag of GET, but got empty")
+	}
+	// first operator changes the config, second one has stale version
+	rec := do(http.MethodPatch, `{"localFtp":true}`, etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("Expected new ETag after PATCH, but got %d %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	fresh := rec.Header().Get("ETag")
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		if rec = do(method, `{"projectId":7,"localCam":true}`, etag); rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected code=412 of %s with stale ETag, but got %d", method, rec.Code)
+		}
+	}
+	if !repo.configs[7].LocalFtp || repo.configs[7].LocalCam {
+		t.Errorf("Expected config isn't changed by stale requests, but got %+v", repo.configs[7])
+	}
+	if rec = do(http.MethodPut, `{"projectId":7,"localCam":true}`, "\"other\", "+fresh); rec.Code != http.StatusOK {
+		t.Errorf("Expected code=200 of PUT with current ETag, but got %d, body=%s", rec.Code, rec.Body.String())
+	}
+	if rec = do(http.MethodDelete, "", "*"); rec.Code != http.StatusOK {
+		t.Errorf("Expected code=200 of DELETE with If-Match *, but got %d", rec.Code)
+	}
+	if rec = do(http.MethodPut, `{"projectId":7}`, "*"); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected code=412 of PUT of deleted config with If-Match *, but got %d", rec.Code)
+	}
+}
+
+func TestVCConfigIfMatchCached(t *testing.T) {
+	repo := newFakeVideocheckRepo()
+	repo.configs[7] = domain.VideocheckConfig{ProjectID: 7}
+	mem, _ := repos.NewMemoryCache(10)
+	cached := repos.NewCachedCustomerRepo(repo, mem, repos.NewCacheTTL(map[string]int{"default": 60}), "test",
+		zap.NewNop().Sugar())
+	s := &Server{log: zap.NewNop().Sugar(), infoRepo: cached}
+	e := echo.New()
+	e.GET("/v2/videochecks/configs/:pid", s.apiGetCustomerVCConfigByID)
+	e.PUT("/v2/videochecks/configs/:pid", s.apiUpdCustomerVCConfigByID)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/7", nil))
+	// other replica changes the config, GET returns ETag of the changed config
+	repo.configs[7] = domain.VideocheckConfig{ProjectID: 7, LocalFtp: true}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/videochecks/configs/7", nil))
+	etag := rec.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodPut, "/v2/videochecks/configs/7", strings.NewReader(`{"projectId":7}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("If-Match", etag)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || repo.configs[7].LocalFtp {
+		t.Errorf("Expected code=200 of PUT with ETag of the fresh GET, but got %d, %+v", rec.Code, repo.configs[7])
+	}
+}
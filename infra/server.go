@@ -8,6 +8,7 @@ package infra
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -33,12 +34,12 @@ import (
 )
 
 const (
-	requestIDName                   = "request_id"
 	periodHealthCheck time.Duration = 30 * time.Second
 	repoINFO          string        = "cminfo"
 	repoISDB          string        = "intraservice_db"
 	repoISAPI         string        = "intraservice_api"
 	repoRef           string        = "reference"
+	repoLayout        string        = "layout"
 	repoToken         string        = "keycloak"
 )
 
@@ -55,14 +56,50 @@ type Server struct {
 	mAPI      *prometheus.SummaryVec
 	mCache    *prometheus.GaugeVec
 	assetRepo domain.AssetRepo
+	// assetFields names and types of the asset custom fields
+	assetFields domain.AssetFields
+	// assetFeed subscribers of the stream of the asset changes
+	assetFeed assetFeed
 	infoRepo  domain.CustomerRepo
-	sdRepo    domain.SDRepo
-	refRepo   domain.RefRepo
-	chCancel  <-chan struct{}
-	fnCancel  context.CancelFunc
-	token     string
-	mu        *sync.Mutex
+	// vcLegacyOptions default form of the videocheck options in responses is json string (legacy)
+	vcLegacyOptions bool
+	// audit log of the changes, nil if it's disabled
+	audit      domain.AuditRepo
+	sdRepo     domain.SDRepo
+	refRepo    domain.RefRepo
+	layoutRepo domain.LayoutRepo
+	deviceRepo domain.DeviceRepo
+	cache      repos.Cache
+	cacheTTL   repos.CacheTTL
+	cacheNS    string
+	chCancel   <-chan struct{}
+	fnCancel   context.CancelFunc
+	token      string
+	// mu guards repositories connected by reconnectors and their readiness
+	mu        sync.RWMutex
 	repoReady map[string]bool
+	deps      []dependency
+	// health last report of the healthChecker
+	healthMu sync.RWMutex
+	health   HealthResponse
+	// keycloak token validation
+	keys             *keySet
+	allowStaticToken bool
+	tokenIssuer      string
+	tokenAZP         []string
+	tokenAudience    string
+	// sdDestinationField id of the Intraservice task field for sdDestination of the customer config
+	sdDestinationField string
+	// sdFileMaxSize, sdFileTypes limits of the files attached to the tasks
+	sdFileMaxSize int64
+	sdFileTypes   []string
+	// hooks notifications of the Intraservice and delivery of the events to the subscribers
+	hooks hooks
+	// sdOpenStatuses ids of the statuses of the open tasks, empty - tasks without closing date are open
+	sdOpenStatuses []int64
+	// taskLocks serializes creation of the tasks with the same controller and category in this process,
+	// replicas are serialized by the lock in CM_INFO
+	taskLocks keyLocks
 }
 
 // NewServer builder main document server
@@ -77,7 +114,6 @@ func NewServer(ctx context.Context, version, build, githash string) *Server {
 		mCache:    redis,
 		chCancel:  ctxembed.Done(),
 		fnCancel:  cancel,
-		mu:        &sync.Mutex{},
 		repoReady: make(map[string]bool),
 	}
 	// fill repo not ready
@@ -85,29 +121,37 @@ func NewServer(ctx context.Context, version, build, githash string) *Server {
 	s.setRepoState(repoISDB, false)
 	s.setRepoState(repoISAPI, false)
 	s.setRepoState(repoRef, false)
+	s.setRepoState(repoLayout, false)
 	s.setRepoState(repoToken, false)
 	// fill config
 	s.setConfig()
 
 	s.setLogger(version, build, githash)
+	s.registerDependencies()
 	// fill token
 	s.token = s.config.GetString("httpd.hardcode_token")
+	s.allowStaticToken = s.config.GetBool("httpd.allow_hardcode_token")
+	s.setTokenValidation()
+	s.setCache()
 	// init asset repo
+	if err := s.config.UnmarshalKey("intraservice.asset_fields", &s.assetFields); err != nil {
+		s.log.Errorf("intraservice.asset_fields config error, %v", err)
+	}
 	timeout := s.config.GetDuration("intraservice.sqltimeout_sec") * time.Second
-	assetRepo, err := repos.NewAssetSQLRepo(s.config.GetString("intraservice.dsn"), timeout)
+	assetRepo, err := repos.NewAssetSQLRepo(s.config.GetString("intraservice.dsn"), timeout, s.assetFields)
 	if err != nil {
 		s.log.Errorf("connection to Intraservice DB error, %v", err)
 		s.mService.WithLabelValues("intraserviceDB",
 			getSrvPortDB(s.config.GetString("intraservice.dsn")), s.version, s.githash, s.build).Set(0)
 		go s.assetReconnector(timeout, s.chCancel)
 	} else {
-		s.setRepoState(repoISDB, true)
 		s.mService.WithLabelValues("intraserviceDB",
 			getSrvPortDB(s.config.GetString("intraservice.dsn")), s.version, s.githash, s.build).Set(1)
-		s.assetRepo = assetRepo
+		s.setAssetRepo(s.cachedAssetRepo(assetRepo))
 	}
 
 	timeoutInfo := s.config.GetDuration("cminfo.sqltimeout_sec") * time.Second
+	s.vcLegacyOptions = s.config.GetBool("cminfo.videocheck_options_as_string")
 	infoRepo, err := repos.NewCustomersRepo(s.config.GetString("cminfo.dsn"), timeoutInfo)
 	if err != nil {
 		s.log.Errorf("connection to CM_INFO DB error, %v", err)
@@ -115,10 +159,9 @@ func NewServer(ctx context.Context, version, build, githash string) *Server {
 			getSrvPortDB(s.config.GetString("cminfo.dsn")), s.version, s.githash, s.build).Set(0)
 		go s.infoReconnector(timeoutInfo, s.chCancel)
 	} else {
-		s.setRepoState(repoINFO, true)
 		s.mService.WithLabelValues("CM_INFO",
 			getSrvPortDB(s.config.GetString("cminfo.dsn")), s.version, s.githash, s.build).Set(1)
-		s.infoRepo = infoRepo
+		s.setInfoRepo(s.cachedInfoRepo(infoRepo))
 	}
 
 	timeoutRef := s.config.GetDuration("ref.sqltimeout_sec") * time.Second
@@ -129,27 +172,81 @@ func NewServer(ctx context.Context, version, build, githash string) *Server {
 			getSrvPortDB(s.config.GetString("ref.dsn")), s.version, s.githash, s.build).Set(0)
 		go s.refReconnector(timeoutRef, s.chCancel)
 	} else {
-		s.setRepoState(repoRef, true)
 		s.mService.WithLabelValues("evolution",
 			getSrvPortDB(s.config.GetString("ref.dsn")), s.version, s.githash, s.build).Set(1)
-		s.refRepo = refRepo
+		s.setRefRepo(s.cachedRefRepo(refRepo))
 	}
 
+	timeoutLayout := s.config.GetDuration("layout.sqltimeout_sec") * time.Second
+	layoutRepo, err := repos.NewLayoutRepo(s.config.GetString("layout.dsn"), timeoutLayout)
+	if err != nil {
+		s.log.Errorf("connection to layout DB error, %v", err)
+		s.mService.WithLabelValues("layout",
+			getSrvPortDB(s.config.GetString("layout.dsn")), s.version, s.githash, s.build).Set(0)
+		go s.layoutReconnector(timeoutLayout, s.chCancel)
+	} else {
+		s.mService.WithLabelValues("layout",
+			getSrvPortDB(s.config.GetString("layout.dsn")), s.version, s.githash, s.build).Set(1)
+		s.setLayoutRepo(layoutRepo)
+	}
+
+	timeoutDevice := s.config.GetDuration("devices.sqltimeout_sec") * time.Second
+	s.deviceRepo = repos.NewDeviceSQLRepo(timeoutDevice,
+		s.config.GetDuration("devices.online_threshold_min")*time.Minute)
+
 	timeoutSD := s.config.GetDuration("intraservice.httptimeout_sec") * time.Second
 	sdRepo := repos.NewISAPI(s.config.GetString("intraservice.url"),
 		s.config.GetString("intraservice.user"), s.config.GetString("intraservice.pass"), timeoutSD, s.log)
 	s.setRepoState(repoISAPI, true)
-	s.sdRepo = sdRepo
+	s.sdRepo = s.cachedSDRepo(sdRepo)
+	s.sdDestinationField = s.config.GetString("intraservice.destination_field")
+	for _, id := range s.config.GetIntSlice("intraservice.open_statuses") {
+		s.sdOpenStatuses = append(s.sdOpenStatuses, int64(id))
+	}
+	s.sdFileMaxSize = s.config.GetInt64("intraservice.files.max_size_mb") << 20
+	s.sdFileTypes = s.config.GetStringSlice("intraservice.files.types")
+
+	s.setHooks()
+	s.setAudit()
+	go s.assetChangesPoller(s.config.GetDuration("intraservice.changes_poll_sec")*time.Second, s.chCancel)
 
 	// start healthChecker
 	go s.healthChecker(periodHealthCheck, s.chCancel)
 	return s
 }
 
+// setTokenValidation fill parameters of keycloak token validation and loads key set
+func (s *Server) setTokenValidation() {
+	s.tokenIssuer = s.config.GetString("keycloak.issuer")
+	s.tokenAZP = s.config.GetStringSlice("keycloak.allowed_azp")
+	s.tokenAudience = s.config.GetString("keycloak.audience")
+	jwksFile := s.config.GetString("keycloak.jwks_file")
+	if jwksFile == "" && s.tokenIssuer == "" {
+		s.log.Warnf("keycloak jwks_file and issuer are empty, token validation disabled")
+		return
+	}
+	timeout := s.config.GetDuration("keycloak.httptimeout_sec") * time.Second
+	if timeout <= 0 {
+		timeout = periodHealthCheck
+	}
+	s.keys = newKeySet(jwksFile, s.tokenIssuer, timeout)
+	err := s.keys.load()
+	if err != nil {
+		s.log.Errorf("load keycloak jwks error, %v", err)
+	} else {
+		s.setRepoState(repoToken, true)
+	}
+	period := s.config.GetDuration("keycloak.jwks_refresh_sec") * time.Second
+	if period <= 0 {
+		period = periodHealthCheck
+	}
+	go s.jwksRefresher(period, s.chCancel)
+}
+
 func (s *Server) isReadyRepo(name string) bool {
-	s.mu.Lock()
+	s.mu.RLock()
 	ready, ok := s.repoReady[name]
-	s.mu.Unlock()
+	s.mu.RUnlock()
 	if !ok {
 		ready = false
 	}
@@ -173,13 +270,17 @@ func (s *Server) assetReconnector(period time.Duration, cancel <-chan struct{})
 			tick.Stop()
 			return
 		case <-tick.C:
-			assetRepo, err := repos.NewAssetSQLRepo(s.config.GetString("intraservice.dsn"), period)
+			assetRepo, err := repos.NewAssetSQLRepo(s.config.GetString("intraservice.dsn"), period, s.assetFields)
 			if err != nil {
 				s.log.Errorf("connection to Intraservice (%s) DB error, %v, wait %v and repeat",
 					shadowConnString(s.config.GetString("intraservice.dsn")), err, period)
 				continue
 			}
-			s.assetRepo = assetRepo
+			s.setAssetRepo(s.cachedAssetRepo(assetRepo))
+			s.mService.WithLabelValues("intraserviceDB",
+				getSrvPortDB(s.config.GetString("intraservice.dsn")), s.version, s.githash, s.build).Set(1)
+			s.log.Infof("connection to Intraservice (%s) DB restored, repository is ready",
+				shadowConnString(s.config.GetString("intraservice.dsn")))
 			tick.Stop()
 			return
 		}
@@ -203,7 +304,11 @@ func (s *Server) infoReconnector(period time.Duration, cancel <-chan struct{}) {
 					shadowConnString(s.config.GetString("cminfo.dsn")), err, period)
 				continue
 			}
-			s.infoRepo = infoRepo
+			s.setInfoRepo(s.cachedInfoRepo(infoRepo))
+			s.mService.WithLabelValues("CM_INFO",
+				getSrvPortDB(s.config.GetString("cminfo.dsn")), s.version, s.githash, s.build).Set(1)
+			s.log.Infof("connection to CM_INFO (%s) DB restored, repository is ready",
+				shadowConnString(s.config.GetString("cminfo.dsn")))
 			tick.Stop()
 			return
 		}
@@ -227,7 +332,39 @@ func (s *Server) refReconnector(period time.Duration, cancel <-chan struct{}) {
 					shadowConnString(s.config.GetString("ref.dsn")), err, period)
 				continue
 			}
-			s.refRepo = refRepo
+			s.setRefRepo(s.cachedRefRepo(refRepo))
+			s.mService.WithLabelValues("evolution",
+				getSrvPortDB(s.config.GetString("ref.dsn")), s.version, s.githash, s.build).Set(1)
+			s.log.Infof("connection to evolution (%s) DB restored, repository is ready",
+				shadowConnString(s.config.GetString("ref.dsn")))
+			tick.Stop()
+			return
+		}
+	}
+}
+
+// layoutReconnector...
+func (s *Server) layoutReconnector(period time.Duration, cancel <-chan struct{}) {
+	s.log.Debugf("starting layoutReconnector")
+	defer s.log.Debugf("stopped layoutReconnector")
+	tick := time.NewTicker(period)
+	for {
+		select {
+		case <-cancel:
+			tick.Stop()
+			return
+		case <-tick.C:
+			layoutRepo, err := repos.NewLayoutRepo(s.config.GetString("layout.dsn"), period)
+			if err != nil {
+				s.log.Errorf("connection to layout (%s) DB error, %v, wait %v and repeat",
+					shadowConnString(s.config.GetString("layout.dsn")), err, period)
+				continue
+			}
+			s.setLayoutRepo(layoutRepo)
+			s.mService.WithLabelValues("layout",
+				getSrvPortDB(s.config.GetString("layout.dsn")), s.version, s.githash, s.build).Set(1)
+			s.log.Infof("connection to layout (%s) DB restored, repository is ready",
+				shadowConnString(s.config.GetString("layout.dsn")))
 			tick.Stop()
 			return
 		}
@@ -240,6 +377,8 @@ func (s *Server) Run() {
 	s.fnCancel = cancel
 	s.chCancel = ctx.Done()
 	s.registerRoutes()
+	// requests are canceled with the server, so Stop interrupts queries of the repositories
+	s.mux.Server.BaseContext = func(net.Listener) context.Context { return ctx }
 	port := s.config.GetString("httpd.port")
 	host := s.config.GetString("httpd.host") + ":" + port
 	s.log.Infof("http server starting on the [%s] tcp port", host)
@@ -270,52 +409,91 @@ func (s *Server) registerRoutes() {
 	e.HideBanner = true // hide banner ECHO
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
+	e.Use(s.requestContext)
 	e.Use(s.customHTTPLogger)
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowCredentials: true,
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowOrigins:     s.config.GetStringSlice("httpd.allow_origins"),
+		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+			http.MethodHead},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization,
+			"If-Match"},
+		// ETag of the videocheck config is read by browsers for If-Match of the change
+		ExposeHeaders: []string{"ETag"},
+		AllowOrigins:  s.config.GetStringSlice("httpd.allow_origins"),
 	}))
 	e.GET("/", s.redirectToSwag)
 	// metric handler
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 	e.GET("/health", s.apiHealthCheck)
+	e.GET("/health/live", s.apiHealthLive)
+	e.GET("/health/ready", s.apiHealthReady)
+	e.GET("/health/details", s.apiHealthDetails)
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 	// group for report implementation
 
 	// secure area
 	auth := e.Group("/v2")
-	auth.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
-		return key == s.token, nil
-	}))
-	auth.GET("/assets/:id", s.apiAssetByID)
-	auth.GET("/assets", s.apiAssets)
-	auth.GET("/customers/:id/configs", s.apiCustomerConfigByID)
-	auth.GET("/customers/configs", s.apiCustomerConfigs)
-
-	auth.GET("/projects/:id", s.apiProjectByID)
-	auth.GET("/projects/:id/ftpinfo", s.apiProjectFTPByID)
-	auth.GET("/projects/:id/controllers/:cid/manualcnts", s.apiProjectMC)
-	auth.GET("/projects", s.apiProjects)
-	e.GET("/v2/videochecks/configs/:pid", s.apiGetCustomerVCConfigByID)
-	auth.PUT("/videochecks/configs/:pid", s.apiUpdCustomerVCConfigByID)
-	auth.DELETE("/videochecks/configs/:pid", s.apiDelCustomerVCConfigByID)
-	e.GET("/v2/videochecks/configs", s.apiCustomerVCConfigs)
-	auth.POST("/videochecks/configs", s.apiNewCustomerVCConfigByID)
+	auth.Use(middleware.KeyAuth(s.validateToken))
+	auth.GET("/assets/tree", s.apiAssetTree, s.needRepos(repoISDB))
+	auth.GET("/assets/changes", s.apiAssetChanges, s.needRepos(repoISDB))
+	auth.GET("/assets/changes/stream", s.apiAssetChangesStream, s.needRepos(repoISDB))
+	auth.GET("/assets/:id/children", s.apiAssetChildren, s.needRepos(repoISDB))
+	auth.GET("/assets/:id/ancestors", s.apiAssetAncestors, s.needRepos(repoISDB))
+	auth.GET("/assets/:id", s.apiAssetByID, s.needRepos(repoISDB))
+	auth.GET("/assets", s.apiAssets, s.needRepos(repoISDB))
+	auth.GET("/customers/:id/configs", s.apiCustomerConfigByID, s.needRepos(repoINFO))
+	auth.POST("/customers/:id/configs", s.apiNewCustomerConfig, s.needRepos(repoINFO, repoISAPI))
+	auth.PUT("/customers/:id/configs", s.apiUpdCustomerConfig, s.needRepos(repoINFO, repoISAPI))
+	auth.PATCH("/customers/:id/configs", s.apiPatchCustomerConfig, s.needRepos(repoINFO, repoISAPI))
+	auth.DELETE("/customers/:id/configs", s.apiDelCustomerConfig, s.needRepos(repoINFO))
+	auth.GET("/customers/configs", s.apiCustomerConfigs, s.needRepos(repoINFO))
+
+	auth.GET("/projects/:id", s.apiProjectByID, s.needRepos(repoINFO))
+	auth.GET("/projects/:id/ftpinfo", s.apiProjectFTPByID, s.needRepos(repoINFO))
+	auth.GET("/projects/:id/controllers/:cid/manualcnts", s.apiProjectMC, s.needRepos(repoINFO))
+	auth.GET("/projects", s.apiProjects, s.needRepos(repoINFO))
+
+	// reports
+	auth.GET("/audit", s.apiAudit)
+	auth.GET("/reports/asset-project-mismatches", s.apiAssetProjectMismatches, s.needRepos(repoISDB, repoINFO))
+	e.GET("/v2/videochecks/configs/schema", s.apiVCOptionsSchema)
+	e.GET("/v2/videochecks/configs/export", s.apiExportVCConfigs, s.needRepos(repoINFO))
+	auth.POST("/videochecks/configs/import", s.apiImportVCConfigs, s.needRepos(repoINFO))
+	e.GET("/v2/videochecks/configs/:pid", s.apiGetCustomerVCConfigByID, s.needRepos(repoINFO))
+	auth.PUT("/videochecks/configs/:pid", s.apiUpdCustomerVCConfigByID, s.needRepos(repoINFO))
+	auth.PATCH("/videochecks/configs/:pid", s.apiPatchCustomerVCConfigByID, s.needRepos(repoINFO))
+	auth.DELETE("/videochecks/configs/:pid", s.apiDelCustomerVCConfigByID, s.needRepos(repoINFO))
+	e.GET("/v2/videochecks/configs", s.apiCustomerVCConfigs, s.needRepos(repoINFO))
+	auth.POST("/videochecks/configs", s.apiNewCustomerVCConfigByID, s.needRepos(repoINFO))
 
 	// Intraservice API
-	auth.GET("/tasks/controllers/:sn", s.apiTasksByControllerSN)
-	auth.PUT("/tasks/:id/comment", s.apiTaskAddComment)
-	auth.PUT("/tasks/:id/status", s.apiTaskChangeStatus)
+	auth.POST("/tasks", s.apiNewTask, s.needRepos(repoINFO, repoISAPI))
+	auth.GET("/tasks/controllers/:sn", s.apiTasksByControllerSN, s.needRepos(repoISAPI))
+	auth.GET("/tasks/statuses", s.apiSDStatuses, s.needRepos(repoISAPI))
+	auth.GET("/tasks/priorities", s.apiSDPriorities, s.needRepos(repoISAPI))
+	auth.GET("/tasks/services", s.apiSDServices, s.needRepos(repoISAPI))
+	auth.GET("/tasks/types", s.apiSDTaskTypes, s.needRepos(repoISAPI))
+	auth.GET("/tasks/fields", s.apiSDTaskFields, s.needRepos(repoISAPI))
+	auth.GET("/tasks/:id", s.apiTaskByID, s.needRepos(repoISAPI))
+	auth.GET("/tasks/:id/lifetime", s.apiTaskLifetime, s.needRepos(repoISAPI))
+	auth.GET("/tasks/:id/files", s.apiTaskFiles, s.needRepos(repoISAPI))
+	auth.GET("/tasks/:id/files/:fid", s.apiTaskFileContent, s.needRepos(repoISAPI))
+	auth.POST("/tasks/:id/files", s.apiTaskAttachFile, s.needRepos(repoISAPI))
+	auth.PUT("/tasks/:id/comment", s.apiTaskAddComment, s.needRepos(repoISAPI))
+	auth.PUT("/tasks/:id/status", s.apiTaskChangeStatus, s.needRepos(repoISAPI))
+
+	// notifications of the Intraservice are verified by signature or shared secret of the webhook
+	e.POST("/v2/hooks/intraservice", s.apiIntraserviceHook)
 
 	// evolution
-	e.GET("/v2/entities", s.apiEntities)
-	e.GET("/v2/entities/:id", s.apiEntityByID)
+	e.GET("/v2/entities", s.apiEntities, s.needRepos(repoRef))
+	e.GET("/v2/entities/:id", s.apiEntityByID, s.needRepos(repoRef))
 
 	// keycloak token validation
-	// auth.GET("/v2/layouts", s.apiLayouts)
-	// auth.GET("/v2/devices", s.apiDevices)
+	auth.GET("/layouts", s.apiLayouts, s.needRepos(repoLayout))
+	auth.GET("/layouts/:id", s.apiLayoutByID, s.needRepos(repoLayout))
+	auth.GET("/devices", s.apiDevices, s.needRepos(repoINFO))
+	auth.GET("/devices/:sn", s.apiDeviceBySN, s.needRepos(repoINFO))
 
 	// pprof
 	dbg := e.Group("/debug")
@@ -326,6 +504,9 @@ func (s *Server) registerRoutes() {
 func (s *Server) healthChecker(period time.Duration, cancel <-chan struct{}) {
 	s.log.Infof("starting healthChecker with scheduller %v", period)
 	defer s.log.Info("stopped healthChecker")
+	if err := s.healthCheck(); err != nil {
+		s.log.Errorf("healthCheck error %s", err)
+	}
 	tick := time.NewTicker(period)
 	for {
 		select {
@@ -346,85 +527,21 @@ func (s *Server) healthCheck() error {
 	s.log.Infof("starting healthCheck")
 	defer s.log.Infof("stopped healthCheck")
 	var resErr error
-	genHealth := true
-
-	// intraserviceDB
-	if s.isReadyRepo(repoISDB) {
-		err := s.assetRepo.Health()
-		if err != nil {
-			genHealth = false
-			resErr = err
-			s.log.With(zap.String("repository",
-				getSrvPortDB(s.config.GetString("intraservice.dsn")))).Errorf("healthCheck error, %v", err)
-			s.mService.WithLabelValues("intraserviceDB",
-				getSrvPortDB(s.config.GetString("intraservice.dsn")), s.version, s.githash, s.build).Set(0)
-		} else {
-
-			s.mService.WithLabelValues("intraserviceDB",
-				getSrvPortDB(s.config.GetString("intraservice.dsn")), s.version, s.githash, s.build).Set(1)
-		}
-	} else {
-		genHealth = false
-	}
-
-	// CM_INFO
-	if s.isReadyRepo(repoINFO) {
-		err := s.infoRepo.Health()
-		if err != nil {
-			genHealth = false
-			resErr = err
-			s.log.With(zap.String("repository",
-				getSrvPortDB(s.config.GetString("cminfo.dsn")))).Errorf("healthCheck error, %v", err)
-			s.mService.WithLabelValues("CM_INFO",
-				getSrvPortDB(s.config.GetString("cminfo.dsn")), s.version, s.githash, s.build).Set(0)
-		} else {
-			s.mService.WithLabelValues("CM_INFO",
-				getSrvPortDB(s.config.GetString("cminfo.dsn")), s.version, s.githash, s.build).Set(1)
-		}
-	} else {
-		genHealth = false
-	}
 
-	// intraserviceDB
-	if s.isReadyRepo(repoRef) {
-		err := s.refRepo.Health()
-		if err != nil {
-			genHealth = false
-			resErr = err
-			s.log.With(zap.String("repository",
-				getSrvPortDB(s.config.GetString("ref.dsn")))).Errorf("healthCheck error, %v", err)
-			s.mService.WithLabelValues("evolution",
-				getSrvPortDB(s.config.GetString("ref.dsn")), s.version, s.githash, s.build).Set(0)
-		} else {
-
-			s.mService.WithLabelValues("evolution",
-				getSrvPortDB(s.config.GetString("ref.dsn")), s.version, s.githash, s.build).Set(1)
+	// repositories
+	report := s.checkDependencies(s.getHealth())
+	s.setHealth(report)
+	for name, state := range report.Dependencies {
+		if state.Status != healthOK {
+			resErr = fmt.Errorf("%s %s: %s", name, state.Status, state.Error)
 		}
-	} else {
-		genHealth = false
 	}
 
-	// intraserviceAPI
-	if s.isReadyRepo(repoISAPI) {
-		err := s.sdRepo.Health()
-		if err != nil {
-			genHealth = false
-			resErr = err
-			s.log.With(zap.String("repository",
-				s.config.GetString("intraservice.url"))).Errorf("healthCheck error, %v", err)
-			s.mService.WithLabelValues("IntraserviceAPI",
-				s.config.GetString("intraservice.url"),
-				s.version, s.githash, s.build).Set(0)
-		} else {
-			s.mService.WithLabelValues("IntraserviceAPI",
-				s.config.GetString("intraservice.url"), s.version, s.githash, s.build).Set(1)
-		}
-	} else {
-		genHealth = false
-	}
+	// cache, unavailable cache doesn't affect general state, repositories work without it
+	s.cacheHealthCheck()
 
-	// general state
-	if genHealth {
+	// general state, degraded service is ready
+	if report.Ready {
 		s.mService.WithLabelValues("general", "localhost", s.version, s.githash, s.build).Set(1)
 	} else {
 		s.mService.WithLabelValues("general", "localhost", s.version, s.githash, s.build).Set(0)
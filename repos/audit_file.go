@@ -0,0 +1,124 @@
+package repos
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// auditMaxLine limit of the line of the audit file
+const auditMaxLine int = 16 << 20
+
+// FileAudit implementation of domain.AuditRepo in the append-only file of json lines,
+// records are searched by reading the file without the lock of the writer up to its size at the start
+type FileAudit struct {
+	mu     sync.Mutex
+	path   string
+	lastID int64
+	// size of the file with written records only
+	size int64
+}
+
+// NewFileAudit returns audit log of the file, the file is created on first record
+func NewFileAudit(path string) (*FileAudit, error) {
+	fa := &FileAudit{path: path}
+	err := fa.scan(-1, func(r domain.AuditRecord) bool {
+		if r.ID > fa.lastID {
+			fa.lastID = r.ID
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		fa.size = info.Size()
+	}
+	return fa, nil
+}
+
+// Append implementation of AuditRepo interface, the record is synced to the disk
+func (fa *FileAudit) Append(_ context.Context, r *domain.AuditRecord) error {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	r.ID = fa.lastID + 1
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fa.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open audit file %s error, %v", fa.path, err)
+	}
+	n, err := f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("write audit file %s error, %v", fa.path, err)
+	}
+	fa.lastID = r.ID
+	fa.size += int64(n)
+	return nil
+}
+
+// Find implementation of AuditRepo interface
+func (fa *FileAudit) Find(ctx context.Context, filter domain.AuditFilter, limit int64) ([]domain.AuditRecord, error) {
+	fa.mu.Lock()
+	size := fa.size
+	fa.mu.Unlock()
+	result := make([]domain.AuditRecord, 0)
+	err := fa.scan(size, func(r domain.AuditRecord) bool {
+		if filter.Match(r) {
+			result = append(result, r)
+		}
+		return int64(len(result)) < limit && ctx.Err() == nil
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+// scan reads records of the first size bytes of the file (whole file if size < 0) until next returns false,
+// missing file has no records
+func (fa *FileAudit) scan(size int64, next func(domain.AuditRecord) bool) error {
+	f, err := os.Open(fa.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open audit file %s error, %v", fa.path, err)
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if size >= 0 {
+		in = io.LimitReader(f, size)
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64<<10), auditMaxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := domain.AuditRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("decode audit file %s line %d error, %v", fa.path, line, err)
+		}
+		if !next(r) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,80 @@
+package repos
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+func TestFileAudit(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	fa, err := NewFileAudit(path)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	now := time.Now().UTC()
+	records := []domain.AuditRecord{
+		{Changed: now.Add(-time.Hour), Actor: "a", Resource: "videocheck/1", Action: domain.AuditCreate},
+		{Changed: now, Actor: "b", Resource: "videocheck/12", Action: domain.AuditUpdate,
+			Before: []byte(`{"localCam":false}`), After: []byte(`{"localCam":true}`)},
+		{Changed: now, Actor: "a", Resource: "task/1", Action: domain.AuditComment},
+	}
+	for i := range records {
+		if err = fa.Append(ctx, &records[i]); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+	}
+	// ids continue after reopening of the file
+	if fa, err = NewFileAudit(path); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	next := domain.AuditRecord{Changed: now, Actor: "c", Resource: "videochecks", Action: domain.AuditDelete}
+	if err = fa.Append(ctx, &next); err != nil || next.ID != 4 {
+		t.Fatalf("Expected record 4, but got %d, error %v", next.ID, err)
+	}
+	tCases := []struct {
+		title  string
+		filter domain.AuditFilter
+		limit  int64
+		ids    []int64
+	}{
+		{title: "all", limit: 10, ids: []int64{1, 2, 3, 4}},
+		{title: "resource kind", filter: domain.AuditFilter{Resource: "videocheck"}, limit: 10, ids: []int64{1, 2}},
+		{title: "resource", filter: domain.AuditFilter{Resource: "videocheck/1"}, limit: 10, ids: []int64{1}},
+		{title: "actor", filter: domain.AuditFilter{Actor: "a"}, limit: 10, ids: []int64{1, 3}},
+		{title: "since", filter: domain.AuditFilter{Since: now.Add(-time.Minute)}, limit: 10, ids: []int64{2, 3, 4}},
+		{title: "page", filter: domain.AuditFilter{AfterID: 1}, limit: 2, ids: []int64{2, 3}},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			found, err := fa.Find(ctx, tc.filter, tc.limit)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if len(found) != len(tc.ids) {
+				t.Fatalf("Expected records %v, but got %+v", tc.ids, found)
+			}
+			for i, r := range found {
+				if r.ID != tc.ids[i] {
+					t.Errorf("Expected record %d at %d, but got %d", tc.ids[i], i, r.ID)
+				}
+			}
+		})
+	}
+	found, _ := fa.Find(ctx, domain.AuditFilter{Resource: "videocheck/12"}, 1)
+	if len(found) != 1 || string(found[0].After) != `{"localCam":true}` {
+		t.Errorf("Expected record with state after the change, but got %+v", found)
+	}
+	// record being written isn't read by the search
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString(`{"id":5,"actor":"d`)
+	f.Close()
+	if found, err = fa.Find(ctx, domain.AuditFilter{}, 10); err != nil || len(found) != 4 {
+		t.Errorf("Expected 4 written records, but got %+v, error %v", found, err)
+	}
+}
@@ -0,0 +1,228 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// cacheScanCount count hint for the redis SCAN command
+	cacheScanCount int64 = 500
+	// cacheDefaultTTL name of the default ttl in the CacheTTL
+	cacheDefaultTTL string = "default"
+)
+
+// Cache behavior of the storage for the cached repositories,
+// values are stored as json
+type Cache interface {
+	// Get decodes value by key into dst, returns false if key not found or expired
+	Get(ctx context.Context, key string, dst interface{}) (bool, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	DeleteByPrefix(ctx context.Context, prefix string) error
+	// Count returns count of keys with prefix
+	Count(prefix string) (int64, error)
+	Health() error
+}
+
+// CacheTTL time to live of cached values by method name, "default" for methods not in the map
+type CacheTTL map[string]time.Duration
+
+// TTL returns time to live for the method
+func (ct CacheTTL) TTL(method string) time.Duration {
+	if ttl, ok := ct[method]; ok {
+		return ttl
+	}
+	return ct[cacheDefaultTTL]
+}
+
+// NewCacheTTL returns CacheTTL from seconds by method name, key "default" for all other methods
+func NewCacheTTL(seconds map[string]int) CacheTTL {
+	ttl := make(CacheTTL, len(seconds))
+	for method, sec := range seconds {
+		ttl[method] = time.Duration(sec) * time.Second
+	}
+	return ttl
+}
+
+// RedisCache implementation of Cache with redis storage
+type RedisCache struct {
+	timeout time.Duration
+	client  *redis.Client
+}
+
+// NewRedisCache returns instance of RedisCache, url format "host:port"
+func NewRedisCache(url string, timeout time.Duration) *RedisCache {
+	return &RedisCache{
+		timeout: timeout,
+		client: redis.NewClient(&redis.Options{
+			Addr:         url,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		}),
+	}
+}
+
+// Get implementation of Cache interface
+func (rc *RedisCache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	raw, err := rc.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = json.Unmarshal(raw, dst); err != nil {
+		return false, fmt.Errorf("decode cached value of key %s error, %w", key, err)
+	}
+	return true, nil
+}
+
+// Set implementation of Cache interface
+func (rc *RedisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	return rc.client.Set(ctx, key, raw, ttl).Err()
+}
+
+// keys returns all keys with prefix using SCAN
+func (rc *RedisCache) keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	iter := rc.client.Scan(ctx, 0, prefix+"*", cacheScanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// DeleteByPrefix implementation of Cache interface
+func (rc *RedisCache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	ctx, cancel := context.WithTimeout(ctx, rc.timeout)
+	defer cancel()
+	keys, err := rc.keys(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return rc.client.Del(ctx, keys...).Err()
+}
+
+// Count implementation of Cache interface
+func (rc *RedisCache) Count(prefix string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rc.timeout)
+	defer cancel()
+	keys, err := rc.keys(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
+
+// Health implementation of Cache interface
+func (rc *RedisCache) Health() error {
+	ctx, cancel := context.WithTimeout(context.Background(), rc.timeout)
+	defer cancel()
+	return rc.client.Ping(ctx).Err()
+}
+
+// memEntry value of the MemoryCache with expiration time
+type memEntry struct {
+	value  []byte
+	expire time.Time
+}
+
+// MemoryCache implementation of Cache with in-memory LRU storage,
+// used when redis is not configured
+type MemoryCache struct {
+	lru *lru.Cache
+}
+
+// NewMemoryCache returns instance of MemoryCache with maximum size items
+func NewMemoryCache(size int) (*MemoryCache, error) {
+	l, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryCache{lru: l}, nil
+}
+
+// Get implementation of Cache interface
+func (mc *MemoryCache) Get(_ context.Context, key string, dst interface{}) (bool, error) {
+	v, ok := mc.lru.Get(key)
+	if !ok {
+		return false, nil
+	}
+	entry := v.(memEntry)
+	if !entry.expire.IsZero() && time.Now().After(entry.expire) {
+		mc.lru.Remove(key)
+		return false, nil
+	}
+	if err := json.Unmarshal(entry.value, dst); err != nil {
+		return false, fmt.Errorf("decode cached value of key %s error, %w", key, err)
+	}
+	return true, nil
+}
+
+// Set implementation of Cache interface
+func (mc *MemoryCache) Set(_ context.Context, key string, value interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	entry := memEntry{value: raw}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	mc.lru.Add(key, entry)
+	return nil
+}
+
+// DeleteByPrefix implementation of Cache interface
+func (mc *MemoryCache) DeleteByPrefix(_ context.Context, prefix string) error {
+	for _, k := range mc.lru.Keys() {
+		if key, ok := k.(string); ok && strings.HasPrefix(key, prefix) {
+			mc.lru.Remove(key)
+		}
+	}
+	return nil
+}
+
+// Count implementation of Cache interface, expired items are not counted
+func (mc *MemoryCache) Count(prefix string) (int64, error) {
+	var count int64
+	now := time.Now()
+	for _, k := range mc.lru.Keys() {
+		key, ok := k.(string)
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		v, ok := mc.lru.Peek(key)
+		if !ok {
+			continue
+		}
+		if entry := v.(memEntry); entry.expire.IsZero() || now.Before(entry.expire) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Health implementation of Cache interface
+func (mc *MemoryCache) Health() error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+// fakeCustomerRepo counts calls to the underlying repository
+type fakeCustomerRepo struct {
+	domain.CustomerRepo
+	calls map[string]int
+	err   error
+}
+
+func (f *fakeCustomerRepo) FindVideoCheckCfgByID(_ context.Context, id int64) (*domain.VideocheckConfig, error) {
+	f.calls["FindVideoCheckCfgByID"]++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &domain.VideocheckConfig{ProjectID: id}, nil
+}
+
+func (f *fakeCustomerRepo) FindVideoChechCfgs(_ context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.VideocheckConfigs, int64, error) {
+	f.calls["FindVideoChechCfgs"]++
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	return domain.VideocheckConfigs{{ProjectID: 1}, {ProjectID: 2}}, 2, nil
+}
+
+func (f *fakeCustomerRepo) DeleteVideoCheckCfgByID(_ context.Context, id int64) (int64, error) {
+	f.calls["DeleteVideoCheckCfgByID"]++
+	return 1, nil
+}
+
+func newRedisCache(t *testing.T) *RedisCache {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run error, %v", err)
+	}
+	t.Cleanup(mr.Close)
+	return NewRedisCache(mr.Addr(), time.Second)
+}
+
+func TestCacheTTL(t *testing.T) {
+	ttl := NewCacheTTL(map[string]int{"default": 60, "asset": 10})
+	if ttl.TTL("asset") != 10*time.Second {
+		t.Errorf("Expected ttl of asset 10s, but got %v", ttl.TTL("asset"))
+	}
+	if ttl.TTL("entity") != time.Minute {
+		t.Errorf("Expected default ttl 1m, but got %v", ttl.TTL("entity"))
+	}
+}
+
+func TestCache(t *testing.T) {
+	mem, err := NewMemoryCache(100)
+	if err != nil {
+		t.Fatalf("NewMemoryCache error, %v", err)
+	}
+	tCases := []struct {
+		name  string
+		cache Cache
+	}{
+		{"redis", newRedisCache(t)},
+		{"inmemory", mem},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cache.Health(); err != nil {
+				t.Fatalf("Expected healthy cache, but got %v", err)
+			}
+			ctx := context.Background()
+			var asset domain.Asset
+			found, err := tc.cache.Get(ctx, "ns:a:1", &asset)
+			if err != nil || found {
+				t.Errorf("Expected not found key without error, but got found=%v err=%v", found, err)
+			}
+			for _, key := range []string{"ns:a:1", "ns:a:2", "ns:b:1"} {
+				if err := tc.cache.Set(ctx, key, domain.Asset{ID: 7, Name: key}, time.Minute); err != nil {
+					t.Fatalf("Set key %s error, %v", key, err)
+				}
+			}
+			found, err = tc.cache.Get(ctx, "ns:a:1", &asset)
+			if err != nil || !found || asset.Name != "ns:a:1" {
+				t.Errorf("Expected cached asset ns:a:1, but got found=%v asset=%+v err=%v", found, asset, err)
+			}
+			if cnt, _ := tc.cache.Count("ns:a:"); cnt != 2 {
+				t.Errorf("Expected 2 keys with prefix ns:a:, but got %d", cnt)
+			}
+			if err := tc.cache.DeleteByPrefix(ctx, "ns:a:"); err != nil {
+				t.Fatalf("DeleteByPrefix error, %v", err)
+			}
+			if cnt, _ := tc.cache.Count("ns:"); cnt != 1 {
+				t.Errorf("Expected 1 key after delete, but got %d", cnt)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheExpire(t *testing.T) {
+	mem, _ := NewMemoryCache(10)
+	_ = mem.Set(context.Background(), "ns:x", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	var v int
+	if found, _ := mem.Get(context.Background(), "ns:x", &v); found {
+		t.Errorf("Expected expired key not found, but got %d", v)
+	}
+	if cnt, _ := mem.Count("ns:"); cnt != 0 {
+		t.Errorf("Expected 0 keys, but got %d", cnt)
+	}
+}
+
+func TestCachedCustomerRepo(t *testing.T) {
+	cache := newRedisCache(t)
+	fake := &fakeCustomerRepo{calls: make(map[string]int)}
+	repo := NewCachedCustomerRepo(fake, cache, NewCacheTTL(map[string]int{"default": 60}), "test",
+		zap.NewNop().Sugar())
+
+	for i := 0; i < 3; i++ {
+		cfg, err := repo.FindVideoCheckCfgByID(context.Background(), 42)
+		if err != nil || cfg == nil || cfg.ProjectID != 42 {
+			t.Fatalf("Expected config 42, but got %+v, error %v", cfg, err)
+		}
+		cfgs, count, err := repo.FindVideoChechCfgs(context.Background(), domain.ListQuery{}, 0, 10)
+		if err != nil || count != 2 || len(cfgs) != 2 {
+			t.Fatalf("Expected 2 configs, but got %d/%d, error %v", len(cfgs), count, err)
+		}
+	}
+	// config by id is never cached, its ETag is checked against the config in the DB
+	if fake.calls["FindVideoCheckCfgByID"] != 3 || fake.calls["FindVideoChechCfgs"] != 1 {
+		t.Errorf("Expected uncached config by id and one call of the list, but got %v", fake.calls)
+	}
+	if cnt, _ := cache.Count(CacheKeyPrefix("test", CacheScopeInfo)); cnt != 1 {
+		t.Errorf("Expected 1 cached key, but got %d", cnt)
+	}
+
+	// canceled request doesn't wait for the cache, but the change invalidates it
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.Get(canceled, repo.key(cmVideoChecks), &domain.VideocheckConfigs{}); err == nil {
+		t.Errorf("Expected error of the cache with canceled context, but got nil")
+	}
+	if _, err := repo.DeleteVideoCheckCfgByID(canceled, 42); err != nil {
+		t.Fatalf("DeleteVideoCheckCfgByID error, %v", err)
+	}
+	if cnt, _ := cache.Count(CacheKeyPrefix("test", CacheScopeInfo)); cnt != 0 {
+		t.Errorf("Expected invalidated cache, but got %d keys", cnt)
+	}
+	_, _, _ = repo.FindVideoChechCfgs(context.Background(), domain.ListQuery{}, 0, 10)
+	if fake.calls["FindVideoChechCfgs"] != 2 {
+		t.Errorf("Expected repository call after invalidation, but got %v", fake.calls)
+	}
+
+	// errors are not cached
+	fake.err = errors.New("db error")
+	if _, _, err := repo.FindVideoChechCfgs(context.Background(), domain.ListQuery{}, 10, 10); err == nil {
+		t.Errorf("Expected error from repository, but got nil")
+	}
+	if cnt, _ := cache.Count(CacheKeyPrefix("test", CacheScopeInfo)); cnt != 1 {
+		t.Errorf("Expected error not cached, but got %d keys", cnt)
+	}
+}
@@ -0,0 +1,416 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+
+	"go.uber.org/zap"
+)
+
+// scopes of the cache keys, used as label of the redis_key_items_total metric
+const (
+	CacheScopeInfo  string = "cminfo"
+	CacheScopeAsset string = "intraservice"
+	CacheScopeRef   string = "reference"
+	CacheScopeSD    string = "intraservice_api"
+)
+
+// names of the cached methods, used as cache key part and ttl name
+const (
+	cmCustomersConfig string = "customers_config"
+	cmCustomerConfig  string = "customer_config"
+	cmProjects        string = "projects"
+	cmProject         string = "project"
+	cmFTP             string = "ftp"
+	cmManualCountings string = "manual_countings"
+	cmVideoChecks     string = "videochecks"
+	cmAssets          string = "assets"
+	cmAsset           string = "asset"
+	cmAssetTree       string = "asset_tree"
+	cmEntities        string = "entities"
+	cmEntity          string = "entity"
+	cmSDDictionary    string = "sd_dictionary"
+)
+
+// cached common part of the cached repositories
+type cached struct {
+	cache  Cache
+	ttl    CacheTTL
+	prefix string
+	log    *zap.SugaredLogger
+}
+
+func newCached(cache Cache, ttl CacheTTL, namespace, scope string, logger *zap.SugaredLogger) cached {
+	return cached{
+		cache:  cache,
+		ttl:    ttl,
+		prefix: CacheKeyPrefix(namespace, scope),
+		log:    logger.With(zap.String("cache_scope", scope)),
+	}
+}
+
+// detachedContext keeps values of the parent context, e.g. request id, without its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// CacheKeyPrefix returns prefix of all cache keys of the scope,
+// namespace contains application version, so new version doesn't read values of the old one
+func CacheKeyPrefix(namespace, scope string) string {
+	return namespace + ":" + scope + ":"
+}
+
+// key makes cache key from method name and arguments
+func (c cached) key(method string, args ...interface{}) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, method)
+	for _, a := range args {
+		parts = append(parts, fmt.Sprint(a))
+	}
+	return c.prefix + strings.Join(parts, ":")
+}
+
+// fetch read-through: decodes cached value into dst or calls load, which must fill dst, and stores dst,
+// errors of the cache are logged only, repository works without cache
+func (c cached) fetch(ctx context.Context, method, key string, dst interface{}, load func() error) error {
+	found, err := c.cache.Get(ctx, key, dst)
+	if err != nil {
+		domain.RequestLogger(ctx, c.log).Warnf("cache get key=%s error, %v", key, err)
+	}
+	if found {
+		return nil
+	}
+	if err = load(); err != nil {
+		return err
+	}
+	if err = c.cache.Set(ctx, key, dst, c.ttl.TTL(method)); err != nil {
+		domain.RequestLogger(ctx, c.log).Warnf("cache set key=%s error, %v", key, err)
+	}
+	return nil
+}
+
+// invalidate deletes all cached values of the methods, it isn't canceled with the request,
+// because the change is already saved and the cache must not keep old values
+func (c cached) invalidate(ctx context.Context, methods ...string) {
+	for _, m := range methods {
+		if err := c.cache.DeleteByPrefix(detachedContext{ctx}, c.prefix+m+":"); err != nil {
+			domain.RequestLogger(ctx, c.log).Warnf("cache invalidate method=%s error, %v", m, err)
+		}
+	}
+}
+
+// CachedCustomerRepo caching decorator of the domain.CustomerRepo
+type CachedCustomerRepo struct {
+	cached
+	repo domain.CustomerRepo
+}
+
+// NewCachedCustomerRepo returns domain.CustomerRepo with read-through cache
+func NewCachedCustomerRepo(repo domain.CustomerRepo, cache Cache, ttl CacheTTL, namespace string,
+	logger *zap.SugaredLogger) *CachedCustomerRepo {
+	return &CachedCustomerRepo{
+		cached: newCached(cache, ttl, namespace, CacheScopeInfo, logger),
+		repo:   repo,
+	}
+}
+
+// FindCustomersConfig implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindCustomersConfig(ctx context.Context, q domain.ListQuery, offset, limit int64,
+	enabled bool) (domain.CustomerConfigs, int64, error) {
+	res := struct {
+		Data  domain.CustomerConfigs `json:"data"`
+		Count int64                  `json:"count"`
+	}{}
+	err := cc.fetch(ctx, cmCustomersConfig, cc.key(cmCustomersConfig, offset, limit, enabled, q), &res,
+		func() (err error) {
+			res.Data, res.Count, err = cc.repo.FindCustomersConfig(ctx, q, offset, limit, enabled)
+			return err
+		})
+	return res.Data, res.Count, err
+}
+
+// FindCustomerConfig implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindCustomerConfig(ctx context.Context, id int64) (*domain.CustomerConfig, error) {
+	var res *domain.CustomerConfig
+	err := cc.fetch(ctx, cmCustomerConfig, cc.key(cmCustomerConfig, id), &res, func() (err error) {
+		res, err = cc.repo.FindCustomerConfig(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// StoreCustomerConfig implementation of CustomerRepo interface, invalidates cached customer configs
+func (cc *CachedCustomerRepo) StoreCustomerConfig(ctx context.Context, cfg domain.CustomerConfig) error {
+	defer cc.invalidate(ctx, cmCustomersConfig, cmCustomerConfig)
+	return cc.repo.StoreCustomerConfig(ctx, cfg)
+}
+
+// UpdateCustomerConfig implementation of CustomerRepo interface, invalidates cached customer configs
+func (cc *CachedCustomerRepo) UpdateCustomerConfig(ctx context.Context, id int64,
+	change domain.CustomerConfigChange) (*domain.CustomerConfig, *domain.CustomerConfig, error) {
+	defer cc.invalidate(ctx, cmCustomersConfig, cmCustomerConfig)
+	return cc.repo.UpdateCustomerConfig(ctx, id, change)
+}
+
+// DeleteCustomerConfig implementation of CustomerRepo interface, invalidates cached customer configs
+func (cc *CachedCustomerRepo) DeleteCustomerConfig(ctx context.Context, id int64) (*domain.CustomerConfig, error) {
+	defer cc.invalidate(ctx, cmCustomersConfig, cmCustomerConfig)
+	return cc.repo.DeleteCustomerConfig(ctx, id)
+}
+
+// FindProjects implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindProjects(ctx context.Context, q domain.ListQuery, offset, limit int64,
+	enabled bool) (domain.Projects, int64, error) {
+	res := struct {
+		Data  domain.Projects `json:"data"`
+		Count int64           `json:"count"`
+	}{}
+	err := cc.fetch(ctx, cmProjects, cc.key(cmProjects, offset, limit, enabled, q), &res, func() (err error) {
+		res.Data, res.Count, err = cc.repo.FindProjects(ctx, q, offset, limit, enabled)
+		return err
+	})
+	return res.Data, res.Count, err
+}
+
+// FindProjectByID implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindProjectByID(ctx context.Context, id int64, dbType *string) (*domain.Project, error) {
+	var res *domain.Project
+	dbt := ""
+	if dbType != nil {
+		dbt = *dbType
+	}
+	err := cc.fetch(ctx, cmProject, cc.key(cmProject, id, dbt), &res, func() (err error) {
+		res, err = cc.repo.FindProjectByID(ctx, id, dbType)
+		return err
+	})
+	return res, err
+}
+
+// FindFTPByID implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindFTPByID(ctx context.Context, id int64) (*domain.FTPinfo, error) {
+	var res *domain.FTPinfo
+	err := cc.fetch(ctx, cmFTP, cc.key(cmFTP, id), &res, func() (err error) {
+		res, err = cc.repo.FindFTPByID(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// FindManualCountings implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindManualCountings(ctx context.Context, id, cid, offset,
+	limit int64) (domain.ManualCountings, int64, error) {
+	res := struct {
+		Data  domain.ManualCountings `json:"data"`
+		Count int64                  `json:"count"`
+	}{}
+	err := cc.fetch(ctx, cmManualCountings, cc.key(cmManualCountings, id, cid, offset, limit), &res,
+		func() (err error) {
+			res.Data, res.Count, err = cc.repo.FindManualCountings(ctx, id, cid, offset, limit)
+			return err
+		})
+	return res.Data, res.Count, err
+}
+
+// FindVideoChechCfgs implementation of CustomerRepo interface
+func (cc *CachedCustomerRepo) FindVideoChechCfgs(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.VideocheckConfigs, int64, error) {
+	res := struct {
+		Data  domain.VideocheckConfigs `json:"data"`
+		Count int64                    `json:"count"`
+	}{}
+	err := cc.fetch(ctx, cmVideoChecks, cc.key(cmVideoChecks, offset, limit, q), &res, func() (err error) {
+		res.Data, res.Count, err = cc.repo.FindVideoChechCfgs(ctx, q, offset, limit)
+		return err
+	})
+	return res.Data, res.Count, err
+}
+
+// FindVideoCheckCfgByID implementation of CustomerRepo interface, never cached,
+// ETag of the config must match the config locked by ChangeVideoCheckCfgs in any replica
+func (cc *CachedCustomerRepo) FindVideoCheckCfgByID(ctx context.Context, id int64) (*domain.VideocheckConfig, error) {
+	return cc.repo.FindVideoCheckCfgByID(ctx, id)
+}
+
+// StoreVideoCheckCfg implementation of CustomerRepo interface, invalidates cached videocheck configs
+func (cc *CachedCustomerRepo) StoreVideoCheckCfg(ctx context.Context, newCfg domain.VideocheckConfig) error {
+	defer cc.invalidate(ctx, cmVideoChecks)
+	return cc.repo.StoreVideoCheckCfg(ctx, newCfg)
+}
+
+// UpSertVideoCheckCfg implementation of CustomerRepo interface, invalidates cached videocheck configs
+func (cc *CachedCustomerRepo) UpSertVideoCheckCfg(ctx context.Context, updCfg domain.VideocheckConfig) error {
+	defer cc.invalidate(ctx, cmVideoChecks)
+	return cc.repo.UpSertVideoCheckCfg(ctx, updCfg)
+}
+
+// DeleteVideoCheckCfgByID implementation of CustomerRepo interface, invalidates cached videocheck configs
+func (cc *CachedCustomerRepo) DeleteVideoCheckCfgByID(ctx context.Context, id int64) (int64, error) {
+	defer cc.invalidate(ctx, cmVideoChecks)
+	return cc.repo.DeleteVideoCheckCfgByID(ctx, id)
+}
+
+// ChangeVideoCheckCfgs implementation of CustomerRepo interface, never cached, invalidates cached videocheck configs
+func (cc *CachedCustomerRepo) ChangeVideoCheckCfgs(ctx context.Context, ids []int64,
+	change domain.VideocheckChange) error {
+	defer cc.invalidate(ctx, cmVideoChecks)
+	return cc.repo.ChangeVideoCheckCfgs(ctx, ids, change)
+}
+
+// WithLock implementation of CustomerRepo interface, never cached
+func (cc *CachedCustomerRepo) WithLock(ctx context.Context, key string, fn func(context.Context) error) error {
+	return cc.repo.WithLock(ctx, key, fn)
+}
+
+// Health implementation of CustomerRepo interface, never cached
+func (cc *CachedCustomerRepo) Health(ctx context.Context) error {
+	return cc.repo.Health(ctx)
+}
+
+// CachedAssetRepo caching decorator of the domain.AssetRepo
+type CachedAssetRepo struct {
+	cached
+	repo domain.AssetRepo
+}
+
+// NewCachedAssetRepo returns domain.AssetRepo with read-through cache
+func NewCachedAssetRepo(repo domain.AssetRepo, cache Cache, ttl CacheTTL, namespace string,
+	logger *zap.SugaredLogger) *CachedAssetRepo {
+	return &CachedAssetRepo{
+		cached: newCached(cache, ttl, namespace, CacheScopeAsset, logger),
+		repo:   repo,
+	}
+}
+
+// FindAll implementation of AssetRepo interface
+func (ca *CachedAssetRepo) FindAll(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.Assets, int64, error) {
+	res := struct {
+		Data  domain.Assets `json:"data"`
+		Count int64         `json:"count"`
+	}{}
+	err := ca.fetch(ctx, cmAssets, ca.key(cmAssets, offset, limit, q), &res, func() (err error) {
+		res.Data, res.Count, err = ca.repo.FindAll(ctx, q, offset, limit)
+		return err
+	})
+	return res.Data, res.Count, err
+}
+
+// FindByID implementation of AssetRepo interface
+func (ca *CachedAssetRepo) FindByID(ctx context.Context, id int64) (*domain.Asset, error) {
+	var res *domain.Asset
+	err := ca.fetch(ctx, cmAsset, ca.key(cmAsset, id), &res, func() (err error) {
+		res, err = ca.repo.FindByID(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// FindDescendants implementation of AssetRepo interface
+func (ca *CachedAssetRepo) FindDescendants(ctx context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	var res domain.AssetNodes
+	err := ca.fetch(ctx, cmAssetTree, ca.key(cmAssetTree, "descendants", id, depth), &res, func() (err error) {
+		res, err = ca.repo.FindDescendants(ctx, id, depth)
+		return err
+	})
+	return res, err
+}
+
+// FindAncestors implementation of AssetRepo interface
+func (ca *CachedAssetRepo) FindAncestors(ctx context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	var res domain.AssetNodes
+	err := ca.fetch(ctx, cmAssetTree, ca.key(cmAssetTree, "ancestors", id, depth), &res, func() (err error) {
+		res, err = ca.repo.FindAncestors(ctx, id, depth)
+		return err
+	})
+	return res, err
+}
+
+// FindChanged implementation of AssetRepo interface, never cached, feed of the changes must be fresh
+func (ca *CachedAssetRepo) FindChanged(ctx context.Context, since time.Time, afterID,
+	limit int64) (domain.Assets, error) {
+	return ca.repo.FindChanged(ctx, since, afterID, limit)
+}
+
+// Health implementation of AssetRepo interface, never cached
+func (ca *CachedAssetRepo) Health(ctx context.Context) error {
+	return ca.repo.Health(ctx)
+}
+
+// CachedRefRepo caching decorator of the domain.RefRepo
+type CachedRefRepo struct {
+	cached
+	repo domain.RefRepo
+}
+
+// NewCachedRefRepo returns domain.RefRepo with read-through cache
+func NewCachedRefRepo(repo domain.RefRepo, cache Cache, ttl CacheTTL, namespace string,
+	logger *zap.SugaredLogger) *CachedRefRepo {
+	return &CachedRefRepo{
+		cached: newCached(cache, ttl, namespace, CacheScopeRef, logger),
+		repo:   repo,
+	}
+}
+
+// FindEntities implementation of RefRepo interface
+func (cr *CachedRefRepo) FindEntities(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.Entities, int64, error) {
+	res := struct {
+		Data  domain.Entities `json:"data"`
+		Count int64           `json:"count"`
+	}{}
+	err := cr.fetch(ctx, cmEntities, cr.key(cmEntities, offset, limit, q), &res, func() (err error) {
+		res.Data, res.Count, err = cr.repo.FindEntities(ctx, q, offset, limit)
+		return err
+	})
+	return res.Data, res.Count, err
+}
+
+// FindEntityByID implementation of RefRepo interface
+func (cr *CachedRefRepo) FindEntityByID(ctx context.Context, id string) (*domain.Entity, error) {
+	var res *domain.Entity
+	err := cr.fetch(ctx, cmEntity, cr.key(cmEntity, id), &res, func() (err error) {
+		res, err = cr.repo.FindEntityByID(ctx, id)
+		return err
+	})
+	return res, err
+}
+
+// Health implementation of RefRepo interface, never cached
+func (cr *CachedRefRepo) Health(ctx context.Context) error {
+	return cr.repo.Health(ctx)
+}
+
+// CachedSDRepo caching decorator of the domain.SDRepo, only dictionaries are cached,
+// tasks are always read from the ServiceDesk
+type CachedSDRepo struct {
+	domain.SDRepo
+	cached
+}
+
+// NewCachedSDRepo returns domain.SDRepo with read-through cache of the dictionaries
+func NewCachedSDRepo(repo domain.SDRepo, cache Cache, ttl CacheTTL, namespace string,
+	logger *zap.SugaredLogger) *CachedSDRepo {
+	return &CachedSDRepo{
+		SDRepo: repo,
+		cached: newCached(cache, ttl, namespace, CacheScopeSD, logger),
+	}
+}
+
+// FindDictionary implementation of SDRepo interface
+func (cs *CachedSDRepo) FindDictionary(ctx context.Context, name string) (domain.SDReferences, error) {
+	var res domain.SDReferences
+	err := cs.fetch(ctx, cmSDDictionary, cs.key(cmSDDictionary, name), &res, func() (err error) {
+		res, err = cs.SDRepo.FindDictionary(ctx, name)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,155 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// Lists of CM_INFO with filtering, search and sorting: cminfo package has only paged methods,
+// so the queries are executed directly by the tables which cminfo reads
+
+// customerConfigFrom tables of the customer configs
+const customerConfigFrom = `FROM [dbo].[ObjectConfigs] oc
+			JOIN [dbo].[Objects] o ON o.[ID] = oc.[ObjectID]`
+
+// customerConfigColumns fields of the customer config for filtering and sorting
+var customerConfigColumns = listColumns{
+	fields: map[string]string{
+		"customerId":     "o.[ID]",
+		"customerName":   "o.[Name]",
+		"customerTypeId": "o.[TypeID]",
+		"sdServiceId":    "oc.[SdServiceID]",
+		"sdCreatorId":    "oc.[SdCreatorID]",
+		"sdDestination":  "oc.[SdDestination]",
+	},
+	search: []string{"customerName", "sdDestination"},
+	key:    "customerId",
+}
+
+// projectFrom tables of the projects with database connections
+const projectFrom = `FROM [dbo].[Objects] o
+			JOIN [dbo].[ObjectDB] d ON d.[ObjectID] = o.[ID]
+			LEFT JOIN [dbo].[ObjectTypes] t ON t.[ID] = o.[TypeID]
+			LEFT JOIN [dbo].[Managers] m ON m.[ID] = o.[ManagerID]`
+
+// projectColumns fields of the project for filtering and sorting
+var projectColumns = listColumns{
+	fields: map[string]string{
+		"id":          "o.[ID]",
+		"name":        "o.[Name]",
+		"typeId":      "o.[TypeID]",
+		"typeName":    "t.[Name]",
+		"parentId":    "o.[ParentID]",
+		"managerId":   "o.[ManagerID]",
+		"managerName": "m.[Name]",
+		"isEnabled":   "o.[IsEnabled]",
+		"db_type":     "d.[DBType]",
+	},
+	search: []string{"name", "managerName"},
+	key:    "id",
+}
+
+// videoCheckColumns fields of the videocheck config for filtering and sorting
+var videoCheckColumns = listColumns{
+	fields: map[string]string{
+		"projectId":   "[ProjectID]",
+		"localServer": "[LocalServer]",
+		"localCam":    "[LocalCam]",
+		"localFtp":    "[LocalFtp]",
+		"options":     "[Options]",
+	},
+	search: []string{"options"},
+	key:    "projectId",
+}
+
+// listCustomersConfig returns customer configs by the list query
+func (cr *CustomersRepo) listCustomersConfig(ctx context.Context, q domain.ListQuery, offset, limit int64,
+	enabled bool) (domain.CustomerConfigs, int64, error) {
+	ls, err := buildListSQL(dialectMSSQL, customerConfigColumns, q, 0)
+	if err != nil {
+		return nil, -1, err
+	}
+	columns := `SELECT o.[ID], o.[Name], o.[TypeID],
+				COALESCE(oc.[SdServiceID], 0), COALESCE(oc.[SdCreatorID], 0), COALESCE(oc.[SdDestination], '')`
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	result := make(domain.CustomerConfigs, 0, limit)
+	count, err := mssqlPage(ctx, cr.db, columns, customerConfigFrom, "o.[IsEnabled] = ?", []interface{}{enabled},
+		ls, offset, limit, q.NoCount, func(rows *sql.Rows) error {
+			item := domain.CustomerConfig{}
+			err := rows.Scan(&item.CustomerID, &item.CustomerName, &item.CustomerTypeID,
+				&item.SdServiceID, &item.SdCreatorID, &item.SdDestination)
+			if err != nil {
+				return err
+			}
+			result = append(result, item)
+			return nil
+		})
+	if err != nil {
+		return nil, -1, err
+	}
+	return result, count, nil
+}
+
+// listProjects returns projects by the list query
+func (cr *CustomersRepo) listProjects(ctx context.Context, q domain.ListQuery, offset, limit int64,
+	enabled bool) (domain.Projects, int64, error) {
+	ls, err := buildListSQL(dialectMSSQL, projectColumns, q, 0)
+	if err != nil {
+		return nil, -1, err
+	}
+	columns := `SELECT o.[ID], o.[Name], o.[TypeID], COALESCE(t.[Name], ''), COALESCE(o.[ParentID], 0),
+				COALESCE(o.[ManagerID], 0), COALESCE(m.[Name], ''), o.[IsEnabled],
+				COALESCE(d.[IP], ''), COALESCE(d.[Port], 0), COALESCE(d.[DBName], ''),
+				COALESCE(d.[Login], ''), COALESCE(d.[Password], ''), COALESCE(d.[DBType], 0)`
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	result := make(domain.Projects, 0, limit)
+	count, err := mssqlPage(ctx, cr.db, columns, projectFrom, "o.[IsEnabled] = ?", []interface{}{enabled},
+		ls, offset, limit, q.NoCount, func(rows *sql.Rows) error {
+			item := domain.Project{}
+			err := rows.Scan(&item.ID, &item.Name, &item.TypeID, &item.TypeName, &item.ParentID,
+				&item.ManagerID, &item.ManagerName, &item.IsEnabled,
+				&item.IP, &item.Port, &item.DBName, &item.Login, &item.Password, &item.DBType)
+			if err != nil {
+				return err
+			}
+			result = append(result, item)
+			return nil
+		})
+	if err != nil {
+		return nil, -1, err
+	}
+	return result, count, nil
+}
+
+// listVideoCheckCfgs returns videocheck configs by the list query
+func (cr *CustomersRepo) listVideoCheckCfgs(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.VideocheckConfigs, int64, error) {
+	ls, err := buildListSQL(dialectMSSQL, videoCheckColumns, q, 0)
+	if err != nil {
+		return nil, -1, err
+	}
+	columns := `SELECT [ProjectID], [LocalServer], [LocalCam], [LocalFtp], COALESCE([Options], '')`
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	result := make(domain.VideocheckConfigs, 0, limit)
+	count, err := mssqlPage(ctx, cr.db, columns, "FROM [dbo].[VideocheckConfigs]", "1 = 1", nil,
+		ls, offset, limit, q.NoCount, func(rows *sql.Rows) error {
+			item := domain.VideocheckConfig{}
+			var options string
+			err := rows.Scan(&item.ProjectID, &item.LocalServer, &item.LocalCam, &item.LocalFtp, &options)
+			if err != nil {
+				return err
+			}
+			item.Options = domain.ParseVideocheckOptions(options)
+			result = append(result, item)
+			return nil
+		})
+	if err != nil {
+		return nil, -1, err
+	}
+	return result, count, nil
+}
@@ -2,19 +2,39 @@ package repos
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"git.countmax.ru/countmax/commonapi/domain"
 	"github.com/pkg/errors"
 
 	"git.countmax.ru/countmax/cminfo"
+
+	mssql "github.com/denisenkom/go-mssqldb" // go-mssqldb mssql import
+)
+
+// errors of the mssql: violation of the primary key or unique constraint, duplicate key of the unique index
+const (
+	mssqlErrDuplicateKey   int32 = 2627
+	mssqlErrDuplicateIndex int32 = 2601
 )
 
+// appLockPrefix prefix of the application locks of the service in CM_INFO
+const appLockPrefix string = "commonapi:"
+
+// vcLockChunk count of the ids in the query of the locked videocheck configs, mssql has 2100 parameters limit
+const vcLockChunk int = 1000
+
 type CustomersRepo struct {
 	connString string
 	info       *cminfo.CMINFO
 	timeout    time.Duration
+	// db connection for changes of the customer configs, cminfo has read only methods
+	db *sql.DB
 }
 
 // NewCustomersRepo returns instance of CustomersRepo with connected CM_INFO DB,
@@ -29,14 +49,24 @@ func NewCustomersRepo(connString string, timeout time.Duration) (*CustomersRepo,
 		return nil, err
 	}
 	cr.info = info
+	db, err := sql.Open("mssql", connString)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(dbMaxOpenConnections)
+	db.SetMaxIdleConns(dbMaxIdleConnections)
+	cr.db = db
 	return cr, nil
 }
 
 // FindCustomersConfig implementation of CustomrRepo interface,
 // returns slice of domain.CustomerConfig
-func (cr *CustomersRepo) FindCustomersConfig(offset int64, limit int64,
+func (cr *CustomersRepo) FindCustomersConfig(ctx context.Context, q domain.ListQuery, offset int64, limit int64,
 	enabled bool) (domain.CustomerConfigs, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+	if !q.IsEmpty() {
+		return cr.listCustomersConfig(ctx, q, offset, limit, enabled)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoCustomers, count, err := cr.info.GetObjectConfigsWithContext(ctx, offset, limit, enabled)
 	if err != nil {
@@ -56,13 +86,16 @@ func (cr *CustomersRepo) FindCustomersConfig(offset int64, limit int64,
 
 // FindCustomerConfig implementation of CustomrRepo interface,
 // returns domain.CustomerConfig
-func (cr *CustomersRepo) FindCustomerConfig(id int64) (*domain.CustomerConfig, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindCustomerConfig(ctx context.Context, id int64) (*domain.CustomerConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoCustomer, err := cr.info.GetObjectConfigByIDWithContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	if infoCustomer == nil {
+		return nil, nil
+	}
 	customer := &domain.CustomerConfig{
 		CustomerID:     infoCustomer.CustomerID,
 		CustomerName:   infoCustomer.CustomerName,
@@ -74,10 +107,108 @@ func (cr *CustomersRepo) FindCustomerConfig(id int64) (*domain.CustomerConfig, e
 	return customer, nil
 }
 
+// StoreCustomerConfig implementation of CustomrRepo interface,
+// inserts service desk parameters of the customer
+func (cr *CustomersRepo) StoreCustomerConfig(ctx context.Context, cfg domain.CustomerConfig) error {
+	query := `INSERT INTO [dbo].[ObjectConfigs] ([ObjectID], [SdServiceID], [SdCreatorID], [SdDestination])
+			VALUES (?, ?, ?, ?)`
+	_, err := cr.changeCustomerConfig(ctx, cfg.CustomerID,
+		func(ctx context.Context, tx *sql.Tx, before *domain.CustomerConfig) error {
+			if before != nil {
+				return domain.ErrAlreadyExists
+			}
+			_, err := tx.ExecContext(ctx, query, cfg.CustomerID, cfg.SdServiceID, cfg.SdCreatorID,
+				cfg.SdDestination)
+			var mssqlErr mssql.Error
+			if errors.As(err, &mssqlErr) && (mssqlErr.Number == mssqlErrDuplicateKey ||
+				mssqlErr.Number == mssqlErrDuplicateIndex) {
+				return fmt.Errorf("%w, %v", domain.ErrAlreadyExists, err)
+			}
+			return err
+		})
+	return err
+}
+
+// UpdateCustomerConfig implementation of CustomrRepo interface,
+// updates service desk parameters of the customer by change of the locked config,
+// returns configs before and after the update or nil if it's not found
+func (cr *CustomersRepo) UpdateCustomerConfig(ctx context.Context, id int64,
+	change domain.CustomerConfigChange) (*domain.CustomerConfig, *domain.CustomerConfig, error) {
+	query := `UPDATE [dbo].[ObjectConfigs]
+			SET [SdServiceID] = ?, [SdCreatorID] = ?, [SdDestination] = ?
+			WHERE [ObjectID] = ?`
+	var after domain.CustomerConfig
+	before, err := cr.changeCustomerConfig(ctx, id,
+		func(ctx context.Context, tx *sql.Tx, before *domain.CustomerConfig) error {
+			if before == nil {
+				return nil
+			}
+			after = change(*before)
+			after.CustomerID = id
+			_, err := tx.ExecContext(ctx, query, after.SdServiceID, after.SdCreatorID, after.SdDestination, id)
+			return err
+		})
+	if err != nil || before == nil {
+		return nil, nil, err
+	}
+	return before, &after, nil
+}
+
+// DeleteCustomerConfig implementation of CustomrRepo interface,
+// deletes service desk parameters of the customer, returns deleted config or nil if it's not found
+func (cr *CustomersRepo) DeleteCustomerConfig(ctx context.Context, id int64) (*domain.CustomerConfig, error) {
+	query := `DELETE FROM [dbo].[ObjectConfigs] WHERE [ObjectID] = ?`
+	return cr.changeCustomerConfig(ctx, id,
+		func(ctx context.Context, tx *sql.Tx, before *domain.CustomerConfig) error {
+			if before == nil {
+				return nil
+			}
+			_, err := tx.ExecContext(ctx, query, id)
+			return err
+		})
+}
+
+// changeCustomerConfig executes change of the customer config locked in the transaction (nil if it doesn't exist),
+// returns the config before the change. The config is read without the object too, if the object is deleted
+func (cr *CustomersRepo) changeCustomerConfig(ctx context.Context, id int64,
+	change func(context.Context, *sql.Tx, *domain.CustomerConfig) error) (*domain.CustomerConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	tx, err := cr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // nolint:errcheck
+	queryBefore := `SELECT COALESCE(o.[Name], ''), COALESCE(o.[TypeID], 0), oc.[SdServiceID], oc.[SdCreatorID],
+				COALESCE(oc.[SdDestination], '')
+			FROM [dbo].[ObjectConfigs] oc WITH (UPDLOCK, HOLDLOCK)
+			LEFT JOIN [dbo].[Objects] o ON o.[ID] = oc.[ObjectID]
+			WHERE oc.[ObjectID] = ?`
+	var before *domain.CustomerConfig
+	prev := domain.CustomerConfig{CustomerID: id}
+	err = tx.QueryRowContext(ctx, queryBefore, id).Scan(&prev.CustomerName, &prev.CustomerTypeID,
+		&prev.SdServiceID, &prev.SdCreatorID, &prev.SdDestination)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, fmt.Errorf("query [%s], error %v", queryBefore, err)
+	default:
+		before = &prev
+	}
+	if err = change(ctx, tx, before); err != nil {
+		return nil, err
+	}
+	return before, tx.Commit()
+}
+
 // FindProjects implementation of CustomrRepo interface,
 // returns slice of domain.Project
-func (cr *CustomersRepo) FindProjects(offset, limit int64, enabled bool) (domain.Projects, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindProjects(ctx context.Context, q domain.ListQuery, offset, limit int64,
+	enabled bool) (domain.Projects, int64, error) {
+	if !q.IsEmpty() {
+		return cr.listProjects(ctx, q, offset, limit, enabled)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoCustomers, count, err := cr.info.GetObjectConfigsDBWithContext(ctx, offset, limit, enabled)
 	if err != nil {
@@ -105,8 +236,8 @@ func (cr *CustomersRepo) FindProjects(offset, limit int64, enabled bool) (domain
 
 // FindProjectByID implementation of CustomrRepo interface,
 // returns domain.Project with specified Id
-func (cr *CustomersRepo) FindProjectByID(id int64, dbType *string) (*domain.Project, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindProjectByID(ctx context.Context, id int64, dbType *string) (*domain.Project, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	if dbType == nil {
 		infoCustomer, err := cr.info.GetObjectConfigDBWithContext(ctx, id)
@@ -154,8 +285,8 @@ func infoProject2domainProject(src *cminfo.Project) *domain.Project {
 
 // FindFTPByID implementation of CustomrRepo interface,
 // returns FTP settings for customer
-func (cr *CustomersRepo) FindFTPByID(id int64) (*domain.FTPinfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindFTPByID(ctx context.Context, id int64) (*domain.FTPinfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoFTP, err := cr.info.GetObjectFTPContext(ctx, id)
 	if err != nil {
@@ -191,8 +322,9 @@ func (cr *CustomersRepo) FindFTPByID(id int64) (*domain.FTPinfo, error) {
 
 // FindManualCountings implementation of CustomrRepo interface,
 // returns manual countings data for controller id in the project of customer pid
-func (cr *CustomersRepo) FindManualCountings(id, cid, offset, limit int64) (domain.ManualCountings, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindManualCountings(ctx context.Context, id, cid, offset,
+	limit int64) (domain.ManualCountings, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoMCs, count, err := cr.info.GetManualCntsByCntrlIDContext(ctx, id, cid, offset, limit)
 	if err != nil {
@@ -213,8 +345,15 @@ func (cr *CustomersRepo) FindManualCountings(id, cid, offset, limit int64) (doma
 	}
 	return result, count, nil
 }
-func (cr *CustomersRepo) FindVideoChechCfgs(offset, limit int64) (domain.VideocheckConfigs, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+
+// FindVideoChechCfgs implementation of CustomrRepo interface,
+// returns slice of domain.VideocheckConfig
+func (cr *CustomersRepo) FindVideoChechCfgs(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.VideocheckConfigs, int64, error) {
+	if !q.IsEmpty() {
+		return cr.listVideoCheckCfgs(ctx, q, offset, limit)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoVCs, count, err := cr.info.GetVideoCheckContext(ctx, offset, limit)
 	if err != nil {
@@ -226,13 +365,13 @@ func (cr *CustomersRepo) FindVideoChechCfgs(offset, limit int64) (domain.Videoch
 		result[i].LocalServer = v.LocalServer
 		result[i].LocalCam = v.LocalCam
 		result[i].LocalFtp = v.LocalFtp
-		result[i].Options = v.Options
+		result[i].Options = domain.ParseVideocheckOptions(v.Options)
 	}
 	return result, count, nil
 }
 
-func (cr *CustomersRepo) FindVideoCheckCfgByID(id int64) (*domain.VideocheckConfig, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) FindVideoCheckCfgByID(ctx context.Context, id int64) (*domain.VideocheckConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	infoVC, err := cr.info.GetVideoCheckByIDContext(ctx, id)
 	if err != nil {
@@ -246,47 +385,156 @@ func (cr *CustomersRepo) FindVideoCheckCfgByID(id int64) (*domain.VideocheckConf
 		LocalServer: infoVC.LocalServer,
 		LocalCam:    infoVC.LocalCam,
 		LocalFtp:    infoVC.LocalFtp,
-		Options:     infoVC.Options,
+		Options:     domain.ParseVideocheckOptions(infoVC.Options),
 	}
 	return vc, nil
 }
 
-func (cr *CustomersRepo) StoreVideoCheckCfg(newCfg domain.VideocheckConfig) error {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) StoreVideoCheckCfg(ctx context.Context, newCfg domain.VideocheckConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	newInfoVC := cminfo.VideocheckConfig{
 		ProjectID:   newCfg.ProjectID,
 		LocalServer: newCfg.LocalServer,
 		LocalCam:    newCfg.LocalCam,
 		LocalFtp:    newCfg.LocalFtp,
-		Options:     newCfg.Options,
+		Options:     newCfg.Options.String(),
 	}
 	return cr.info.NewVideoCheckConfigContext(ctx, newInfoVC)
 }
 
-func (cr *CustomersRepo) UpSertVideoCheckCfg(updCfg domain.VideocheckConfig) error {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) UpSertVideoCheckCfg(ctx context.Context, updCfg domain.VideocheckConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	updInfoVC := cminfo.VideocheckConfig{
 		ProjectID:   updCfg.ProjectID,
 		LocalServer: updCfg.LocalServer,
 		LocalCam:    updCfg.LocalCam,
 		LocalFtp:    updCfg.LocalFtp,
-		Options:     updCfg.Options,
+		Options:     updCfg.Options.String(),
 	}
 	return cr.info.UpdVideoCheckConfigContext(ctx, updInfoVC)
 }
 
-func (cr *CustomersRepo) DeleteVideoCheckCfgByID(id int64) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) DeleteVideoCheckCfgByID(ctx context.Context, id int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	return cr.info.DelVideoCheckConfigContext(ctx, id)
 }
 
+// ChangeVideoCheckCfgs implementation of CustomrRepo interface, configs are locked till the end
+// of the transaction, missing ones too, so concurrent changes wait for it
+func (cr *CustomersRepo) ChangeVideoCheckCfgs(ctx context.Context, ids []int64,
+	change domain.VideocheckChange) error {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	tx, err := cr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+	current, err := lockVideoCheckCfgs(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	changes, err := change(current)
+	if err != nil {
+		return err
+	}
+	changed := make([]int64, 0, len(changes))
+	for id := range changes {
+		changed = append(changed, id)
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i] < changed[j] })
+	for _, id := range changed {
+		vc := changes[id]
+		switch {
+		case vc == nil:
+			_, err = tx.ExecContext(ctx, `DELETE FROM [dbo].[VideocheckConfigs] WHERE [ProjectID] = ?`, id)
+		case current[id] == nil:
+			_, err = tx.ExecContext(ctx, `INSERT INTO [dbo].[VideocheckConfigs]
+				([ProjectID], [LocalServer], [LocalCam], [LocalFtp], [Options]) VALUES (?, ?, ?, ?, ?)`,
+				id, vc.LocalServer, vc.LocalCam, vc.LocalFtp, vc.Options.String())
+		default:
+			_, err = tx.ExecContext(ctx, `UPDATE [dbo].[VideocheckConfigs]
+				SET [LocalServer] = ?, [LocalCam] = ?, [LocalFtp] = ?, [Options] = ?
+				WHERE [ProjectID] = ?`, vc.LocalServer, vc.LocalCam, vc.LocalFtp, vc.Options.String(), id)
+		}
+		if err != nil {
+			return fmt.Errorf("write videocheck config projectId=%d, error %v", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// lockVideoCheckCfgs reads configs of the ids with update and range locks by chunks of vcLockChunk ids
+func lockVideoCheckCfgs(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]*domain.VideocheckConfig,
+	error) {
+	current := make(map[int64]*domain.VideocheckConfig, len(ids))
+	for start := 0; start < len(ids); start += vcLockChunk {
+		end := start + vcLockChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, id := range ids[start:end] {
+			args = append(args, id)
+		}
+		query := `SELECT [ProjectID], [LocalServer], [LocalCam], [LocalFtp], COALESCE([Options], '')
+			FROM [dbo].[VideocheckConfigs] WITH (UPDLOCK, HOLDLOCK)
+			WHERE [ProjectID] IN (?` + strings.Repeat(", ?", len(args)-1) + `)`
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("query [%s], error %v", query, err)
+		}
+		for rows.Next() {
+			vc := &domain.VideocheckConfig{}
+			var options string
+			if err = rows.Scan(&vc.ProjectID, &vc.LocalServer, &vc.LocalCam, &vc.LocalFtp, &options); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			vc.Options = domain.ParseVideocheckOptions(options)
+			current[vc.ProjectID] = vc
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// WithLock implementation of CustomrRepo interface, sp_getapplock is owned by the transaction without changes,
+// which is rolled back after the function, waiting for the lock is limited by the sql timeout
+func (cr *CustomersRepo) WithLock(ctx context.Context, key string, fn func(context.Context) error) error {
+	tx, err := cr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+	query := `DECLARE @res int;
+			EXEC @res = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Transaction',
+				@LockTimeout = ?;
+			SELECT @res;`
+	lockCtx, cancel := context.WithTimeout(ctx, cr.timeout)
+	defer cancel()
+	var res int
+	if err = tx.QueryRowContext(lockCtx, query, appLockPrefix+key, cr.timeout.Milliseconds()).Scan(&res); err != nil {
+		return fmt.Errorf("query [%s], error %v", query, err)
+	}
+	// 0 and 1 the lock is granted at once or after waiting, negative values are timeout, deadlock and errors
+	if res < 0 {
+		return fmt.Errorf("lock %s isn't granted, sp_getapplock result %d", key, res)
+	}
+	return fn(ctx)
+}
+
 // Health implementation of CustomrRepo interface,
 // check simple sql query to sql server
-func (cr *CustomersRepo) Health() error {
-	ctx, cancel := context.WithTimeout(context.Background(), cr.timeout)
+func (cr *CustomersRepo) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, cr.timeout)
 	defer cancel()
 	return cr.info.Healthz(ctx)
 }
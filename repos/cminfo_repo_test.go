@@ -1,8 +1,10 @@
 package repos
 
 import (
+	"context"
 	"testing"
-	//"git.countmax.ru/countmax/commonapi/domain"
+
+	"git.countmax.ru/countmax/commonapi/domain"
 	// "go.uber.org/zap"
 	// "go.uber.org/zap/zapcore"
 )
@@ -17,7 +19,7 @@ func TestCustomersRepoHealth(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected success make CustomersRepo, but error, %v", err)
 	}
-	err = cr.Health()
+	err = cr.Health(context.Background())
 	if err != nil {
 		t.Errorf("Expected success cr.Health(), but error %v", err)
 	}
@@ -28,7 +30,7 @@ func TestFindCustomersConfig(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected success make CustomersRepo, but error, %v", err)
 	}
-	customers, count, err := cr.FindCustomersConfig(0, 10, true)
+	customers, count, err := cr.FindCustomersConfig(context.Background(), domain.ListQuery{}, 0, 10, true)
 	if err != nil {
 		t.Errorf("Expected success cr.FindCustomersConfig(), but error %v", err)
 	}
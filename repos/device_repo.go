@@ -0,0 +1,236 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+
+	_ "github.com/denisenkom/go-mssqldb" // go-mssqldb mssql import
+)
+
+// DeviceSQLRepo repository of devices from the CountMax 5.2.3 project databases,
+// connections are opened on demand by project connection parameters from CM_INFO and cached
+type DeviceSQLRepo struct {
+	timeout         time.Duration
+	onlineThreshold time.Duration
+	mu              sync.Mutex
+	// dbs connections by server, database and login of the project
+	dbs map[string]*projectConn
+}
+
+// projectConn connection to the project database, done is closed when the connection is checked
+type projectConn struct {
+	connString string
+	done       chan struct{}
+	db         *sql.DB
+	err        error
+}
+
+// NewDeviceSQLRepo returns instance of DeviceSQLRepo, device is online
+// if it was seen not later than onlineThreshold ago
+func NewDeviceSQLRepo(timeout, onlineThreshold time.Duration) *DeviceSQLRepo {
+	return &DeviceSQLRepo{
+		timeout:         timeout,
+		onlineThreshold: onlineThreshold,
+		dbs:             make(map[string]*projectConn),
+	}
+}
+
+// projectConnString returns sqlserver:// URL of the project database,
+// credentials and database are escaped so that ';' or '=' in them don't add connection parameters
+func projectConnString(p domain.Project) string {
+	host := p.IP
+	if p.Port != 0 {
+		host = net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+	}
+	u := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(p.Login, p.Password),
+		Host:     host,
+		RawQuery: url.Values{"database": {p.DBName}}.Encode(),
+	}
+	return u.String()
+}
+
+// conn returns cached connection to the project database or makes new one, connection is checked
+// once for concurrent requests of the same database without blocking requests of other databases,
+// connection with changed password replaces the cached one
+func (dr *DeviceSQLRepo) conn(ctx context.Context, p domain.Project) (*sql.DB, error) {
+	if p.IP == "" || p.DBName == "" {
+		return nil, fmt.Errorf("project %d has empty database connection parameters", p.ID)
+	}
+	key := fmt.Sprintf("%s:%d/%s@%s", p.IP, p.Port, p.DBName, p.Login)
+	connString := projectConnString(p)
+	dr.mu.Lock()
+	pc, ok := dr.dbs[key]
+	if ok && pc.connString != connString {
+		// credentials are rotated, the old connection isn't used anymore
+		go closeProjectConn(pc)
+		ok = false
+	}
+	if !ok {
+		pc = &projectConn{connString: connString, done: make(chan struct{})}
+		dr.dbs[key] = pc
+	}
+	dr.mu.Unlock()
+	if !ok {
+		dr.open(ctx, p, key, pc)
+	}
+	select {
+	case <-pc.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return pc.db, pc.err
+}
+
+// open connects to the project database, failed connection is removed from the cache to retry it later
+func (dr *DeviceSQLRepo) open(ctx context.Context, p domain.Project, key string, pc *projectConn) {
+	defer close(pc.done)
+	db, err := sql.Open("mssql", pc.connString)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, dr.timeout)
+		defer cancel()
+		if err = db.PingContext(ctx); err != nil {
+			db.Close()
+		}
+	}
+	if err != nil {
+		pc.err = fmt.Errorf("connection to project %d database [%s].[%d].[%s] error, %v",
+			p.ID, p.IP, p.Port, p.DBName, err)
+		dr.mu.Lock()
+		if dr.dbs[key] == pc {
+			delete(dr.dbs, key)
+		}
+		dr.mu.Unlock()
+		return
+	}
+	db.SetMaxOpenConns(dbMaxOpenConnections)
+	db.SetMaxIdleConns(dbMaxIdleConnections)
+	pc.db = db
+}
+
+// closeProjectConn closes connection after its check
+func closeProjectConn(pc *projectConn) error {
+	<-pc.done
+	if pc.db == nil {
+		return nil
+	}
+	return pc.db.Close()
+}
+
+// Close - close all opened connections to project databases
+func (dr *DeviceSQLRepo) Close() error {
+	dr.mu.Lock()
+	conns := make([]*projectConn, 0, len(dr.dbs))
+	for key, pc := range dr.dbs {
+		conns = append(conns, pc)
+		delete(dr.dbs, key)
+	}
+	dr.mu.Unlock()
+	var resErr error
+	for _, pc := range conns {
+		if err := closeProjectConn(pc); err != nil {
+			resErr = err
+		}
+	}
+	return resErr
+}
+
+// isOnline returns true if device was seen not later than onlineThreshold ago
+func (dr *DeviceSQLRepo) isOnline(lastSeen time.Time) bool {
+	return !lastSeen.IsZero() && time.Since(lastSeen) <= dr.onlineThreshold
+}
+
+const deviceColumns = `SELECT c.[Sernomer]
+				,COALESCE(c.[LayoutID], '')
+				,COALESCE(z.[Name], '')
+				,COALESCE(e.[Name], '')
+				,COALESCE(c.[Model], '')
+				,c.[LastActivity]
+			FROM [dbo].[Controllers] c
+			LEFT JOIN [dbo].[Enters] e ON e.[ID] = c.[EnterID]
+			LEFT JOIN [dbo].[Zones] z ON z.[ID] = e.[ZoneID]`
+
+func (dr *DeviceSQLRepo) scanDevice(p domain.Project,
+	row interface{ Scan(...interface{}) error }) (domain.Device, error) {
+	item := domain.Device{ProjectID: p.ID}
+	var lastSeen sql.NullTime
+	err := row.Scan(&item.SN, &item.LayoutID, &item.Zone, &item.Enter, &item.Model, &lastSeen)
+	if err != nil {
+		return item, err
+	}
+	if lastSeen.Valid {
+		item.LastSeen = lastSeen.Time
+	}
+	item.IsOnline = dr.isOnline(item.LastSeen)
+	return item, nil
+}
+
+// FindDevices returns slice of devices of the project, empty layoutID means all layouts
+func (dr *DeviceSQLRepo) FindDevices(ctx context.Context, p domain.Project, layoutID string,
+	offset, limit int64) (domain.Devices, int64, error) {
+	db, err := dr.conn(ctx, p)
+	if err != nil {
+		return nil, -1, err
+	}
+	query := deviceColumns + `
+			WHERE (? = '' OR c.[LayoutID] = ?)
+			ORDER BY c.[Sernomer]
+			OFFSET ? ROWS
+			FETCH NEXT ? ROWS ONLY;
+			SELECT count(*) FROM [dbo].[Controllers] c
+			WHERE (? = '' OR c.[LayoutID] = ?)`
+	ctx, cancel := context.WithTimeout(ctx, dr.timeout)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, layoutID, layoutID, offset, limit, layoutID, layoutID)
+	if err != nil {
+		return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	result := make(domain.Devices, 0, limit)
+	for rows.Next() {
+		item, err := dr.scanDevice(p, rows)
+		if err != nil {
+			return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+		}
+		result = append(result, item)
+	}
+	var count int64
+	if rows.NextResultSet() {
+		for rows.Next() {
+			err = rows.Scan(&count)
+			if err != nil {
+				return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+			}
+		}
+	}
+	return result, count, nil
+}
+
+// FindDeviceBySN returns single device of the project with specified serial number
+func (dr *DeviceSQLRepo) FindDeviceBySN(ctx context.Context, p domain.Project, sn string) (*domain.Device, error) {
+	db, err := dr.conn(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	query := deviceColumns + `
+			WHERE c.[Sernomer] = ?`
+	ctx, cancel := context.WithTimeout(ctx, dr.timeout)
+	defer cancel()
+	item, err := dr.scanDevice(p, db.QueryRowContext(ctx, query, sn))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	return &item, nil
+}
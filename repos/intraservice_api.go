@@ -2,12 +2,15 @@ package repos
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"git.countmax.ru/countmax/commonapi/domain"
@@ -17,6 +20,12 @@ import (
 
 const (
 	maxIdleConns int = 10
+	// isDateParamLayout format of the dates in the query parameters of the Intraservice api
+	isDateParamLayout string = "2006-01-02T15:04:05"
+	// isListPageSize page size of the task history and the dictionaries, all pages are read
+	isListPageSize int = 1000
+	// isListMaxPages limit of the pages of the list, the rest is truncated
+	isListMaxPages int = 100
 )
 
 // ISAPI repository for servicedesk entities
@@ -25,7 +34,10 @@ type ISAPI struct {
 	user    string
 	pass    string
 	handler *http.Client
-	log     *zap.SugaredLogger
+	// files client without timeout of the whole request for the files of the tasks,
+	// transfer is limited by the context of the request
+	files *http.Client
+	log   *zap.SugaredLogger
 }
 
 // NewISAPI builder for ISAPI
@@ -48,13 +60,83 @@ func NewISAPI(url, user, pass string, timeout time.Duration, logger *zap.Sugared
 		IdleConnTimeout: timeout,
 		TLSClientConfig: cfg,
 	}}
+	api.files = &http.Client{Transport: api.handler.Transport}
 	return api
 }
 
-func (api *ISAPI) FindAllBySN(serNomer string, offset, limit int64) (domain.ControllerTasks, int64, error) {
-	uri := fmt.Sprintf("%s/api/task?search=%s&pagesize=%d&page=1", api.url, serNomer, limit)
-	log := api.log.With(zap.String("target", uri))
-	request, err := http.NewRequest("GET", uri, nil)
+// FindAllBySN returns tasks of the controller by serial number, Intraservice pages by page number,
+// so offset and limit are translated to the pages of size limit and one or two pages are requested
+func (api *ISAPI) FindAllBySN(ctx context.Context, serNomer string, filter domain.TaskFilter, offset,
+	limit int64) (domain.Tasks, int64, error) {
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	first, last := offset/limit+1, (offset+limit-1)/limit+1
+	result := make(domain.Tasks, 0, limit)
+	var count int64
+	for page := first; page <= last; page++ {
+		tasks, total, err := api.findTasksPage(ctx, taskSearchParams(serNomer, filter, page, limit))
+		if err != nil {
+			return nil, 0, err
+		}
+		count = total
+		// position of the page in the result, the first page can start before offset
+		skip := offset - (page-1)*limit
+		if skip < 0 {
+			skip = 0
+		}
+		if skip < int64(len(tasks)) {
+			result = append(result, tasks[skip:]...)
+		}
+		if int64(len(tasks)) < limit || page*limit >= count {
+			break
+		}
+	}
+	if int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, count, nil
+}
+
+// taskSearchParams query parameters of the Intraservice tasks search
+func taskSearchParams(search string, filter domain.TaskFilter, page, pageSize int64) url.Values {
+	params := url.Values{}
+	params.Set("search", search)
+	params.Set("page", strconv.FormatInt(page, 10))
+	params.Set("pagesize", strconv.FormatInt(pageSize, 10))
+	if len(filter.StatusIDs) > 0 {
+		params.Set("statusids", joinIDs(filter.StatusIDs))
+	}
+	if len(filter.ServiceIDs) > 0 {
+		params.Set("serviceids", joinIDs(filter.ServiceIDs))
+	}
+	// Intraservice compares dates in the time zone of its server
+	if filter.CreatedFrom != nil {
+		params.Set("createdmoreorequal", filter.CreatedFrom.In(time.Local).Format(isDateParamLayout))
+	}
+	if filter.CreatedTo != nil {
+		params.Set("createdlessorequal", filter.CreatedTo.In(time.Local).Format(isDateParamLayout))
+	}
+	if filter.NewestFirst {
+		params.Set("sort", "Id desc")
+	}
+	return params
+}
+
+// joinIDs comma separated list of the ids
+func joinIDs(ids []int64) string {
+	list := make([]string, len(ids))
+	for i := range ids {
+		list[i] = strconv.FormatInt(ids[i], 10)
+	}
+	return strings.Join(list, ",")
+}
+
+// findTasksPage returns page of the tasks and total count of the tasks
+func (api *ISAPI) findTasksPage(ctx context.Context, params url.Values) (domain.Tasks, int64, error) {
+	uri := fmt.Sprintf("%s/api/task?%s", api.url, params.Encode())
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		log.Errorf("make NewRequest error, %v", err)
 		return nil, 0, err
@@ -74,37 +156,195 @@ func (api *ISAPI) FindAllBySN(serNomer string, offset, limit int64) (domain.Cont
 		log.Warnf("task search wrong response, status=%s, body=%s", response.Status, string(txt))
 		return nil, 0, fmt.Errorf("task search wrong response, status=%s, body=%s", response.Status, string(txt))
 	}
-	rawTasks := make(map[string]interface{})
-	err = json.NewDecoder(response.Body).Decode(&rawTasks)
+	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Errorf("make json.NewDecoder.Decode error, %v", err)
+		log.Errorf("ioutil.ReadAll error, %v", err)
 		return nil, 0, err
 	}
-	pagi := rawTasks["Paginator"].(map[string]interface{})
-	count := pagi["Count"].(float64)
+	// Intraservice returns dates in the time zone of its server without offset
+	tasks, count, err := decodeTasksPage(body, time.Local)
+	if err != nil {
+		log.Errorf("decodeTasksPage error, %v", err)
+		return nil, 0, err
+	}
+	return tasks, count, nil
+}
 
-	tasks, ok := rawTasks["Tasks"].([]interface{})
+// FindTaskByID returns task by id, nil if task not found
+func (api *ISAPI) FindTaskByID(ctx context.Context, id int64) (*domain.Task, error) {
+	body, found, err := api.getJSON(ctx, fmt.Sprintf("%s/api/task/%d", api.url, id))
+	if err != nil || !found {
+		return nil, err
+	}
+	// Intraservice returns task as is or wrapped into the Task field
+	wrapped := isFields{}
+	if err = json.Unmarshal(body, &wrapped); err == nil && len(wrapped["Task"]) > 0 {
+		body = wrapped["Task"]
+	}
+	task, err := decodeTask(body, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// TaskLifetime returns comments and status transitions of the task, nil if task not found
+func (api *ISAPI) TaskLifetime(ctx context.Context, id int64) (*domain.TaskLifetime, error) {
+	items, found, err := api.getList(ctx, fmt.Sprintf("%s/api/tasklifetime?taskid=%d", api.url, id), "TaskLifetimes")
+	if err != nil || !found {
+		return nil, err
+	}
+	return decodeTaskLifetime(items, id, time.Local)
+}
+
+// isDictionaryPaths paths of the dictionaries in the Intraservice api
+var isDictionaryPaths = map[string]string{
+	domain.SDStatuses:   "/api/status",
+	domain.SDPriorities: "/api/priority",
+	domain.SDServices:   "/api/service",
+	domain.SDTaskTypes:  "/api/tasktype",
+	domain.SDTaskFields: "/api/taskfield",
+}
+
+// FindDictionary returns items of the Intraservice dictionary
+func (api *ISAPI) FindDictionary(ctx context.Context, name string) (domain.SDReferences, error) {
+	path, ok := isDictionaryPaths[name]
 	if !ok {
-		log.Error("make slice of Tasks error")
-		return nil, 0, fmt.Errorf("make slice of Tasks error")
+		return nil, fmt.Errorf("unknown dictionary %s", name)
+	}
+	uri := api.url + path
+	items, found, err := api.getList(ctx, uri, "")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("dictionary %s not found, %s", name, uri)
 	}
-	result := make(domain.ControllerTasks, 0, len(tasks))
-	for _, v := range tasks {
-		json, err := json.Marshal(v)
+	refs, err := decodeDictionary(items, name == domain.SDTaskFields)
+	if err != nil {
+		domain.RequestLogger(ctx, api.log).With(zap.String("target", uri)).Errorf("decodeDictionary error, %v", err)
+		return nil, err
+	}
+	return refs, nil
+}
+
+// getList returns records of all pages of the list from the array field, false if the list isn't found (404),
+// pages are read until the total count of the paginator or the short page
+func (api *ISAPI) getList(ctx context.Context, uri, field string) ([]json.RawMessage, bool, error) {
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	result := make([]json.RawMessage, 0)
+	for page := 1; page <= isListMaxPages; page++ {
+		pageURI := fmt.Sprintf("%s%spage=%d&pagesize=%d", uri, sep, page, isListPageSize)
+		body, found, err := api.getJSON(ctx, pageURI)
+		if err != nil || !found {
+			return nil, found, err
+		}
+		items, count, counted, err := decodeListPage(body, field)
 		if err != nil {
-			continue
+			domain.RequestLogger(ctx, api.log).With(zap.String("target", pageURI)).Errorf(
+				"decodeListPage error, %v", err)
+			return nil, false, err
 		}
-		item := domain.ControllerTask{
-			Task: string(json),
+		result = append(result, items...)
+		if len(items) < isListPageSize || counted && int64(len(result)) >= count {
+			return result, true, nil
 		}
-		result = append(result, item)
 	}
-	return result, int64(count), nil
+	domain.RequestLogger(ctx, api.log).With(zap.String("target", uri)).Warnf("list is truncated to %d records",
+		len(result))
+	return result, true, nil
 }
 
-func (api *ISAPI) TaskAddComment(taskID string, comment string) error {
+// getJSON returns body of the response, false if entity not found (404)
+func (api *ISAPI) getJSON(ctx context.Context, uri string) ([]byte, bool, error) {
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		log.Errorf("make NewRequest error, %v", err)
+		return nil, false, err
+	}
+	request.SetBasicAuth(api.user, api.pass)
+	request.Header.Set("Accept", "application/json")
+	response, err := api.handler.Do(request)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return nil, false, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("ioutil.ReadAll error, %v", err)
+		return nil, false, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return body, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("http response status=%s, code=%d, response=%s",
+		response.Status, response.StatusCode, string(body))
+}
+
+// CreateTask creates task in the Intraservice, returns created task
+func (api *ISAPI) CreateTask(ctx context.Context, task domain.Task) (*domain.Task, error) {
+	uri := fmt.Sprintf("%s/api/task", api.url)
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	params := map[string]interface{}{
+		"Name":        task.Title,
+		"Description": task.Description,
+		"ServiceId":   task.ServiceID,
+		"CreatorId":   task.CreatorID,
+	}
+	for id, value := range task.Fields {
+		params[isCustomFieldPrefix+id] = value
+	}
+	bodyrequest, err := json.Marshal(params)
+	if err != nil {
+		log.Errorf("make body request error, %v", err)
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", uri, bytes.NewReader(bodyrequest))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(api.user, api.pass)
+	response, err := api.handler.Do(request)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("ioutil.ReadAll error, %v", err)
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("http response status=%s, code=%d, response=%s",
+			response.Status, response.StatusCode, string(body))
+	}
+	// Intraservice returns created task as is or wrapped into the Task field
+	wrapped := isFields{}
+	if err = json.Unmarshal(body, &wrapped); err == nil && len(wrapped["Task"]) > 0 {
+		body = wrapped["Task"]
+	}
+	created, err := decodeTask(body, time.Local)
+	if err != nil {
+		log.Errorf("decodeTask error, %v", err)
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (api *ISAPI) TaskAddComment(ctx context.Context, taskID string, comment string) error {
 	uri := fmt.Sprintf("%s/api/task/%s", api.url, taskID)
-	log := api.log.With(zap.String("target", uri))
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
 
 	params := map[string]string{
 		"Comment": comment,
@@ -118,7 +358,7 @@ func (api *ISAPI) TaskAddComment(taskID string, comment string) error {
 	// Set reader from body request
 	r := bytes.NewReader(bodyrequest)
 	// make request
-	request, err := http.NewRequest("PUT", uri, r)
+	request, err := http.NewRequestWithContext(ctx, "PUT", uri, r)
 	if err != nil {
 		return err
 	}
@@ -142,9 +382,9 @@ func (api *ISAPI) TaskAddComment(taskID string, comment string) error {
 	return nil
 }
 
-func (api *ISAPI) TaskSetStatus(taskID string, status domain.TaskStatus) error {
+func (api *ISAPI) TaskSetStatus(ctx context.Context, taskID string, status domain.TaskStatus) error {
 	uri := fmt.Sprintf("%s/api/task/%s", api.url, taskID)
-	log := api.log.With(zap.String("target", uri))
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
 
 	bodyParams := map[string]string{}
 	if status.Comment != "" {
@@ -166,7 +406,7 @@ func (api *ISAPI) TaskSetStatus(taskID string, status domain.TaskStatus) error {
 	// Set reader from body request
 	r := bytes.NewReader(bodyrequest)
 	// make request
-	request, err := http.NewRequest("PUT", uri, r)
+	request, err := http.NewRequestWithContext(ctx, "PUT", uri, r)
 	if err != nil {
 		return err
 	}
@@ -189,9 +429,47 @@ func (api *ISAPI) TaskSetStatus(taskID string, status domain.TaskStatus) error {
 	return nil
 }
 
-func (api *ISAPI) Health() error {
+// ServiceExists returns true if service with id exists in the Intraservice
+func (api *ISAPI) ServiceExists(ctx context.Context, id int64) (bool, error) {
+	return api.exists(ctx, fmt.Sprintf("%s/api/service/%d?fields=Id", api.url, id))
+}
+
+// UserExists returns true if user with id exists in the Intraservice
+func (api *ISAPI) UserExists(ctx context.Context, id int64) (bool, error) {
+	return api.exists(ctx, fmt.Sprintf("%s/api/user/%d?fields=Id", api.url, id))
+}
+
+// exists checks entity by uri, Intraservice returns 404 for unknown id
+func (api *ISAPI) exists(ctx context.Context, uri string) (bool, error) {
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		log.Errorf("make NewRequest error, %v", err)
+		return false, err
+	}
+	request.SetBasicAuth(api.user, api.pass)
+	response, err := api.handler.Do(request)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	txt, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("ioutil.ReadAll error, %v", err)
+	}
+	return false, fmt.Errorf("http response status=%s, code=%d, response=%s", response.Status, response.StatusCode, string(txt))
+}
+
+func (api *ISAPI) Health(ctx context.Context) error {
 	uri := fmt.Sprintf("%s/api/tasktype?fields=Id", api.url)
-	request, err := http.NewRequest("GET", uri, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
 		return err
 	}
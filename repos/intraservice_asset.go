@@ -0,0 +1,89 @@
+package repos
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// isAssetData custom fields of the Intraservice asset in the XML column Data: <data><field id="62">1</field></data>
+type isAssetData struct {
+	Fields []struct {
+		ID    int64  `xml:"id,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"field"`
+}
+
+// decodeAssetFields parses XML Data of the asset, fields of the mapping are converted to their types
+// (value is kept as string if it doesn't match the type), other fields are returned by id as strings
+func decodeAssetFields(data string, mapping domain.AssetFields, loc *time.Location) (map[string]interface{}, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	doc := isAssetData{}
+	if err := xml.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, fmt.Errorf("decode asset data error, %v", err)
+	}
+	names := make(map[int64]domain.AssetField, len(mapping))
+	byID := make(map[int64]string, len(mapping))
+	for name, f := range mapping {
+		names[f.ID], byID[f.ID] = f, name
+	}
+	result := make(map[string]interface{}, len(doc.Fields))
+	for _, f := range doc.Fields {
+		value := strings.TrimSpace(f.Value)
+		name, ok := byID[f.ID]
+		if !ok {
+			result[strconv.FormatInt(f.ID, 10)] = value
+			continue
+		}
+		if value == "" {
+			result[name] = nil
+			continue
+		}
+		result[name] = assetFieldValue(value, names[f.ID].Type, loc)
+	}
+	return result, nil
+}
+
+// assetFieldValue converts value of the custom field to the type
+func assetFieldValue(value, kind string, loc *time.Location) interface{} {
+	switch kind {
+	case domain.AssetFieldInt:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case domain.AssetFieldFloat:
+		if f, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64); err == nil {
+			return f
+		}
+	case domain.AssetFieldDate:
+		if t := parseISTime(value, loc); t != nil {
+			return *t
+		}
+	case domain.AssetFieldBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// assetFieldColumns returns columns of the assets with custom fields of the mapping for filtering: field.name,
+// values are compared as strings, so the query doesn't fail on the values of the wrong type
+func assetFieldColumns(mapping domain.AssetFields) listColumns {
+	cols := listColumns{fields: make(map[string]string, len(assetColumns.fields)+len(mapping)),
+		search: assetColumns.search, key: assetColumns.key, times: assetColumns.times}
+	for name, expr := range assetColumns.fields {
+		cols.fields[name] = expr
+	}
+	for name, f := range mapping {
+		cols.fields[domain.AssetFieldPrefix+name] = fmt.Sprintf(
+			"Data.value('(/data/field[@id=%d])[1]', 'nvarchar(4000)')", f.ID)
+	}
+	return cols
+}
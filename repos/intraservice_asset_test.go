@@ -0,0 +1,67 @@
+package repos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+func TestDecodeAssetFields(t *testing.T) {
+	mapping := domain.AssetFields{
+		"serial":       {ID: 63, Type: domain.AssetFieldString},
+		"floor":        {ID: 64, Type: domain.AssetFieldInt},
+		"square":       {ID: 65, Type: domain.AssetFieldFloat},
+		"install_date": {ID: 66, Type: domain.AssetFieldDate},
+		"active":       {ID: 67, Type: domain.AssetFieldBool},
+	}
+	tCases := []struct {
+		title  string
+		data   string
+		fields map[string]interface{}
+		err    bool
+	}{
+		{title: "typed fields",
+			data: `<data><field id="62">5001</field><field id="63"> A123 </field><field id="64">2</field>` +
+				`<field id="65">12,5</field><field id="66">2021-03-01</field><field id="67">true</field></data>`,
+			fields: map[string]interface{}{"62": "5001", "serial": "A123", "floor": int64(2), "square": 12.5,
+				"install_date": time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), "active": true}},
+		{title: "wrong type and empty value", data: `<data><field id="64">first</field><field id="66"/></data>`,
+			fields: map[string]interface{}{"floor": "first", "install_date": nil}},
+		{title: "empty data"},
+		{title: "broken xml", data: `<data><field id="62">`, err: true},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			fields, err := decodeAssetFields(tc.data, mapping, time.UTC)
+			if (err != nil) != tc.err {
+				t.Fatalf("Expected error %v, but got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) && !(len(fields) == 0 && len(tc.fields) == 0) {
+				t.Errorf("Expected fields %v, but got %v", tc.fields, fields)
+			}
+		})
+	}
+}
+
+func TestAssetFieldColumns(t *testing.T) {
+	cols := assetFieldColumns(domain.AssetFields{"serial": {ID: 63, Type: domain.AssetFieldString}})
+	q := domain.ListQuery{Filters: []domain.Filter{{Field: "field.serial", Op: domain.OpEq, Value: "A123"}}}
+	ls, err := buildListSQL(dialectMSSQL, cols, q, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if !strings.Contains(ls.where, "Data.value('(/data/field[@id=63])[1]', 'nvarchar(4000)') = ?") ||
+		len(ls.args) != 1 || ls.args[0] != "A123" {
+		t.Errorf("Expected condition by field 63, but got %s %v", ls.where, ls.args)
+	}
+	q.Filters[0].Field = "field.model"
+	if _, err = buildListSQL(dialectMSSQL, cols, q, 0); err == nil {
+		t.Errorf("Expected error of not mapped field, but got nil")
+	}
+	if _, ok := assetColumns.fields["field.serial"]; ok {
+		t.Errorf("Expected base columns without custom fields, but got %v", assetColumns.fields)
+	}
+}
@@ -22,14 +22,20 @@ type AssetSQLRepo struct {
 	connString string
 	timeout    time.Duration
 	db         *sql.DB
+	// fields mapping of the asset custom fields, columns - asset columns with the custom fields
+	fields  domain.AssetFields
+	columns listColumns
 }
 
 // NewAssetSQLRepo returns instance of SQLRepo with connected Intraservice DB,
 // format connection string: "server=study-app;user id=transport;password=transport;port=1433;database=CM_Transport523;"
-func NewAssetSQLRepo(connString string, timeout time.Duration) (*AssetSQLRepo, error) {
+// fields - names and types of the asset custom fields
+func NewAssetSQLRepo(connString string, timeout time.Duration, fields domain.AssetFields) (*AssetSQLRepo, error) {
 	asr := &AssetSQLRepo{
 		connString: connString,
 		timeout:    timeout,
+		fields:     fields,
+		columns:    assetFieldColumns(fields),
 	}
 	err := asr.connDB()
 	if err != nil {
@@ -61,82 +67,216 @@ func (asr *AssetSQLRepo) Close() error {
 }
 
 // Health - check connection to DB
-func (asr *AssetSQLRepo) Health() error {
-	ctx, cancel := context.WithTimeout(context.Background(), asr.timeout)
+func (asr *AssetSQLRepo) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, asr.timeout)
 	defer cancel()
 	row := asr.db.QueryRowContext(ctx, "select @@version as [v]")
 	var tmp string
 	return row.Scan(&tmp)
 }
 
+// assetColumns fields of the asset for filtering and sorting
+var assetColumns = listColumns{
+	fields: map[string]string{
+		"id":                  "Data.value('(/data/field[@id=62])[1]', 'int')",
+		"name":                "Name",
+		"serviceDeskParentId": "ParentId",
+		"changed":             "Changed",
+		"serviceDeskId":       "Id",
+	},
+	search: []string{"name"},
+	key:    "serviceDeskId",
+	times:  []string{"changed"},
+}
+
 // FindAll returns slice of assets from Intraservice database
-func (asr *AssetSQLRepo) FindAll(offset, limit int64) (domain.Assets, int64, error) {
-	query := `SELECT Data.value('(/data/field[@id=62])[1]', 'int') as ID, 
-					Name, 
-					ParentId, 
-					Changed, 
-					Id as ServiceDeskID 
-			FROM [dbo].[Asset]
-			WHERE Data.value('(/data/field[@id=62])[1]', 'int') IS NOT NULL
-			AND ParentId IS NOT NULL
-			ORDER BY Id
-			OFFSET ? ROWS 
-			FETCH NEXT ? ROWS ONLY;
-			SELECT count(*) 
-			FROM [dbo].[Asset]
-			WHERE Data.value('(/data/field[@id=62])[1]', 'int') IS NOT NULL
-			AND ParentId IS NOT NULL`
-	ctx, cancel := context.WithTimeout(context.Background(), asr.timeout)
-	defer cancel()
-	rows, err := asr.db.QueryContext(ctx, query, offset, limit)
+func (asr *AssetSQLRepo) FindAll(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.Assets, int64, error) {
+	ls, err := buildListSQL(dialectMSSQL, asr.columns, q, 0)
 	if err != nil {
-		return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+		return nil, -1, err
 	}
-	defer rows.Close()
+	columns := `SELECT Data.value('(/data/field[@id=62])[1]', 'int') as ID,
+					Name,
+					ParentId,
+					Changed,
+					Id as ServiceDeskID,
+					CAST(Data AS nvarchar(max)) as Data`
+	where := `Data.value('(/data/field[@id=62])[1]', 'int') IS NOT NULL
+			AND ParentId IS NOT NULL`
+	ctx, cancel := context.WithTimeout(ctx, asr.timeout)
+	defer cancel()
 	result := make([]domain.Asset, 0, limit)
-	for rows.Next() {
-		item := domain.Asset{}
-		err = rows.Scan(&item.ID, &item.Name, &item.ServiceDeskParentID, &item.Changed, &item.ServiceDeskID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return result, -1, nil
-			}
-			return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
-		}
-		result = append(result, item)
-	}
-	var count int64
-	if rows.NextResultSet() {
-		for rows.Next() {
-			err = rows.Scan(&count)
+	count, err := mssqlPage(ctx, asr.db, columns, "FROM [dbo].[Asset]", where, nil, ls, offset, limit, q.NoCount,
+		func(rows *sql.Rows) error {
+			item, err := asr.scanAsset(rows)
 			if err != nil {
-				if err == sql.ErrNoRows {
-					return result, -1, nil
-				}
-				return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+				return err
 			}
-
-		}
+			result = append(result, item)
+			return nil
+		})
+	if err != nil {
+		return nil, -1, err
 	}
 	return result, count, nil
 }
 
 // FindByID returns single Asset with specified id from Intraservice database
-func (asr *AssetSQLRepo) FindByID(id int64) (*domain.Asset, error) {
+func (asr *AssetSQLRepo) FindByID(ctx context.Context, id int64) (*domain.Asset, error) {
 	query := `SELECT Data.value('(/data/field[@id=62])[1]', 'int') as ID,
 					Name,
 					ParentId as ServiceDeskParentID,
 					Changed,
-					Id as ServiceDeskID 
+					Id as ServiceDeskID,
+					CAST(Data AS nvarchar(max)) as Data
 			FROM [dbo].[Asset]
 			WHERE Data.value('(/data/field[@id=62])[1]', 'int') = ?`
-	ctx, cancel := context.WithTimeout(context.Background(), asr.timeout)
+	ctx, cancel := context.WithTimeout(ctx, asr.timeout)
 	defer cancel()
-	item := domain.Asset{}
-	err := asr.db.QueryRowContext(ctx, query, id).
-		Scan(&item.ID, &item.Name, &item.ServiceDeskParentID, &item.Changed, &item.ServiceDeskID)
+	item, err := asr.scanAsset(asr.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		return nil, fmt.Errorf("query [%s], error %v", query, err)
 	}
 	return &item, nil
 }
+
+// rowScanner common part of the sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(...interface{}) error
+}
+
+// scanAsset reads asset columns: ID, Name, ParentId, Changed, Id, Data
+func (asr *AssetSQLRepo) scanAsset(row rowScanner) (domain.Asset, error) {
+	item := domain.Asset{}
+	var (
+		changed sql.NullTime
+		data    sql.NullString
+	)
+	err := row.Scan(&item.ID, &item.Name, &item.ServiceDeskParentID, &changed, &item.ServiceDeskID, &data)
+	if err != nil {
+		return item, err
+	}
+	item.Changed = dbLocalTime(changed.Time)
+	item.Fields, err = decodeAssetFields(data.String, asr.fields, time.Local)
+	return item, err
+}
+
+// dbLocalTime returns time of the datetime column without zone (driver returns it in UTC)
+// in the local zone, Intraservice database works in the same time zone as the service
+func dbLocalTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// dbTimeLayout format of the datetime parameters, string parameter is compared with datetime column without zone
+const dbTimeLayout string = "2006-01-02T15:04:05.000"
+
+// FindChanged implementation of AssetRepo interface
+func (asr *AssetSQLRepo) FindChanged(ctx context.Context, since time.Time, afterID,
+	limit int64) (domain.Assets, error) {
+	changed := "Changed > ?"
+	args := []interface{}{limit, since.In(time.Local).Format(dbTimeLayout)}
+	if afterID > 0 {
+		changed = "(Changed > ? OR (Changed = ? AND Id > ?))"
+		args = append(args, args[1], afterID)
+	}
+	query := `SELECT TOP (?) Data.value('(/data/field[@id=62])[1]', 'int') as ID,
+					Name,
+					ParentId,
+					Changed,
+					Id as ServiceDeskID,
+					CAST(Data AS nvarchar(max)) as Data
+			FROM [dbo].[Asset]
+			WHERE Data.value('(/data/field[@id=62])[1]', 'int') IS NOT NULL
+				AND ParentId IS NOT NULL
+				AND ` + changed + `
+			ORDER BY Changed, Id`
+	ctx, cancel := context.WithTimeout(ctx, asr.timeout)
+	defer cancel()
+	rows, err := asr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	result := make(domain.Assets, 0, limit)
+	for rows.Next() {
+		item, err := asr.scanAsset(rows)
+		if err != nil {
+			return nil, fmt.Errorf("query [%s], error %v", query, err)
+		}
+		result = append(result, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	return result, nil
+}
+
+// assetTreeSQL recursive walk of the asset hierarchy, %s - anchor condition, %s - join of the next level.
+// Path of the visited ids stops walking on cycles of ParentId.
+const assetTreeSQL = `WITH tree AS (
+		SELECT Id, ParentId, Name, Changed, Data.value('(/data/field[@id=62])[1]', 'int') AS AssetID, 0 AS Depth,
+			CAST(',' + CAST(Id AS varchar(20)) + ',' AS varchar(max)) AS Path, CAST(Data AS nvarchar(max)) AS Data
+		FROM [dbo].[Asset]
+		WHERE %s
+		UNION ALL
+		SELECT a.Id, a.ParentId, a.Name, a.Changed, a.Data.value('(/data/field[@id=62])[1]', 'int'), t.Depth + 1,
+			CAST(t.Path + CAST(a.Id AS varchar(20)) + ',' AS varchar(max)), CAST(a.Data AS nvarchar(max))
+		FROM [dbo].[Asset] a
+		JOIN tree t ON %s
+		WHERE t.Depth < ? AND t.Path NOT LIKE '%%,' + CAST(a.Id AS varchar(20)) + ',%%'
+	)
+	SELECT AssetID, Name, ParentId, Changed, Id, Depth, Data
+	FROM tree
+	ORDER BY Depth, Name, Id
+	OPTION (MAXRECURSION 0)`
+
+// FindDescendants implementation of AssetRepo interface, walks down by recursive CTE
+func (asr *AssetSQLRepo) FindDescendants(ctx context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	if id == 0 {
+		return asr.assetTree(ctx, fmt.Sprintf(assetTreeSQL, "ParentId IS NULL", "a.ParentId = t.Id"), depth)
+	}
+	return asr.assetTree(ctx, fmt.Sprintf(assetTreeSQL, "Id = ?", "a.ParentId = t.Id"), id, depth)
+}
+
+// FindAncestors implementation of AssetRepo interface, walks up by recursive CTE
+func (asr *AssetSQLRepo) FindAncestors(ctx context.Context, id int64, depth int) (domain.AssetNodes, error) {
+	return asr.assetTree(ctx, fmt.Sprintf(assetTreeSQL, "Id = ?", "a.Id = t.ParentId"), id, depth)
+}
+
+// assetTree returns nodes of the hierarchy query
+func (asr *AssetSQLRepo) assetTree(ctx context.Context, query string, args ...interface{}) (domain.AssetNodes, error) {
+	ctx, cancel := context.WithTimeout(ctx, asr.timeout)
+	defer cancel()
+	rows, err := asr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	result := make(domain.AssetNodes, 0)
+	for rows.Next() {
+		var (
+			item     domain.AssetNode
+			assetID  sql.NullInt64
+			parentID sql.NullInt64
+			changed  sql.NullTime
+			data     sql.NullString
+		)
+		err = rows.Scan(&assetID, &item.Name, &parentID, &changed, &item.ServiceDeskID, &item.Depth, &data)
+		if err != nil {
+			return nil, fmt.Errorf("scan asset node error, %v", err)
+		}
+		item.ID, item.ServiceDeskParentID, item.Changed = assetID.Int64, parentID.Int64, dbLocalTime(changed.Time)
+		if item.Fields, err = decodeAssetFields(data.String, asr.fields, time.Local); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,197 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+
+	"go.uber.org/zap"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// files of the tasks are served by the Intraservice api:
+// GET /api/taskfile?taskid=1 - list, GET /api/taskfile/2?taskid=1 - content,
+// POST /api/taskfile?taskid=1 - multipart upload with the field file
+
+// TaskFiles returns files attached to the task
+func (api *ISAPI) TaskFiles(ctx context.Context, taskID int64) (domain.TaskFiles, error) {
+	uri := fmt.Sprintf("%s/api/taskfile?taskid=%d", api.url, taskID)
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		log.Errorf("make NewRequest error, %v", err)
+		return nil, err
+	}
+	request.SetBasicAuth(api.user, api.pass)
+	request.Header.Set("Accept", "application/json")
+	response, err := api.handler.Do(request)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("ioutil.ReadAll error, %v", err)
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response status=%s, code=%d, response=%s",
+			response.Status, response.StatusCode, string(body))
+	}
+	page := struct {
+		Files []json.RawMessage `json:"Files"`
+	}{}
+	if err = json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("decode task files error, %v", err)
+	}
+	result := make(domain.TaskFiles, 0, len(page.Files))
+	for _, raw := range page.Files {
+		file, err := decodeTaskFile(raw, taskID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+// TaskFile returns file of the task with content, nil if file not found
+func (api *ISAPI) TaskFile(ctx context.Context, taskID, fileID int64) (*domain.TaskFile, io.ReadCloser, error) {
+	uri := fmt.Sprintf("%s/api/taskfile/%d?taskid=%d", api.url, fileID, taskID)
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		log.Errorf("make NewRequest error, %v", err)
+		return nil, nil, err
+	}
+	request.SetBasicAuth(api.user, api.pass)
+	// the content is streamed to the client, so it can take longer than timeout of the api
+	response, err := api.files.Do(request)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return nil, nil, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, nil, nil
+	default:
+		defer response.Body.Close()
+		txt, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			log.Errorf("ioutil.ReadAll error, %v", err)
+		}
+		return nil, nil, fmt.Errorf("http response status=%s, code=%d, response=%s",
+			response.Status, response.StatusCode, string(txt))
+	}
+	file := &domain.TaskFile{
+		ID:          fileID,
+		TaskID:      taskID,
+		Size:        response.ContentLength,
+		ContentType: response.Header.Get("Content-Type"),
+	}
+	if _, params, err := mime.ParseMediaType(response.Header.Get("Content-Disposition")); err == nil {
+		file.Name = params["filename"]
+	}
+	return file, response.Body, nil
+}
+
+// AttachTaskFile streams content to the Intraservice as multipart form without buffering of the file
+func (api *ISAPI) AttachTaskFile(ctx context.Context, taskID int64, name, contentType string,
+	content io.Reader) (*domain.TaskFile, error) {
+	uri := fmt.Sprintf("%s/api/taskfile?taskid=%d", api.url, taskID)
+	log := domain.RequestLogger(ctx, api.log).With(zap.String("target", uri))
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(name)))
+		header.Set("Content-Type", contentType)
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	request, err := http.NewRequestWithContext(ctx, "POST", uri, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		log.Errorf("make NewRequest error, %v", err)
+		return nil, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(api.user, api.pass)
+	response, err := api.files.Do(request)
+	// stops the writer if the request failed before the whole content was sent
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		log.Errorf("get response error, %v", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("ioutil.ReadAll error, %v", err)
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("http response status=%s, code=%d, response=%s",
+			response.Status, response.StatusCode, string(body))
+	}
+	// Intraservice returns uploaded file as is or wrapped into the File field
+	wrapped := isFields{}
+	if err = json.Unmarshal(body, &wrapped); err == nil && len(wrapped["File"]) > 0 {
+		body = wrapped["File"]
+	}
+	file, err := decodeTaskFile(body, taskID)
+	if err != nil {
+		log.Errorf("decodeTaskFile error, %v", err)
+		return nil, err
+	}
+	if file.Name == "" {
+		file.Name = name
+	}
+	if file.ContentType == "" {
+		file.ContentType = contentType
+	}
+	return &file, nil
+}
+
+// decodeTaskFile tolerant decoder of the Intraservice file of the task
+func decodeTaskFile(raw json.RawMessage, taskID int64) (domain.TaskFile, error) {
+	f := isFields{}
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return domain.TaskFile{}, fmt.Errorf("decode task file error, %v", err)
+	}
+	file := domain.TaskFile{TaskID: taskID}
+	file.ID, _ = f.int64("Id")
+	file.Name = f.string("Name")
+	file.Size, _ = f.int64("Size")
+	file.ContentType = f.string("ContentType")
+	file.Created = f.time("Created", time.Local)
+	if id, ok := f.int64("TaskId"); ok {
+		file.TaskID = id
+	}
+	if file.ID == 0 {
+		return file, fmt.Errorf("decode task file error, empty Id in %s", string(raw))
+	}
+	return file, nil
+}
@@ -0,0 +1,102 @@
+package repos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newISAPIFileStub Intraservice file api stand-in with files of the task 1 in memory
+func newISAPIFileStub(t *testing.T, files map[int64][]byte) *ISAPI {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("taskid") != "1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/taskfile":
+			fmt.Fprint(w, `{"Files":[`)
+			for id := int64(1); id <= int64(len(files)); id++ {
+				if id > 1 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `{"Id":"%d","Name":"photo%d.jpg","Size":%d,"Created":"2021-03-01 10:00:00"}`,
+					id, id, len(files[id]))
+			}
+			fmt.Fprint(w, `]}`)
+		case r.Method == http.MethodGet:
+			var id int64
+			if _, err := fmt.Sscanf(r.URL.Path, "/api/taskfile/%d", &id); err != nil || files[id] == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="photo%d.jpg"`, id))
+			_, _ = w.Write(files[id])
+		case r.Method == http.MethodPost:
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			content, _ := ioutil.ReadAll(file)
+			id := int64(len(files) + 1)
+			files[id] = content
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"File":{"Id":%d,"Name":%q,"Size":%d,"ContentType":%q}}`,
+				id, header.Filename, len(content), header.Header.Get("Content-Type"))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+}
+
+func TestISAPITaskFiles(t *testing.T) {
+	files := map[int64][]byte{1: []byte("first")}
+	api := newISAPIFileStub(t, files)
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("v"), 1<<20)
+	file, err := api.AttachTaskFile(ctx, 1, `clip "1".mp4`, "video/mp4", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Expected success AttachTaskFile, but error %v", err)
+	}
+	if file.ID != 2 || file.Name != `clip "1".mp4` || file.Size != int64(len(content)) ||
+		file.ContentType != "video/mp4" {
+		t.Errorf("Expected uploaded file 2 with name, size and type, but got %+v", *file)
+	}
+	if !bytes.Equal(files[2], content) {
+		t.Errorf("Expected %d bytes in the stand-in, but got %d", len(content), len(files[2]))
+	}
+	list, err := api.TaskFiles(ctx, 1)
+	if err != nil {
+		t.Fatalf("Expected success TaskFiles, but error %v", err)
+	}
+	if len(list) != 2 || list[0].ID != 1 || list[0].TaskID != 1 || list[0].Created == nil {
+		t.Errorf("Expected 2 files with first file 1, but got %+v", list)
+	}
+	info, body, err := api.TaskFile(ctx, 1, 1)
+	if err != nil || info == nil {
+		t.Fatalf("Expected file 1, but got %v, error %v", info, err)
+	}
+	defer body.Close()
+	got, _ := ioutil.ReadAll(body)
+	if string(got) != "first" || info.Name != "photo1.jpg" || info.ContentType != "image/jpeg" {
+		t.Errorf("Expected content first of photo1.jpg, but got %s of %+v", string(got), *info)
+	}
+	info, _, err = api.TaskFile(ctx, 1, 9)
+	if err != nil || info != nil {
+		t.Errorf("Expected not found file 9, but got %v, error %v", info, err)
+	}
+	if _, err = api.TaskFiles(ctx, 2); err == nil {
+		t.Errorf("Expected error of unknown task, but got nil")
+	}
+}
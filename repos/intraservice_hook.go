@@ -0,0 +1,108 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// reTaskTitleSN serial number of the controller in the title of the task created by commonapi: [category] SN
+var reTaskTitleSN = regexp.MustCompile(`^\[[^\]]+\]\s+(\S+)`)
+
+// ParseIntraserviceHook normalizes notification of the Intraservice about changed task into events.
+// Notification is the task (as is or in the Task field) with optional fields EventType, OldStatusId,
+// Comment, Editor and Date. Without EventType events are detected by fields: new task, changed status, comment.
+// SN of the controller is taken from the custom field snField or from the title of the task,
+// event id is stable, so repeated notification gives the same events.
+func ParseIntraserviceHook(body []byte, snField string, loc *time.Location) (domain.TaskEvents, error) {
+	hook := isFields{}
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return nil, fmt.Errorf("decode hook error, %v", err)
+	}
+	raw := json.RawMessage(body)
+	if len(hook["Task"]) > 0 {
+		raw = hook["Task"]
+	}
+	task, err := decodeTask(raw, loc)
+	if err != nil {
+		return nil, err
+	}
+	base := domain.TaskEvent{TaskID: task.ID, StatusID: task.StatusID, Status: task.Status,
+		Author: firstString(hook, "Editor", "EditorName")}
+	base.SN = task.Fields[snField]
+	if base.SN == "" {
+		if m := reTaskTitleSN.FindStringSubmatch(task.Title); m != nil {
+			base.SN = m[1]
+		}
+	}
+	if ids := hook.ids("AssetIds"); len(ids) > 0 {
+		base.AssetID = ids[0]
+	} else if ids = taskFields(raw).ids("AssetIds"); len(ids) > 0 {
+		base.AssetID = ids[0]
+	}
+	switch {
+	case hook.time("Date", loc) != nil:
+		base.Occurred = *hook.time("Date", loc)
+	case task.Changed != nil:
+		base.Occurred = *task.Changed
+	case task.Created != nil:
+		base.Occurred = *task.Created
+	default:
+		base.Occurred = time.Now()
+	}
+	prevStatus, hasPrev := hook.int64("OldStatusId")
+	comment := strings.TrimSpace(firstString(hook, "Comment", "Comments"))
+	types := make([]string, 0, 3)
+	switch kind := strings.ToLower(firstString(hook, "EventType", "Event")); {
+	case strings.Contains(kind, "creat"):
+		types = append(types, domain.EventTaskCreated)
+	case strings.Contains(kind, "status"):
+		types = append(types, domain.EventTaskStatusChanged)
+	case strings.Contains(kind, "comment"):
+		types = append(types, domain.EventTaskCommentAdded)
+	default:
+		if hook.bool("IsNew") || (task.Created != nil && task.Changed != nil && task.Created.Equal(*task.Changed)) {
+			types = append(types, domain.EventTaskCreated)
+		} else if hasPrev && prevStatus != task.StatusID {
+			types = append(types, domain.EventTaskStatusChanged)
+		}
+		if comment != "" {
+			types = append(types, domain.EventTaskCommentAdded)
+		}
+	}
+	result := make(domain.TaskEvents, 0, len(types))
+	for _, t := range types {
+		event := base
+		event.Type = t
+		event.ID = fmt.Sprintf("%d-%s-%d", task.ID, t, event.Occurred.UnixNano())
+		switch t {
+		case domain.EventTaskStatusChanged:
+			event.PrevStatusID = prevStatus
+		case domain.EventTaskCommentAdded:
+			event.Comment = comment
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// firstString returns the first not empty field
+func firstString(f isFields, names ...string) string {
+	for _, name := range names {
+		if value := f.string(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// taskFields raw fields of the task, empty map if task isn't object
+func taskFields(raw json.RawMessage) isFields {
+	f := isFields{}
+	_ = json.Unmarshal(raw, &f)
+	return f
+}
@@ -0,0 +1,141 @@
+package repos
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+func TestParseIntraserviceHook(t *testing.T) {
+	tCases := []struct {
+		title   string
+		body    string
+		snField string
+		types   []string
+		sn      string
+		prev    int64
+		comment string
+	}{
+		{title: "created by dates",
+			body: `{"Id":1,"Name":"[offline] S1","StatusId":27,` +
+				`"Created":"2021-03-01 10:00:00","Changed":"2021-03-01 10:00:00"}`,
+			types: []string{domain.EventTaskCreated}, sn: "S1"},
+		{title: "status changed in wrapped task",
+			body: `{"Task":{"Id":1,"Name":"[offline] S1","StatusId":29,"Changed":"2021-03-02 10:00:00"},` +
+				`"OldStatusId":27,"Editor":"support"}`,
+			types: []string{domain.EventTaskStatusChanged}, sn: "S1", prev: 27},
+		{title: "status and comment",
+			body: `{"Id":1,"Name":"no sn","StatusId":29,"Field1105":"S2","OldStatusId":"27",` +
+				`"Comment":" done "}`,
+			snField: "1105", types: []string{domain.EventTaskStatusChanged, domain.EventTaskCommentAdded},
+			sn: "S2", prev: 27, comment: "done"},
+		{title: "explicit type",
+			body:  `{"Id":1,"Name":"[video] S3","StatusId":29,"EventType":"CommentAdded","Comment":"ok"}`,
+			types: []string{domain.EventTaskCommentAdded}, sn: "S3", comment: "ok"},
+		{title: "nothing changed", body: `{"Id":1,"Name":"[video] S3","StatusId":29,"OldStatusId":29}`, sn: "S3"},
+		{title: "without id", body: `{"Name":"[video] S3"}`},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			events, err := ParseIntraserviceHook([]byte(tc.body), tc.snField, time.UTC)
+			if tc.title == "without id" {
+				if err == nil {
+					t.Errorf("Expected error, but got %v", events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if len(events) != len(tc.types) {
+				t.Fatalf("Expected %d events, but got %v", len(tc.types), events)
+			}
+			for i, e := range events {
+				if e.Type != tc.types[i] || e.TaskID != 1 || e.SN != tc.sn {
+					t.Errorf("Expected %s event of the task 1 with SN %s, but got %+v", tc.types[i], tc.sn, e)
+				}
+				if e.Type == domain.EventTaskStatusChanged && e.PrevStatusID != tc.prev {
+					t.Errorf("Expected previous status %d, but got %d", tc.prev, e.PrevStatusID)
+				}
+				if e.Type == domain.EventTaskCommentAdded && e.Comment != tc.comment {
+					t.Errorf("Expected comment %q, but got %q", tc.comment, e.Comment)
+				}
+			}
+			// id of the repeated notification is the same if it has date of the change
+			again, _ := ParseIntraserviceHook([]byte(tc.body), tc.snField, time.UTC)
+			if len(events) > 0 && strings.Contains(tc.body, "Changed") && again[0].ID != events[0].ID {
+				t.Errorf("Expected stable event id %s, but got %s", events[0].ID, again[0].ID)
+			}
+		})
+	}
+}
+
+func TestFileOutbox(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	ob, err := NewFileOutbox(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	now := time.Now()
+	messages := []domain.OutboxMessage{
+		{Subscriber: "a", Event: domain.TaskEvent{ID: "1"}, NextAttempt: now},
+		{Subscriber: "b", Event: domain.TaskEvent{ID: "1"}, NextAttempt: now.Add(time.Hour)},
+		{Subscriber: "c", Event: domain.TaskEvent{ID: "1"}, NextAttempt: now},
+	}
+	if queued, err := ob.Enqueue(ctx, messages); err != nil || queued != 3 {
+		t.Fatalf("Expected 3 queued messages, but got %d, error %v", queued, err)
+	}
+	if messages[0].ID != 1 || messages[2].ID != 3 {
+		t.Errorf("Expected ids 1..3, but got %d, %d", messages[0].ID, messages[2].ID)
+	}
+	delivered := now
+	messages[0].Delivered = &delivered
+	messages[2].Attempts, messages[2].LastError = 1, "timeout"
+	if err = ob.Update(ctx, []domain.OutboxMessage{messages[0], messages[2]}); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	// reopened outbox keeps not delivered messages and the sequence of ids
+	ob, err = NewFileOutbox(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	pending, _ := ob.Pending(ctx, now, 10)
+	if len(pending) != 1 || pending[0].ID != 3 || pending[0].LastError != "timeout" {
+		t.Errorf("Expected pending message 3 after error, but got %+v", pending)
+	}
+	pending, _ = ob.Pending(ctx, now.Add(2*time.Hour), 10)
+	if len(pending) != 2 || pending[0].ID != 2 {
+		t.Errorf("Expected pending messages 2, 3, but got %+v", pending)
+	}
+	// repeated event is skipped for the subscribers, even if it's delivered
+	next := []domain.OutboxMessage{
+		{Subscriber: "a", Event: domain.TaskEvent{ID: "1"}, NextAttempt: now},
+		{Subscriber: "a", Event: domain.TaskEvent{ID: "2"}, NextAttempt: now},
+		{Subscriber: "a", Event: domain.TaskEvent{ID: "2"}, NextAttempt: now},
+	}
+	if queued, err := ob.Enqueue(ctx, next); err != nil || queued != 1 || next[0].ID != 0 || next[1].ID != 4 {
+		t.Errorf("Expected one queued message 4, but got %d, %+v, %v", queued, next, err)
+	}
+	if err = ob.Update(ctx, []domain.OutboxMessage{{ID: 100}}); err == nil {
+		t.Errorf("Expected error of unknown message, but got nil")
+	}
+	// delivered and dead messages are removed after the retention
+	expired := now.Add(-2 * time.Hour)
+	messages[0].Delivered = &expired
+	messages[2].Dead, messages[2].NextAttempt = true, expired
+	if err = ob.Update(ctx, []domain.OutboxMessage{messages[0], messages[2]}); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	again := []domain.OutboxMessage{{Subscriber: "a", Event: domain.TaskEvent{ID: "1"}, NextAttempt: now}}
+	if queued, _ := ob.Enqueue(ctx, again); queued != 1 || again[0].ID != 5 {
+		t.Errorf("Expected queued message 5 of the event after the retention, but got %+v", again[0])
+	}
+	if ob, err = NewFileOutbox(path, time.Hour); err != nil || len(ob.messages) != 3 {
+		t.Errorf("Expected messages 2, 4, 5 in the file, but got %+v, %v", ob.messages, err)
+	}
+}
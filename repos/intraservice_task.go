@@ -0,0 +1,275 @@
+package repos
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// prefix of the custom fields of the Intraservice task: Field1101
+const isCustomFieldPrefix string = "Field"
+
+// isTimeLayouts formats of the Intraservice dates, dates without zone are in the Intraservice server time zone
+var isTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"2006-01-02",
+}
+
+// isTasksPage page of the tasks of the Intraservice api /api/task
+type isTasksPage struct {
+	Tasks     []json.RawMessage          `json:"Tasks"`
+	Paginator map[string]json.RawMessage `json:"Paginator"`
+}
+
+// decodeTasksPage decodes page of the tasks and total count, count of the page used if paginator is absent
+func decodeTasksPage(body []byte, loc *time.Location) (domain.Tasks, int64, error) {
+	page := isTasksPage{}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, 0, fmt.Errorf("decode tasks page error, %v", err)
+	}
+	result := make(domain.Tasks, 0, len(page.Tasks))
+	for _, raw := range page.Tasks {
+		task, err := decodeTask(raw, loc)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, task)
+	}
+	count, ok := isFields(page.Paginator).int64("Count")
+	if !ok {
+		count = int64(len(result))
+	}
+	return result, count, nil
+}
+
+// decodeTask tolerant decoder of the Intraservice task: numbers can be strings, dates can be in any
+// of isTimeLayouts, unknown fields are ignored, custom fields FieldNNN are collected to the Fields
+func decodeTask(raw json.RawMessage, loc *time.Location) (domain.Task, error) {
+	f := isFields{}
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return domain.Task{}, fmt.Errorf("decode task error, %v", err)
+	}
+	task := domain.Task{Raw: raw}
+	task.ID, _ = f.int64("Id")
+	task.ParentID, _ = f.int64("ParentId")
+	task.Title = f.string("Name")
+	task.Description = f.string("Description")
+	task.StatusID, _ = f.int64("StatusId")
+	task.Status = f.string("StatusName")
+	task.PriorityID, _ = f.int64("PriorityId")
+	task.Priority = f.string("PriorityName")
+	task.ServiceID, _ = f.int64("ServiceId")
+	task.Service = f.string("ServiceName")
+	task.TypeID, _ = f.int64("TypeId")
+	task.CreatorID, _ = f.int64("CreatorId")
+	task.Creator = f.string("Creator")
+	task.ExecutorIDs = f.ids("ExecutorIds")
+	task.Executors = f.string("Executors")
+	task.Created = f.time("Created", loc)
+	task.Changed = f.time("Changed", loc)
+	task.Deadline = f.time("Deadline", loc)
+	task.Closed = f.time("Closed", loc)
+	for name := range f {
+		id := strings.TrimPrefix(name, isCustomFieldPrefix)
+		if id == name {
+			continue
+		}
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			continue
+		}
+		if value := f.string(name); value != "" {
+			if task.Fields == nil {
+				task.Fields = make(map[string]string)
+			}
+			task.Fields[id] = value
+		}
+	}
+	if task.ID == 0 {
+		return task, fmt.Errorf("decode task error, empty Id in %s", string(raw))
+	}
+	return task, nil
+}
+
+// decodeListPage decodes records of the page of the Intraservice list and total count of the records,
+// records are in the array field (any field except Paginator if field is empty) or the body is the array
+func decodeListPage(body []byte, field string) ([]json.RawMessage, int64, bool, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err == nil {
+		return items, 0, false, nil
+	}
+	wrapped := isFields{}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, 0, false, fmt.Errorf("decode list page error, %v", err)
+	}
+	for name, raw := range wrapped {
+		if (name == field || field == "" && name != "Paginator") && json.Unmarshal(raw, &items) == nil {
+			break
+		}
+	}
+	paginator := isFields{}
+	if raw, ok := wrapped["Paginator"]; ok && json.Unmarshal(raw, &paginator) != nil {
+		return nil, 0, false, fmt.Errorf("decode list paginator error, %s", string(raw))
+	}
+	count, ok := paginator.int64("Count")
+	return items, count, ok, nil
+}
+
+// decodeTaskLifetime decodes history of the task, records with comment are comments,
+// records with status are transitions from the status of the previous transition
+func decodeTaskLifetime(items []json.RawMessage, taskID int64, loc *time.Location) (*domain.TaskLifetime, error) {
+	records := make([]isFields, 0, len(items))
+	for _, raw := range items {
+		f := isFields{}
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, fmt.Errorf("decode task lifetime error, %v", err)
+		}
+		records = append(records, f)
+	}
+	// history is processed from old to new records
+	sort.SliceStable(records, func(i, j int) bool {
+		di, dj := records[i].time("Date", loc), records[j].time("Date", loc)
+		if di != nil && dj != nil && !di.Equal(*dj) {
+			return di.Before(*dj)
+		}
+		idi, _ := records[i].int64("Id")
+		idj, _ := records[j].int64("Id")
+		return idi < idj
+	})
+	result := &domain.TaskLifetime{TaskID: taskID, Comments: make([]domain.TaskNote, 0),
+		Statuses: make([]domain.TaskStatusChange, 0)}
+	var prevID int64
+	var prevName string
+	for _, f := range records {
+		id, _ := f.int64("Id")
+		authorID, _ := f.int64("EditorId")
+		author, date := f.string("Editor"), f.time("Date", loc)
+		if text := strings.TrimSpace(f.string("Comments")); text != "" {
+			result.Comments = append(result.Comments, domain.TaskNote{ID: id, AuthorID: authorID, Author: author,
+				Date: date, Private: f.bool("IsPrivateComment"), Text: text})
+		}
+		statusID, ok := f.int64("StatusId")
+		if !ok || statusID == prevID {
+			continue
+		}
+		change := domain.TaskStatusChange{ID: id, AuthorID: authorID, Author: author, Date: date,
+			FromStatusID: prevID, FromStatus: prevName, ToStatusID: statusID, ToStatus: f.string("StatusName")}
+		if oldID, ok := f.int64("OldStatusId"); ok {
+			change.FromStatusID, change.FromStatus = oldID, f.string("OldStatusName")
+		}
+		result.Statuses = append(result.Statuses, change)
+		prevID, prevName = statusID, change.ToStatus
+	}
+	return result, nil
+}
+
+// decodeDictionary decodes items of the dictionary, custom fields get key of the task field
+func decodeDictionary(items []json.RawMessage, fields bool) (domain.SDReferences, error) {
+	result := make(domain.SDReferences, 0, len(items))
+	for _, raw := range items {
+		f := isFields{}
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, fmt.Errorf("decode dictionary item error, %v", err)
+		}
+		ref := domain.SDReference{Name: f.string("Name"), Type: f.string("Type"), IsArchived: f.bool("IsArchive")}
+		ref.ID, _ = f.int64("Id")
+		ref.ParentID, _ = f.int64("ParentId")
+		if ref.ID == 0 {
+			return nil, fmt.Errorf("decode dictionary item error, empty Id in %s", string(raw))
+		}
+		if fields {
+			ref.Key = isCustomFieldPrefix + strconv.FormatInt(ref.ID, 10)
+		}
+		result = append(result, ref)
+	}
+	return result, nil
+}
+
+// isFields raw fields of the Intraservice entity, values can be numbers, strings or null
+type isFields map[string]json.RawMessage
+
+// string returns string value of the field, numbers and booleans are formatted
+func (f isFields) string(name string) string {
+	raw, ok := f[name]
+	if !ok {
+		return ""
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+	raw = bytes.TrimSpace(raw)
+	if bytes.Equal(raw, []byte("null")) {
+		return ""
+	}
+	return string(raw)
+}
+
+// bool returns boolean value of the boolean, numeric or string field
+func (f isFields) bool(name string) bool {
+	b, err := strconv.ParseBool(strings.TrimSpace(f.string(name)))
+	return err == nil && b
+}
+
+// int64 returns integer value of the number or numeric string field, false if field is absent or not a number
+func (f isFields) int64(name string) (int64, bool) {
+	value := strings.TrimSpace(f.string(name))
+	if value == "" {
+		return 0, false
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, true
+	}
+	if fl, err := strconv.ParseFloat(value, 64); err == nil {
+		return int64(fl), true
+	}
+	return 0, false
+}
+
+// ids returns list of ids from comma separated string or array field
+func (f isFields) ids(name string) []int64 {
+	result := make([]int64, 0)
+	var list []json.RawMessage
+	if err := json.Unmarshal(f[name], &list); err == nil {
+		for i := range list {
+			if id, ok := (isFields{"id": list[i]}).int64("id"); ok {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+	for _, part := range strings.Split(f.string(name), ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// time returns date of the field in any of isTimeLayouts, nil if field is empty or has unknown format
+func (f isFields) time(name string, loc *time.Location) *time.Time {
+	return parseISTime(f.string(name), loc)
+}
+
+// parseISTime returns date in any of isTimeLayouts, nil if value is empty or has unknown format
+func parseISTime(value string, loc *time.Location) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	for _, layout := range isTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+	"go.uber.org/zap"
+)
+
+// newISAPIStub Intraservice api stand-in, responds with body to the /api/task
+func newISAPIStub(t *testing.T, status int, body string) *ISAPI {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/task" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+}
+
+func TestISAPIFindAllBySN(t *testing.T) {
+	tCases := []struct {
+		title     string
+		status    int
+		body      string
+		count     int64
+		ids       []int64
+		executors int
+		fields    map[string]string
+		closed    bool
+		isErr     bool
+	}{
+		{title: "numbers and paginator", status: http.StatusOK,
+			body: `{"Tasks":[{"Id":101,"Name":"camera","StatusId":31,"ExecutorIds":"5, 7",` +
+				`"Created":"2021-03-01T10:20:30","Closed":null,"Field1101":"1234"}],"Paginator":{"Count":12}}`,
+			count: 12, ids: []int64{101}, executors: 2, fields: map[string]string{"1101": "1234"}},
+		{title: "string ids without paginator", status: http.StatusOK,
+			body: `{"Tasks":[{"Id":"102","ExecutorIds":[8],"Closed":"02.03.2021 11:00","Field1102":5},` +
+				`{"Id":"103.0","Unknown":{"a":1}}]}`,
+			count: 2, ids: []int64{102, 103}, executors: 1, fields: map[string]string{"1102": "5"}, closed: true},
+		{title: "empty page", status: http.StatusOK, body: `{"Tasks":[],"Paginator":{"Count":"0"}}`,
+			ids: []int64{}},
+		{title: "task without id", status: http.StatusOK, body: `{"Tasks":[{"Name":"x"}]}`, isErr: true},
+		{title: "server error", status: http.StatusInternalServerError, body: `{}`, isErr: true},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			api := newISAPIStub(t, tc.status, tc.body)
+			tasks, count, err := api.FindAllBySN(context.Background(), "S1", domain.TaskFilter{}, 0, 10)
+			if tc.isErr {
+				if err == nil {
+					t.Errorf("Expected error, but got %d tasks", len(tasks))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected success FindAllBySN, but error %v", err)
+			}
+			if count != tc.count {
+				t.Errorf("Expected count %d, but got %d", tc.count, count)
+			}
+			if len(tasks) != len(tc.ids) {
+				t.Fatalf("Expected %d tasks, but got %d", len(tc.ids), len(tasks))
+			}
+			for i, id := range tc.ids {
+				if tasks[i].ID != id {
+					t.Errorf("Expected task id %d, but got %d", id, tasks[i].ID)
+				}
+				if len(tasks[i].Raw) == 0 {
+					t.Errorf("Expected raw json of the task %d, but it's empty", id)
+				}
+			}
+			if len(tasks) == 0 {
+				return
+			}
+			if len(tasks[0].ExecutorIDs) != tc.executors {
+				t.Errorf("Expected %d executors, but got %v", tc.executors, tasks[0].ExecutorIDs)
+			}
+			for k, v := range tc.fields {
+				if tasks[0].Fields[k] != v {
+					t.Errorf("Expected field %s=%s, but got %s", k, v, tasks[0].Fields[k])
+				}
+			}
+			if (tasks[0].Closed != nil) != tc.closed {
+				t.Errorf("Expected closed %v, but got %v", tc.closed, tasks[0].Closed)
+			}
+		})
+	}
+}
+
+func TestISAPICreateTask(t *testing.T) {
+	var params map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/task" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"Task":{"Id":"501","Name":%q,"ServiceId":10,"Field1105":%q}}`,
+			params["Name"], params["Field1105"])
+	}))
+	defer srv.Close()
+	api := NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+	task, err := api.CreateTask(context.Background(), domain.Task{Title: "[offline] S1", ServiceID: 10, CreatorID: 20,
+		Fields: map[string]string{"1105": "support"}})
+	if err != nil {
+		t.Fatalf("Expected success CreateTask, but error %v", err)
+	}
+	if task.ID != 501 || task.Title != "[offline] S1" || task.Fields["1105"] != "support" {
+		t.Errorf("Expected created task 501 with destination, but got %+v", *task)
+	}
+	if params["CreatorId"] != float64(20) {
+		t.Errorf("Expected CreatorId 20 in request, but got %v", params["CreatorId"])
+	}
+}
+
+func TestISAPIFindAllBySNPaging(t *testing.T) {
+	const total = 25
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		page, _ := strconv.Atoi(query.Get("page"))
+		size, _ := strconv.Atoi(query.Get("pagesize"))
+		tasks := make([]string, 0, size)
+		for id := (page-1)*size + 1; id <= page*size && id <= total; id++ {
+			tasks = append(tasks, fmt.Sprintf(`{"Id":%d}`, id))
+		}
+		fmt.Fprintf(w, `{"Tasks":[%s],"Paginator":{"Count":%d}}`, strings.Join(tasks, ","), total)
+	}))
+	defer srv.Close()
+	api := NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+	tCases := []struct {
+		title  string
+		offset int64
+		limit  int64
+		first  int64
+		count  int
+	}{
+		{title: "first page", offset: 0, limit: 10, first: 1, count: 10},
+		{title: "aligned offset", offset: 10, limit: 10, first: 11, count: 10},
+		{title: "unaligned offset", offset: 5, limit: 10, first: 6, count: 10},
+		{title: "unaligned tail", offset: 18, limit: 10, first: 19, count: 7},
+		{title: "beyond total", offset: 30, limit: 10, count: 0},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			tasks, count, err := api.FindAllBySN(context.Background(), "SN 1&2",
+				domain.TaskFilter{StatusIDs: []int64{31, 32}, CreatedFrom: &from}, tc.offset, tc.limit)
+			if err != nil {
+				t.Fatalf("Expected success FindAllBySN, but error %v", err)
+			}
+			if count != total {
+				t.Errorf("Expected total %d, but got %d", total, count)
+			}
+			if len(tasks) != tc.count {
+				t.Fatalf("Expected %d tasks, but got %d", tc.count, len(tasks))
+			}
+			for i := range tasks {
+				if tasks[i].ID != tc.first+int64(i) {
+					t.Errorf("Expected task id %d, but got %d", tc.first+int64(i), tasks[i].ID)
+				}
+			}
+			if query.Get("search") != "SN 1&2" || query.Get("statusids") != "31,32" ||
+				query.Get("createdmoreorequal") != "2021-03-01T00:00:00" {
+				t.Errorf("Expected escaped search and filters, but got %v", query)
+			}
+		})
+	}
+}
+
+func TestISAPITaskLifetime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/task/7":
+			fmt.Fprint(w, `{"Task":{"Id":7,"Name":"[offline] S1","StatusId":"31"}}`)
+		case r.URL.Path == "/api/tasklifetime" && r.URL.Query().Get("taskid") == "7":
+			// records are not ordered by date
+			fmt.Fprint(w, `{"TaskLifetimes":[
+				{"Id":3,"Date":"2021-03-02T09:00:00","Editor":"Engineer","EditorId":5,"StatusId":29,
+					"StatusName":"Closed","Comments":"replaced","IsPrivateComment":"true"},
+				{"Id":1,"Date":"2021-03-01T10:00:00","Editor":"commonapi","EditorId":20,"StatusId":31,
+					"StatusName":"Open","Comments":"no data"},
+				{"Id":2,"Date":"2021-03-01T11:00:00","Editor":"Engineer","EditorId":5,"StatusId":31,
+					"Comments":"","IsPrivateComment":false}
+			]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	api := NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+	ctx := context.Background()
+	task, err := api.FindTaskByID(ctx, 7)
+	if err != nil || task == nil || task.StatusID != 31 {
+		t.Fatalf("Expected task 7 with status 31, but got %+v, error %v", task, err)
+	}
+	if task, err = api.FindTaskByID(ctx, 8); err != nil || task != nil {
+		t.Errorf("Expected not found task 8, but got %+v, error %v", task, err)
+	}
+	lifetime, err := api.TaskLifetime(ctx, 7)
+	if err != nil || lifetime == nil {
+		t.Fatalf("Expected lifetime of task 7, but got %+v, error %v", lifetime, err)
+	}
+	if len(lifetime.Comments) != 2 || lifetime.Comments[0].Text != "no data" || lifetime.Comments[0].Private ||
+		!lifetime.Comments[1].Private || lifetime.Comments[1].AuthorID != 5 {
+		t.Errorf("Expected public and private comments in order of date, but got %+v", lifetime.Comments)
+	}
+	if len(lifetime.Statuses) != 2 {
+		t.Fatalf("Expected 2 status transitions, but got %+v", lifetime.Statuses)
+	}
+	if last := lifetime.Statuses[1]; last.FromStatusID != 31 || last.FromStatus != "Open" || last.ToStatusID != 29 {
+		t.Errorf("Expected transition from 31 Open to 29, but got %+v", last)
+	}
+}
+
+func TestISAPIFindDictionary(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/api/status":
+			fmt.Fprint(w, `{"Statuses":[{"Id":27,"Name":"Open"},{"Id":"29","Name":"Closed","IsArchive":false}],
+				"Paginator":{"Count":2}}`)
+		case "/api/taskfield":
+			fmt.Fprint(w, `[{"Id":1101,"Name":"Result","Type":"string"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	api := NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+	cache, err := NewMemoryCache(10)
+	if err != nil {
+		t.Fatalf("Expected success NewMemoryCache, but error %v", err)
+	}
+	repo := NewCachedSDRepo(api, cache, NewCacheTTL(map[string]int{"default": 60}), "test", zap.NewNop().Sugar())
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		statuses, err := repo.FindDictionary(ctx, domain.SDStatuses)
+		if err != nil {
+			t.Fatalf("Expected success FindDictionary, but error %v", err)
+		}
+		if len(statuses) != 2 || statuses.FindByID(29) == nil || statuses.FindByID(29).Name != "Closed" {
+			t.Errorf("Expected statuses 27 and 29, but got %+v", statuses)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected one call to Intraservice, but got %d", calls)
+	}
+	fields, err := repo.FindDictionary(ctx, domain.SDTaskFields)
+	if err != nil || len(fields) != 1 || fields[0].Key != "Field1101" || fields[0].Type != "string" {
+		t.Errorf("Expected field 1101 with key and type, but got %+v, error %v", fields, err)
+	}
+	if _, err = repo.FindDictionary(ctx, domain.SDServices); err == nil {
+		t.Errorf("Expected error of the missing dictionary, but got nil")
+	}
+}
+
+func TestISAPIListPaging(t *testing.T) {
+	const total = 2500
+	pages := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("pagesize"))
+		items := make([]string, 0, size)
+		for id := (page-1)*size + 1; id <= page*size && id <= total; id++ {
+			items = append(items, fmt.Sprintf(`{"Id":%d,"Name":"S%d","Comments":"c%d","StatusId":%d}`, id, id, id, id))
+		}
+		switch r.URL.Path {
+		case "/api/status":
+			fmt.Fprintf(w, `{"Statuses":[%s],"Paginator":{"Count":%d}}`, strings.Join(items, ","), total)
+		case "/api/tasklifetime":
+			// no paginator, the short page is the last one
+			fmt.Fprintf(w, `{"TaskLifetimes":[%s]}`, strings.Join(items, ","))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	api := NewISAPI(srv.URL, "user", "pass", time.Second, zap.NewNop().Sugar())
+	ctx := context.Background()
+	statuses, err := api.FindDictionary(ctx, domain.SDStatuses)
+	if err != nil || len(statuses) != total || statuses.FindByID(total) == nil {
+		t.Errorf("Expected %d statuses of all pages, but got %d, error %v", total, len(statuses), err)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages of the dictionary, but got %d", pages)
+	}
+	pages = 0
+	lifetime, err := api.TaskLifetime(ctx, 7)
+	if err != nil || lifetime == nil {
+		t.Fatalf("Expected lifetime of task 7, but got error %v", err)
+	}
+	if len(lifetime.Comments) != total || len(lifetime.Statuses) != total {
+		t.Errorf("Expected %d records of the lifetime, but got %d comments and %d statuses", total,
+			len(lifetime.Comments), len(lifetime.Statuses))
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages of the lifetime, but got %d", pages)
+	}
+}
+
+func TestTaskSearchParamsNewestFirst(t *testing.T) {
+	params := taskSearchParams("S1", domain.TaskFilter{NewestFirst: true}, 1, 100)
+	if params.Get("sort") != "Id desc" {
+		t.Errorf("Expected sort by id descending, but got %v", params)
+	}
+	if params = taskSearchParams("S1", domain.TaskFilter{}, 1, 100); params.Get("sort") != "" {
+		t.Errorf("Expected default order, but got %v", params)
+	}
+}
@@ -0,0 +1,164 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+
+	_ "github.com/denisenkom/go-mssqldb" // go-mssqldb mssql import
+)
+
+// NewLayoutRepo fabric for LayoutRepo
+func NewLayoutRepo(connString string, timeout time.Duration) (domain.LayoutRepo, error) {
+	if strings.Contains(connString, repoType) {
+		return NewLayoutPGRepo(connString, timeout)
+	}
+	return NewLayoutSQLRepo(connString, timeout)
+}
+
+type LayoutSQLRepo struct {
+	connString string
+	timeout    time.Duration
+	db         *sql.DB
+}
+
+// NewLayoutSQLRepo returns instance of SQLRepo with connected evolution DB,
+// format connection string: "server=study-app;user id=transport;password=transport;port=1433;database=evolution;"
+func NewLayoutSQLRepo(connString string, timeout time.Duration) (*LayoutSQLRepo, error) {
+	lr := &LayoutSQLRepo{
+		connString: connString,
+		timeout:    timeout,
+	}
+	err := lr.connDB()
+	if err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// connDB - make connection to database
+func (lr *LayoutSQLRepo) connDB() error {
+	db, err := sql.Open("mssql", lr.connString)
+	if err != nil {
+		return err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	db.SetMaxOpenConns(dbMaxOpenConnections)
+	db.SetMaxIdleConns(dbMaxIdleConnections)
+	lr.db = db
+	return nil
+}
+
+// Close - close connection to database
+func (lr *LayoutSQLRepo) Close() error {
+	return lr.db.Close()
+}
+
+// Health - check connection to DB
+func (lr *LayoutSQLRepo) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, lr.timeout)
+	defer cancel()
+	row := lr.db.QueryRowContext(ctx, "select @@version as [v]")
+	var tmp string
+	return row.Scan(&tmp)
+}
+
+// FindLayoutIDs returns all layouts with LayoutID only from evolution database
+func (lr *LayoutSQLRepo) FindLayoutIDs(ctx context.Context) (domain.Layouts, error) {
+	query := `SELECT [layout_id] FROM [dbo].[layouts] ORDER BY [layout_id]`
+	ctx, cancel := context.WithTimeout(ctx, lr.timeout)
+	defer cancel()
+	rows, err := lr.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	result := make(domain.Layouts, 0)
+	for rows.Next() {
+		item := domain.Layout{}
+		err = rows.Scan(&item.LayoutID)
+		if err != nil {
+			return nil, fmt.Errorf("query [%s], error %v", query, err)
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// FindLayouts returns slice of Layout with ids from comma separated listIDs from evolution database
+func (lr *LayoutSQLRepo) FindLayouts(ctx context.Context, listIDs string, offset,
+	limit int64) (domain.Layouts, int64, error) {
+	query := `SELECT l.[layout_id]
+				,l.[title]
+				,l.[kind]
+				,COALESCE(l.[crm_customer_id], '')
+				,COALESCE(c.[title], '')
+				,l.[is_active]
+			FROM [dbo].[layouts] l
+			LEFT JOIN [dbo].[crm_customers] c ON c.[crm_customer_id] = l.[crm_customer_id]
+			WHERE l.[layout_id] IN (SELECT [value] FROM STRING_SPLIT(?, ','))
+			ORDER BY l.[layout_id]
+			OFFSET ? ROWS
+			FETCH NEXT ? ROWS ONLY;
+			SELECT count(*) FROM [dbo].[layouts]
+			WHERE [layout_id] IN (SELECT [value] FROM STRING_SPLIT(?, ','))`
+	ctx, cancel := context.WithTimeout(ctx, lr.timeout)
+	defer cancel()
+	rows, err := lr.db.QueryContext(ctx, query, listIDs, offset, limit, listIDs)
+	if err != nil {
+		return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	result := make(domain.Layouts, 0, limit)
+	for rows.Next() {
+		item := domain.Layout{}
+		err = rows.Scan(&item.LayoutID, &item.Title, &item.Kind, &item.Owner.ID, &item.Owner.Title, &item.IsActive)
+		if err != nil {
+			return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+		}
+		result = append(result, item)
+	}
+	var count int64
+	if rows.NextResultSet() {
+		for rows.Next() {
+			err = rows.Scan(&count)
+			if err != nil {
+				return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+			}
+		}
+	}
+	return result, count, nil
+}
+
+// FindLayoutByID returns single layout with specified id from evolution database
+func (lr *LayoutSQLRepo) FindLayoutByID(ctx context.Context, id string) (*domain.Layout, error) {
+	query := `SELECT l.[layout_id]
+				,l.[title]
+				,l.[kind]
+				,COALESCE(l.[crm_customer_id], '')
+				,COALESCE(c.[title], '')
+				,l.[is_active]
+			FROM [dbo].[layouts] l
+			LEFT JOIN [dbo].[crm_customers] c ON c.[crm_customer_id] = l.[crm_customer_id]
+			WHERE l.[layout_id] = ?`
+	ctx, cancel := context.WithTimeout(ctx, lr.timeout)
+	defer cancel()
+	item := domain.Layout{}
+	err := lr.db.QueryRowContext(ctx, query, id).
+		Scan(&item.LayoutID, &item.Title, &item.Kind, &item.Owner.ID, &item.Owner.Title, &item.IsActive)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	return &item, nil
+}
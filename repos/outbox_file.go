@@ -0,0 +1,187 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// FileOutbox implementation of domain.Outbox in the json file, the file is rewritten atomically
+// on every change. Delivered and dead messages are kept for the retention to skip repeated events
+// and for analysis, then they are removed from the file
+type FileOutbox struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	lastID    int64
+	messages  map[int64]domain.OutboxMessage
+	// queued ids of the messages by subscriber and event
+	queued map[string]int64
+}
+
+// outboxState content of the outbox file
+type outboxState struct {
+	LastID   int64                  `json:"lastId"`
+	Messages []domain.OutboxMessage `json:"messages"`
+}
+
+// NewFileOutbox returns outbox with messages loaded from the file, the file is created on first change
+func NewFileOutbox(path string, retention time.Duration) (*FileOutbox, error) {
+	ob := &FileOutbox{path: path, retention: retention, messages: make(map[int64]domain.OutboxMessage),
+		queued: make(map[string]int64)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ob, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read outbox file %s error, %v", path, err)
+	}
+	state := outboxState{}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decode outbox file %s error, %v", path, err)
+	}
+	ob.lastID = state.LastID
+	for _, m := range state.Messages {
+		ob.messages[m.ID] = m
+		ob.queued[outboxKey(m)] = m.ID
+	}
+	return ob, nil
+}
+
+// outboxKey key of the message: subscriber and event
+func outboxKey(m domain.OutboxMessage) string {
+	return m.Subscriber + "\x00" + m.Event.ID
+}
+
+// Enqueue implementation of Outbox interface
+func (ob *FileOutbox) Enqueue(_ context.Context, messages []domain.OutboxMessage) (int, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	lastID := ob.lastID
+	added := make([]int64, 0, len(messages))
+	for i := range messages {
+		key := outboxKey(messages[i])
+		if _, ok := ob.queued[key]; ok {
+			messages[i].ID = 0
+			continue
+		}
+		lastID++
+		messages[i].ID = lastID
+		ob.messages[lastID] = messages[i]
+		ob.queued[key] = lastID
+		added = append(added, lastID)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	if err := ob.save(lastID); err != nil {
+		// messages aren't accepted if they aren't persisted
+		for _, id := range added {
+			delete(ob.queued, outboxKey(ob.messages[id]))
+			delete(ob.messages, id)
+		}
+		for i := range messages {
+			messages[i].ID = 0
+		}
+		return 0, err
+	}
+	ob.lastID = lastID
+	return len(added), nil
+}
+
+// Pending implementation of Outbox interface, messages are ordered by id
+func (ob *FileOutbox) Pending(_ context.Context, before time.Time, limit int) ([]domain.OutboxMessage, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	result := make([]domain.OutboxMessage, 0)
+	for _, m := range ob.messages {
+		if m.Delivered == nil && !m.Dead && !m.NextAttempt.After(before) {
+			result = append(result, m)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// Update implementation of Outbox interface
+func (ob *FileOutbox) Update(_ context.Context, messages []domain.OutboxMessage) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	prev := make([]domain.OutboxMessage, 0, len(messages))
+	restore := func() {
+		for i := len(prev) - 1; i >= 0; i-- {
+			ob.messages[prev[i].ID] = prev[i]
+		}
+	}
+	for _, m := range messages {
+		p, ok := ob.messages[m.ID]
+		if !ok {
+			restore()
+			return fmt.Errorf("outbox message %d not found", m.ID)
+		}
+		prev = append(prev, p)
+		ob.messages[m.ID] = m
+	}
+	if err := ob.save(ob.lastID); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// prune removes delivered and dead messages after the retention
+func (ob *FileOutbox) prune(now time.Time) {
+	for id, m := range ob.messages {
+		finished := m.NextAttempt
+		if m.Delivered != nil {
+			finished = *m.Delivered
+		}
+		if (m.Delivered != nil || m.Dead) && !finished.After(now.Add(-ob.retention)) {
+			delete(ob.queued, outboxKey(m))
+			delete(ob.messages, id)
+		}
+	}
+}
+
+// save writes messages to the temporary file and renames it to the outbox file, expired messages are removed
+func (ob *FileOutbox) save(lastID int64) error {
+	ob.prune(time.Now())
+	state := outboxState{LastID: lastID, Messages: make([]domain.OutboxMessage, 0, len(ob.messages))}
+	for _, m := range ob.messages {
+		state.Messages = append(state.Messages, m)
+	}
+	sort.Slice(state.Messages, func(i, j int) bool { return state.Messages[i].ID < state.Messages[j].ID })
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode outbox error, %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(ob.path), filepath.Base(ob.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create outbox file error, %v", err)
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ob.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write outbox file %s error, %v", ob.path, err)
+	}
+	return nil
+}
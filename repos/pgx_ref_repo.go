@@ -51,35 +51,63 @@ func (rpr *RefPGSQLRepo) Close() {
 }
 
 // Health - check connection to DB
-func (rpr *RefPGSQLRepo) Health() error {
-	ctx, cancel := context.WithTimeout(context.Background(), rpr.timeout)
+func (rpr *RefPGSQLRepo) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rpr.timeout)
 	defer cancel()
 	var tmp string
 	return rpr.db.QueryRow(ctx, "SELECT version();").Scan(&tmp)
 }
 
+// pgEntityColumns fields of the entity for filtering and sorting
+var pgEntityColumns = listColumns{
+	fields: map[string]string{
+		"id":          "entity_id",
+		"description": "description",
+	},
+	search: []string{"id", "description"},
+	key:    "id",
+}
+
 // FindEntities returns slice of Entity from evolution database
-func (rpr *RefPGSQLRepo) FindEntities(offset, limit int64) (domain.Entities, int64, error) {
-	query := "select entity_id, description from entities limit $1 offset $2;"
+func (rpr *RefPGSQLRepo) FindEntities(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.Entities, int64, error) {
+	ls, err := buildListSQL(dialectPG, pgEntityColumns, q, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	nArgs := len(ls.args) + len(ls.afterArgs)
+	query := fmt.Sprintf("select entity_id, description from entities where true%s%s order by %s limit $%d offset $%d;",
+		ls.where, ls.after, ls.order, nArgs+1, nArgs+2)
+	args := make([]interface{}, 0, nArgs+2)
+	args = append(args, ls.args...)
+	args = append(args, ls.afterArgs...)
 	batch := &pgx.Batch{}
-	batch.Queue(query, limit, offset)
-	batch.Queue("select count(*) from entities")
-	ctx, cancel := context.WithTimeout(context.Background(), rpr.timeout)
+	batch.Queue(query, append(args, limit, offset)...)
+	if !q.NoCount {
+		batch.Queue("select count(*) from entities where true"+ls.where, ls.args...)
+	}
+	ctx, cancel := context.WithTimeout(ctx, rpr.timeout)
 	defer cancel()
 	batchRes := rpr.db.SendBatch(ctx, batch)
+	defer batchRes.Close()
 	rows, err := batchRes.Query()
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("query [%s], error %v", query, err)
 	}
 	result := make(domain.Entities, 0, limit)
 	for rows.Next() {
 		item := domain.Entity{}
 		err = rows.Scan(&item.ID, &item.Description)
 		if err != nil {
+			rows.Close()
 			return nil, 0, err
 		}
 		result = append(result, item)
 	}
+	rows.Close()
+	if q.NoCount {
+		return result, -1, nil
+	}
 	var count int64
 	err = batchRes.QueryRow().Scan(&count)
 	if err != nil {
@@ -89,9 +117,9 @@ func (rpr *RefPGSQLRepo) FindEntities(offset, limit int64) (domain.Entities, int
 }
 
 // FindEntityByID returns single entity with specified id from evolution database
-func (rpr *RefPGSQLRepo) FindEntityByID(id string) (*domain.Entity, error) {
+func (rpr *RefPGSQLRepo) FindEntityByID(ctx context.Context, id string) (*domain.Entity, error) {
 	query := `select entity_id, description from entities where entity_id=$1`
-	ctx, cancel := context.WithTimeout(context.Background(), rpr.timeout)
+	ctx, cancel := context.WithTimeout(ctx, rpr.timeout)
 	defer cancel()
 	item := domain.Entity{}
 	err := rpr.db.QueryRow(ctx, query, id).Scan(&item.ID, &item.Description)
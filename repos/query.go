@@ -0,0 +1,226 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+// sqlDialect kind of the database, defines placeholders and case insensitive search
+type sqlDialect int
+
+const (
+	dialectMSSQL sqlDialect = iota
+	dialectPG
+)
+
+// sqlOps sql operators of the filter operators
+var sqlOps = map[string]string{
+	domain.OpEq:  "=",
+	domain.OpNe:  "<>",
+	domain.OpGt:  ">",
+	domain.OpGte: ">=",
+	domain.OpLt:  "<",
+	domain.OpLte: "<=",
+}
+
+// listColumns sql expressions of the resource fields for filtering and sorting
+type listColumns struct {
+	// fields json name of the field -> sql expression
+	fields map[string]string
+	// search json names of the fields for the free-text search
+	search []string
+	// key json name of the unique field, added to the end of sorting for the stable paging
+	key string
+	// times json names of the datetime fields, their values are bound in dbTimeLayout of the local time
+	times []string
+}
+
+// listTimeLayouts formats of the values of the datetime fields, without zone they are in the local time
+var listTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// arg returns argument of the value of the field of the filter or the cursor
+func (cols listColumns) arg(field, value string) (interface{}, error) {
+	for _, name := range cols.times {
+		if name != field {
+			continue
+		}
+		for _, layout := range listTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				return t.In(time.Local).Format(dbTimeLayout), nil
+			}
+		}
+		return nil, fmt.Errorf("wrong value %s of the field %s, RFC3339 time required", value, field)
+	}
+	return value, nil
+}
+
+// listSQL conditions and order of the list query
+type listSQL struct {
+	// where conditions, starts with " AND " if not empty
+	where string
+	// order expressions for ORDER BY
+	order string
+	args  []interface{}
+	// after keyset condition of the page, starts with " AND " if not empty,
+	// it isn't applied to the total count
+	after     string
+	afterArgs []interface{}
+}
+
+// escapeLike escapes wildcards of the LIKE pattern, escape character is backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// buildListSQL makes conditions and order of the query, argOffset is count of arguments before conditions
+// (used for numbered placeholders of the postgres)
+func buildListSQL(d sqlDialect, cols listColumns, q domain.ListQuery, argOffset int) (listSQL, error) {
+	res := listSQL{args: make([]interface{}, 0, len(q.Filters)+len(cols.search))}
+	placeholder := func() string {
+		return placeholderN(d, argOffset+len(res.args))
+	}
+	like := func(expr string) string {
+		if d == dialectPG {
+			return fmt.Sprintf(`%s::text ILIKE %s ESCAPE '\'`, expr, placeholder())
+		}
+		return fmt.Sprintf(`%s LIKE %s ESCAPE '\'`, expr, placeholder())
+	}
+	var where strings.Builder
+	for _, f := range q.Filters {
+		expr, ok := cols.fields[f.Field]
+		if !ok {
+			return res, fmt.Errorf("filter by field %s not allowed", f.Field)
+		}
+		if f.Op == domain.OpLike {
+			res.args = append(res.args, "%"+escapeLike(f.Value)+"%")
+			where.WriteString(" AND " + like(expr))
+			continue
+		}
+		op, ok := sqlOps[f.Op]
+		if !ok {
+			return res, fmt.Errorf("filter operator %s not allowed", f.Op)
+		}
+		arg, err := cols.arg(f.Field, f.Value)
+		if err != nil {
+			return res, err
+		}
+		res.args = append(res.args, arg)
+		where.WriteString(fmt.Sprintf(" AND %s %s %s", expr, op, placeholder()))
+	}
+	if q.Search != "" && len(cols.search) > 0 {
+		conds := make([]string, 0, len(cols.search))
+		for _, field := range cols.search {
+			res.args = append(res.args, "%"+escapeLike(q.Search)+"%")
+			conds = append(conds, like(cols.fields[field]))
+		}
+		where.WriteString(" AND (" + strings.Join(conds, " OR ") + ")")
+	}
+	res.where = where.String()
+
+	sortFields := q.SortFields(cols.key)
+	order := make([]string, 0, len(sortFields))
+	for _, s := range sortFields {
+		expr, ok := cols.fields[s.Field]
+		if !ok {
+			return res, fmt.Errorf("sort by field %s not allowed", s.Field)
+		}
+		if s.Desc {
+			order = append(order, expr+" DESC")
+			continue
+		}
+		order = append(order, expr+" ASC")
+	}
+	res.order = strings.Join(order, ", ")
+	if len(q.After) == 0 {
+		return res, nil
+	}
+	if len(q.After) != len(sortFields) {
+		return res, fmt.Errorf("cursor has %d values, expected %d", len(q.After), len(sortFields))
+	}
+	// (f1 > v1 OR (f1 = v1 AND f2 > v2) OR ...), < for the descending fields
+	argsCount := len(res.args)
+	after := make([]interface{}, len(sortFields))
+	for i, s := range sortFields {
+		arg, err := cols.arg(s.Field, q.After[i])
+		if err != nil {
+			return res, err
+		}
+		after[i] = arg
+	}
+	conds := make([]string, 0, len(sortFields))
+	for i, s := range sortFields {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			res.afterArgs = append(res.afterArgs, after[j])
+			parts = append(parts, fmt.Sprintf("%s = %s", cols.fields[sortFields[j].Field],
+				placeholderN(d, argOffset+argsCount+len(res.afterArgs))))
+		}
+		op := ">"
+		if s.Desc {
+			op = "<"
+		}
+		res.afterArgs = append(res.afterArgs, after[i])
+		parts = append(parts, fmt.Sprintf("%s %s %s", cols.fields[s.Field], op,
+			placeholderN(d, argOffset+argsCount+len(res.afterArgs))))
+		conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+	}
+	res.after = " AND (" + strings.Join(conds, " OR ") + ")"
+	return res, nil
+}
+
+// placeholderN placeholder of the n-th argument of the query
+func placeholderN(d sqlDialect, n int) string {
+	if d == dialectPG {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// mssqlPage executes paged query with count of all rows in the one batch,
+// from contains FROM with joins, where - base conditions of the resource.
+// Count is -1 if the query has NoCount
+func mssqlPage(ctx context.Context, db *sql.DB, columns, from, where string, whereArgs []interface{},
+	ls listSQL, offset, limit int64, noCount bool, scan func(*sql.Rows) error) (int64, error) {
+	query := columns + " " + from + " WHERE " + where + ls.where + ls.after + `
+			ORDER BY ` + ls.order + `
+			OFFSET ? ROWS
+			FETCH NEXT ? ROWS ONLY;`
+	args := make([]interface{}, 0, 2*(len(whereArgs)+len(ls.args))+len(ls.afterArgs)+2)
+	args = append(args, whereArgs...)
+	args = append(args, ls.args...)
+	args = append(args, ls.afterArgs...)
+	args = append(args, offset, limit)
+	if !noCount {
+		query += `
+			SELECT count(*) ` + from + " WHERE " + where + ls.where
+		args = append(args, whereArgs...)
+		args = append(args, ls.args...)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return -1, fmt.Errorf("query [%s], error %v", query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return -1, fmt.Errorf("query [%s], error %v", query, err)
+		}
+	}
+	if noCount {
+		return -1, rows.Err()
+	}
+	var count int64
+	if rows.NextResultSet() {
+		for rows.Next() {
+			if err = rows.Scan(&count); err != nil {
+				return -1, fmt.Errorf("query [%s], error %v", query, err)
+			}
+		}
+	}
+	return count, rows.Err()
+}
@@ -0,0 +1,133 @@
+package repos
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.countmax.ru/countmax/commonapi/domain"
+)
+
+func TestBuildListSQL(t *testing.T) {
+	cols := listColumns{
+		fields: map[string]string{"id": "[id]", "name": "[name]", "managerId": "[manager_id]"},
+		search: []string{"name"},
+		key:    "id",
+	}
+	tCases := []struct {
+		title   string
+		dialect sqlDialect
+		query   domain.ListQuery
+		where   string
+		order   string
+		args    []interface{}
+		after   string
+		aArgs   []interface{}
+		isErr   bool
+	}{
+		{title: "empty", dialect: dialectMSSQL, order: "[id] ASC", args: []interface{}{}},
+		{title: "mssql filters", dialect: dialectMSSQL,
+			query: domain.ListQuery{
+				Filters: []domain.Filter{{Field: "managerId", Op: domain.OpEq, Value: "5"},
+					{Field: "id", Op: domain.OpGte, Value: "100"}},
+				Search: "50%_mall",
+				Sort:   []domain.SortField{{Field: "name", Desc: true}},
+			},
+			where: ` AND [manager_id] = ? AND [id] >= ? AND ([name] LIKE ? ESCAPE '\')`,
+			order: "[name] DESC, [id] ASC",
+			args:  []interface{}{"5", "100", `%50\%\_mall%`}},
+		{title: "pg placeholders", dialect: dialectPG,
+			query: domain.ListQuery{
+				Filters: []domain.Filter{{Field: "name", Op: domain.OpLike, Value: "mall"}},
+				Search:  "x",
+				Sort:    []domain.SortField{{Field: "id", Desc: true}},
+			},
+			where: ` AND [name]::text ILIKE $1 ESCAPE '\' AND ([name]::text ILIKE $2 ESCAPE '\')`,
+			order: "[id] DESC",
+			args:  []interface{}{"%mall%", "%x%"}},
+		{title: "mssql keyset", dialect: dialectMSSQL,
+			query: domain.ListQuery{
+				Sort:  []domain.SortField{{Field: "name", Desc: true}},
+				After: []string{"mall", "7"},
+			},
+			order: "[name] DESC, [id] ASC",
+			args:  []interface{}{},
+			after: " AND (([name] < ?) OR ([name] = ? AND [id] > ?))",
+			aArgs: []interface{}{"mall", "mall", "7"}},
+		{title: "pg keyset after filters", dialect: dialectPG,
+			query: domain.ListQuery{
+				Filters: []domain.Filter{{Field: "managerId", Op: domain.OpEq, Value: "5"}},
+				After:   []string{"7"},
+			},
+			where: " AND [manager_id] = $1",
+			order: "[id] ASC",
+			args:  []interface{}{"5"},
+			after: " AND (([id] > $2))",
+			aArgs: []interface{}{"7"}},
+		{title: "cursor mismatch", dialect: dialectMSSQL, isErr: true,
+			query: domain.ListQuery{Sort: []domain.SortField{{Field: "name"}}, After: []string{"7"}}},
+		{title: "unknown field", dialect: dialectMSSQL, isErr: true,
+			query: domain.ListQuery{Filters: []domain.Filter{{Field: "password", Op: domain.OpEq, Value: "1"}}}},
+		{title: "unknown sort", dialect: dialectMSSQL, isErr: true,
+			query: domain.ListQuery{Sort: []domain.SortField{{Field: "password"}}}},
+		{title: "unknown op", dialect: dialectMSSQL, isErr: true,
+			query: domain.ListQuery{Filters: []domain.Filter{{Field: "id", Op: "in", Value: "1"}}}},
+	}
+	for _, tc := range tCases {
+		t.Run(tc.title, func(t *testing.T) {
+			ls, err := buildListSQL(tc.dialect, cols, tc.query, 0)
+			if tc.isErr {
+				if err == nil {
+					t.Errorf("Expected error, but got where=%s", ls.where)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected success buildListSQL, but error %v", err)
+			}
+			if ls.where != tc.where {
+				t.Errorf("Expected where=%s, but got %s", tc.where, ls.where)
+			}
+			if ls.order != tc.order {
+				t.Errorf("Expected order=%s, but got %s", tc.order, ls.order)
+			}
+			if !reflect.DeepEqual(ls.args, tc.args) {
+				t.Errorf("Expected args=%v, but got %v", tc.args, ls.args)
+			}
+			if ls.after != tc.after {
+				t.Errorf("Expected after=%s, but got %s", tc.after, ls.after)
+			}
+			if !reflect.DeepEqual(ls.afterArgs, tc.aArgs) {
+				t.Errorf("Expected after args=%v, but got %v", tc.aArgs, ls.afterArgs)
+			}
+		})
+	}
+}
+
+func TestBuildListSQLTimeCursor(t *testing.T) {
+	// cursor of the page sorted by changed has values of the last asset in json
+	changed := time.Date(2021, 3, 4, 10, 11, 12, 3333333, time.Local)
+	data, _ := json.Marshal(domain.Asset{Changed: changed, ServiceDeskID: 5})
+	last := struct {
+		Changed string `json:"changed"`
+	}{}
+	_ = json.Unmarshal(data, &last)
+	q := domain.ListQuery{Sort: []domain.SortField{{Field: "changed"}}, After: []string{last.Changed, "5"},
+		Filters: []domain.Filter{{Field: "changed", Op: domain.OpGte, Value: "2021-03-01"}}}
+	ls, err := buildListSQL(dialectMSSQL, assetColumns, q, 0)
+	if err != nil {
+		t.Fatalf("Expected success buildListSQL, but error %v", err)
+	}
+	expected := []interface{}{"2021-03-04T10:11:12.003", "2021-03-04T10:11:12.003", "5"}
+	if !reflect.DeepEqual(ls.afterArgs, expected) {
+		t.Errorf("Expected after args=%v, but got %v", expected, ls.afterArgs)
+	}
+	if !reflect.DeepEqual(ls.args, []interface{}{"2021-03-01T00:00:00.000"}) {
+		t.Errorf("Expected filter by local time, but got %v", ls.args)
+	}
+	q = domain.ListQuery{Filters: []domain.Filter{{Field: "changed", Op: domain.OpGt, Value: "yesterday"}}}
+	if _, err = buildListSQL(dialectMSSQL, assetColumns, q, 0); err == nil {
+		t.Errorf("Expected error of the wrong time, but got nil")
+	}
+}
@@ -67,64 +67,55 @@ func (rer *RefSQLRepo) Close() error {
 }
 
 // Health - check connection to DB
-func (rer *RefSQLRepo) Health() error {
-	ctx, cancel := context.WithTimeout(context.Background(), rer.timeout)
+func (rer *RefSQLRepo) Health(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rer.timeout)
 	defer cancel()
 	row := rer.db.QueryRowContext(ctx, "select @@version as [v]")
 	var tmp string
 	return row.Scan(&tmp)
 }
 
+// entityColumns fields of the entity for filtering and sorting
+var entityColumns = listColumns{
+	fields: map[string]string{
+		"id":          "[id]",
+		"description": "[description]",
+	},
+	search: []string{"id", "description"},
+	key:    "id",
+}
+
 // FindEntities returns slice of Entity from evolution database
-func (rer *RefSQLRepo) FindEntities(offset, limit int64) (domain.Entities, int64, error) {
-	query := `SELECT  [id]
-				,[description]
-			FROM [dbo].[entities]
-			ORDER BY [id]
-			OFFSET ? ROWS 
-			FETCH NEXT ? ROWS ONLY;
-			SELECT count(*) FROM [dbo].[entities]`
-	ctx, cancel := context.WithTimeout(context.Background(), rer.timeout)
-	defer cancel()
-	rows, err := rer.db.QueryContext(ctx, query, offset, limit)
+func (rer *RefSQLRepo) FindEntities(ctx context.Context, q domain.ListQuery, offset,
+	limit int64) (domain.Entities, int64, error) {
+	ls, err := buildListSQL(dialectMSSQL, entityColumns, q, 0)
 	if err != nil {
-		return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+		return nil, -1, err
 	}
-	defer rows.Close()
+	ctx, cancel := context.WithTimeout(ctx, rer.timeout)
+	defer cancel()
 	result := make(domain.Entities, 0, limit)
-	for rows.Next() {
-		item := domain.Entity{}
-		err = rows.Scan(&item.ID, &item.Description)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return nil, -1, nil
-			}
-			return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
-		}
-		result = append(result, item)
-	}
-	var count int64
-	if rows.NextResultSet() {
-		for rows.Next() {
-			err = rows.Scan(&count)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					return result, -1, nil
-				}
-				return nil, -1, fmt.Errorf("query [%s], error %v", query, err)
+	count, err := mssqlPage(ctx, rer.db, "SELECT [id], [description]", "FROM [dbo].[entities]", "1 = 1", nil,
+		ls, offset, limit, q.NoCount, func(rows *sql.Rows) error {
+			item := domain.Entity{}
+			if err := rows.Scan(&item.ID, &item.Description); err != nil {
+				return err
 			}
-
-		}
+			result = append(result, item)
+			return nil
+		})
+	if err != nil {
+		return nil, -1, err
 	}
 	return result, count, nil
 }
 
 // FindEntityByID returns single entity with specified id from evolution database
-func (rer *RefSQLRepo) FindEntityByID(id string) (*domain.Entity, error) {
+func (rer *RefSQLRepo) FindEntityByID(ctx context.Context, id string) (*domain.Entity, error) {
 	query := `SELECT  [id]
 				,[description]
 			FROM [dbo].[entities] where id=?`
-	ctx, cancel := context.WithTimeout(context.Background(), rer.timeout)
+	ctx, cancel := context.WithTimeout(ctx, rer.timeout)
 	defer cancel()
 	item := domain.Entity{}
 	err := rer.db.QueryRowContext(ctx, query, id).Scan(&item.ID, &item.Description)
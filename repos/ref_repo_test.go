@@ -1,9 +1,11 @@
 package repos
 
 import (
+	"context"
 	"testing"
 	"time"
-	//"git.countmax.ru/countmax/commonapi/domain"
+
+	"git.countmax.ru/countmax/commonapi/domain"
 	// "go.uber.org/zap"
 	// "go.uber.org/zap/zapcore"
 )
@@ -18,7 +20,7 @@ func TestRefHealth(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected success make RefSQLRepo, but error, %v", err)
 	}
-	err = tr.Health()
+	err = tr.Health(context.Background())
 	if err != nil {
 		t.Errorf("Expected success tr.Health(), but error %v", err)
 	}
@@ -29,7 +31,7 @@ func TestFindEntities(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected success make RefSQLRepo, but error, %v", err)
 	}
-	entities, count, err := tr.FindEntities(1, 1)
+	entities, count, err := tr.FindEntities(context.Background(), domain.ListQuery{}, 1, 1)
 	if err != nil {
 		t.Errorf("Expected success tr.FindEntities(), but error %v", err)
 	}
@@ -50,7 +52,7 @@ func TestFindEntityByID(t *testing.T) {
 		t.Errorf("Expected success make RefSQLRepo, but error, %v", err)
 	}
 	id := "chain"
-	entity, err := tr.FindEntityByID(id)
+	entity, err := tr.FindEntityByID(context.Background(), id)
 	if err != nil {
 		t.Errorf("Expected success tr.FindEntityByID(), but error %v", err)
 	}